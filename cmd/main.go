@@ -10,11 +10,21 @@ import (
 	_ "github.com/tiny-systems/encoding-module/components/csv/encode"
 	_ "github.com/tiny-systems/encoding-module/components/gotemplate"
 	_ "github.com/tiny-systems/encoding-module/components/json/decode"
+	_ "github.com/tiny-systems/encoding-module/components/json/diff"
 	_ "github.com/tiny-systems/encoding-module/components/json/encode"
+	_ "github.com/tiny-systems/encoding-module/components/json/flatten"
+	_ "github.com/tiny-systems/encoding-module/components/json/merge"
+	_ "github.com/tiny-systems/encoding-module/components/json/patch"
+	_ "github.com/tiny-systems/encoding-module/components/json/validate"
 	_ "github.com/tiny-systems/encoding-module/components/jwt/encode"
 	_ "github.com/tiny-systems/encoding-module/components/jwt/verify"
 	_ "github.com/tiny-systems/encoding-module/components/textchunk"
+	_ "github.com/tiny-systems/encoding-module/components/xml/decode"
+	_ "github.com/tiny-systems/encoding-module/components/xml/dsig"
 	_ "github.com/tiny-systems/encoding-module/components/xml/encode"
+	_ "github.com/tiny-systems/encoding-module/components/xml/feed"
+	_ "github.com/tiny-systems/encoding-module/components/xml/query"
+	_ "github.com/tiny-systems/encoding-module/components/xml/soap"
 	"github.com/tiny-systems/module/cli"
 	"os"
 	"os/signal"
@@ -1,10 +1,16 @@
 package decode
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/infer"
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
 	"github.com/tiny-systems/module/api/v1alpha1"
 	"github.com/tiny-systems/module/module"
 	"github.com/tiny-systems/module/registry"
@@ -15,6 +21,16 @@ const (
 	RequestPort   = "request"
 	ResponsePort  = "response"
 	ErrorPort     = "error"
+	SummaryPort   = "summary"
+
+	ModeDocument = "document"
+	ModeLines    = "lines"
+	ModeStream   = "stream"
+
+	defaultMaxDepth = 64
+	// lenientMaxDepth bounds the lenient dialects when strictSyntax is off,
+	// as the regular decoder bounds strict JSON.
+	lenientMaxDepth = 10000
 )
 
 type Context any
@@ -24,11 +40,48 @@ type Decoded any
 type Settings struct {
 	EnableErrorPort bool    `json:"enableErrorPort" required:"true" title:"Enable Error Port" description:"If error happen, error port will emit an error message"`
 	Decoded         Decoded `json:"decoded" configurable:"true" title:"Decoded shape" description:"Schema and example of the decoded JSON. Downstream edges from this node will be validated against this shape."`
+
+	// Nothing is unwrapped by default: a string that happens to parse as JSON
+	// is not necessarily a payload, and guessing wrong changes a field's type
+	// under every expression that reads it.
+	UnwrapPaths []string `json:"unwrapPaths" title:"Unwrap Paths" description:"JSON Pointers to string fields that carry JSON encoded as a string, such as /payload or /events/*/body (* matches every key or element). Each is replaced by the structure it encodes, so one json_decode does what used to take a chain of them. A path the document does not have is skipped."`
+	UnwrapAll   bool     `json:"unwrapAll" title:"Unwrap Everywhere" description:"Replace every string that decodes as a JSON object or array, wherever it is. Use it when the encoded fields are not known in advance — a free-text field that happens to hold valid JSON is unwrapped too."`
+	UnwrapDepth int      `json:"unwrapDepth" default:"3" title:"Unwrap Depth" description:"The most layers of string encoding peeled along any one path, for senders that encode an already encoded payload."`
+
+	Select string `json:"select" title:"Select" description:"Emit only part of the document: a JSON Pointer such as /data/items, or a simple JSONPath such as $.data.items[0]. The decoded shape then describes the selected value. A path the document does not have is an error, not a null."`
+
+	// Off by default: an example written before this setting existed was only
+	// ever a hint, and turning it into a contract would start failing flows
+	// whose payloads differ from it in ways nobody minded.
+	EnforceShape bool `json:"enforceShape" title:"Enforce Decoded Shape" description:"Check every decoded document against the decoded shape: keys the example names must be present with the same type, and array elements must look like the example's first element. A document that does not conform fails, listing each offending path, instead of flowing on and resolving to null two nodes later."`
+
+	Mode       string `json:"mode" default:"document" enum:"document,lines,stream" enumTitles:"One JSON document|JSON Lines (one value per line)|Stream a top-level array" title:"Mode" description:"Lines reads newline-delimited JSON (NDJSON) or an RFC 7464 JSON text sequence, decoding each record on its own: the output is records, and a line that does not decode is listed in errors instead of failing the batch. Stream reads a top-level array one element at a time and emits each as its own message, then a summary on the summary port — for exports too large to hold twice, in strict JSON only. In both, the decoded shape describes one record."`
+	MaxRecords int    `json:"maxRecords" default:"10000" title:"Max Records" description:"Lines and stream modes. Ceiling on records from one message. Reaching it sets truncated."`
+
+	// Strict stays the default: a payload that only parses leniently is
+	// usually a mistake upstream, and accepting it quietly would hide that.
+	Dialect string `json:"dialect" default:"strict" enum:"strict,jsonc,json5" enumTitles:"Strict JSON (RFC 8259)|JSONC (comments, trailing commas)|JSON5" title:"Dialect" description:"Strict accepts only standard JSON. JSONC also takes // and /* */ comments and trailing commas, as in VS Code and tsconfig files. JSON5 adds single-quoted strings, unquoted keys, hex numbers and the other relaxations of hand-written config. Errors report the line and column either way. Stream mode takes strict only."`
+
+	// Off by default because the regular decoder is faster and every existing
+	// flow already depends on what it accepts. The cases it lets through are
+	// the ones that matter when two parties must read a payload identically.
+	StrictSyntax bool `json:"strictSyntax" title:"Reject Ambiguous JSON" description:"Refuse a repeated object key (otherwise the last one silently wins, while other parsers keep the first), content after the top-level value, invalid UTF-8, unpaired \\u surrogates, and nesting deeper than maxDepth. Each refusal names the offending path. Turn it on for signed or security-relevant payloads."`
+	MaxDepth     int  `json:"maxDepth" default:"64" title:"Max Depth" description:"With strictSyntax on, the deepest nesting of objects and arrays accepted. With it off, nesting stops at 10000 levels."`
+
+	// Float stays the default because it is what every existing flow was built
+	// against, and a number turning into a string would break their
+	// comparisons. The cost is the one this setting exists to name.
+	Numbers string `json:"numbers" default:"float" enum:"float,string,int64,decimal" enumTitles:"Float (rounds past 2^53)|String|Integer when it fits|Exact decimal" title:"Numbers" description:"How numbers are decoded. Float rounds any integer past 9007199254740992, so a 64-bit id or a large money amount silently changes and a lookup on it fails later. String keeps the exact text. Integer keeps whole numbers that fit in 64 bits as integers and everything else as an exact decimal. Exact decimal keeps every number as the literal the sender wrote, and json_encode writes it back unchanged."`
+
+	LearnShape   bool `json:"learnShape" title:"Learn Shape" description:"Watch the first learnSamples decoded values and emit, once, a proposed decoded example and JSON Schema on the shape port — covering optional fields, fields that change type and what array elements look like. Also adds a learn port that takes a pasted corpus of samples and answers the same way. Adopt the example into decoded; the node carries on decoding either way."`
+	LearnSamples int  `json:"learnSamples" default:"20" title:"Learn Samples" description:"How many decoded values learnShape watches before proposing a shape. More samples catch more optional fields."`
 }
 
 type Error struct {
-	Context Context `json:"context"`
-	Error   string  `json:"error"`
+	Context    Context     `json:"context"`
+	Error      string      `json:"error"`
+	Violations []Violation `json:"violations,omitempty" title:"Violations" description:"Set when enforceShape rejected the document — one entry per path that departs from the decoded shape."`
+	Path       string      `json:"path,omitempty" title:"Path" description:"Set when strictSyntax rejected the document — a JSON Pointer to where."`
 }
 
 type Request struct {
@@ -43,6 +96,12 @@ type Output struct {
 
 type Component struct {
 	settings Settings
+
+	// learner sees decoded values until the shape is learned; learnMu guards
+	// both, since messages are handled concurrently.
+	learnMu sync.Mutex
+	learner infer.Learner
+	learned bool
 }
 
 func (h *Component) GetInfo() module.ComponentInfo {
@@ -51,7 +110,15 @@ func (h *Component) GetInfo() module.ComponentInfo {
 		Description: "JSON Decoder",
 		Info: "Parses a JSON string into data the rest of the flow can read. " +
 			"SET THE `decoded` SETTING to an example of the JSON you expect — a string has no shape, so without one every downstream edge is unverifiable: an expression like {{$.decoded.user.id}} is accepted when the flow is built and resolves to null at runtime. With an example, the same mistake is caught immediately. Only the shape matters, not the values; one representative object is enough, and for a list one representative element. " +
-			"When the payload carries a list of items, wire array_split after this so each item arrives as its own message instead of every downstream node looping. " +
+			"Turn on enforceShape to make the example a contract checked at runtime, so a payload missing a field the flow reads fails here, naming the path, instead of flowing on as null. " +
+			"For log shipper output or a bulk export with one JSON value per line, set mode to lines. " +
+			"When the data sits inside an envelope such as {\"data\":{\"items\":[...]}}, set select to /data/items so the output is the part the flow reads. " +
+			"When a field holds JSON encoded as a string — common in webhooks and queue messages — list it in unwrapPaths instead of chaining another json_decode. " +
+			"For hand-edited config with comments or trailing commas, set dialect to jsonc or json5 instead of cleaning it up in js_eval first. " +
+			"For signed or security-relevant payloads, turn on strictSyntax so a repeated key or trailing content is refused rather than quietly resolved. " +
+			"If the JSON carries 64-bit ids or large amounts, set numbers to int64 or decimal — the default float silently rounds anything past 2^53. " +
+			"When the payload carries a list of items, wire array_split after this so each item arrives as its own message instead of every downstream node looping — or, for a very large top-level array, set mode to stream, which emits each element as it is read and a summary when the array ends. " +
+			"Not sure what the payloads look like? Turn on learnShape and it proposes a decoded example and a JSON Schema from the first payloads it sees, or from samples sent to its learn port. " +
 			"Some senders report that they truncated a batch, in a field alongside it. Include that field in the example and check it, or a batch that silently dropped items reads as the complete set.",
 		Tags: []string{"json"},
 	}
@@ -66,11 +133,22 @@ func (h *Component) OnSettings(_ context.Context, msg any) error {
 		return fmt.Errorf("invalid settings")
 	}
 	h.settings = in
+
+	h.learnMu.Lock()
+	h.learner, h.learned = infer.Learner{}, false
+	h.learnMu.Unlock()
 	return nil
 }
 
 // Handle dispatches the RequestPort. System ports go through capabilities.
 func (h *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port == LearnPort && h.settings.LearnShape {
+		in, ok := msg.(LearnRequest)
+		if !ok {
+			return module.Fail(fmt.Errorf("invalid input"))
+		}
+		return h.handleLearn(ctx, handler, in)
+	}
 	if port != RequestPort {
 		return module.Fail(fmt.Errorf("unknown port: %s", port))
 	}
@@ -81,23 +159,129 @@ func (h *Component) Handle(ctx context.Context, handler module.Handler, port str
 		return module.Fail(fmt.Errorf("invalid input"))
 	}
 
-	var res Decoded
+	switch h.settings.Mode {
+	case ModeLines:
+		return h.handleLines(ctx, handler, in)
+	case ModeStream:
+		return h.handleStream(ctx, handler, in)
+	}
 
-	err := json.Unmarshal([]byte(in.Encoded), &res)
+	res, err := h.decode([]byte(in.Encoded))
 	if err != nil {
-		if !h.settings.EnableErrorPort {
-			return module.Fail(err)
-		}
-		return handler(ctx, ErrorPort, Error{
-			Context: in.Context,
-			Error:   err.Error(),
-		})
+		return h.handleError(ctx, handler, in.Context, err)
 	}
 
-	return handler(ctx, ResponsePort, Output{
+	return h.respond(ctx, handler, in.Context, Output{
 		Context: in.Context,
 		Decoded: res,
-	})
+	}, res)
+}
+
+// decode takes one document from bytes to the value the response port
+// carries: parsed, unwrapped, narrowed to the selection, and checked against
+// the shape.
+func (h *Component) decode(data []byte) (any, error) {
+	res, err := h.unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.settings.UnwrapAll || len(h.settings.UnwrapPaths) > 0 {
+		if res, err = h.unwrap(res); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.settings.Select != "" {
+		tokens, err := pointer.Compile(h.settings.Select)
+		if err != nil {
+			return nil, err
+		}
+		if res, err = pointer.Get(res, tokens); err != nil {
+			return nil, fmt.Errorf("select %s: %w", h.settings.Select, err)
+		}
+	}
+
+	if h.settings.EnforceShape {
+		if violations := conform(h.settings.Decoded, res, "", nil); len(violations) > 0 {
+			return nil, &shapeError{violations: violations}
+		}
+	}
+	return res, nil
+}
+
+// unmarshal is the one place bytes become a value, so every mode decodes the
+// same way.
+func (h *Component) unmarshal(data []byte) (any, error) {
+	dialect := h.settings.Dialect
+	if dialect == "" {
+		dialect = DialectStrict
+	}
+	if dialect != DialectStrict || h.settings.StrictSyntax {
+		maxDepth := h.settings.MaxDepth
+		switch {
+		case !h.settings.StrictSyntax:
+			maxDepth = lenientMaxDepth
+		case maxDepth <= 0:
+			maxDepth = defaultMaxDepth
+		}
+		res, err := parse(data, dialect, h.settings.StrictSyntax, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		return numbers(res, h.settings.Numbers), nil
+	}
+
+	var res Decoded
+	if h.settings.Numbers == "" || h.settings.Numbers == NumbersFloat {
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, located(data, err)
+		}
+		return res, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&res); err != nil {
+		return nil, located(data, err)
+	}
+	// A decoder stops after one value where Unmarshal refuses what follows;
+	// asking for a second keeps the two paths accepting the same input.
+	var extra any
+	if err := dec.Decode(&extra); err != io.EOF {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return numbers(res, h.settings.Numbers), nil
+}
+
+// located adds the line and column to a syntax error, which the decoder only
+// reports as a byte offset — useless against a payload of any size.
+func located(data []byte, err error) error {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return err
+	}
+	line, col := position(data, int(syntax.Offset))
+	return fmt.Errorf("line %d, column %d: %w", line, col, err)
+}
+
+func (h *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !h.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	out := Error{
+		Context: reqCtx,
+		Error:   err.Error(),
+	}
+	var shape *shapeError
+	if errors.As(err, &shape) {
+		out.Violations = shape.violations
+	}
+	var syntax *syntaxError
+	if errors.As(err, &syntax) {
+		out.Path = syntax.path
+	}
+	return handler(ctx, ErrorPort, out)
 }
 
 func (h *Component) Ports() []module.Port {
@@ -109,13 +293,11 @@ func (h *Component) Ports() []module.Port {
 			Configuration: Request{},
 		},
 		{
-			Name:     ResponsePort,
-			Position: module.Right,
-			Label:    "Out",
-			Source:   true,
-			Configuration: Output{
-				Decoded: h.settings.Decoded,
-			},
+			Name:          ResponsePort,
+			Position:      module.Right,
+			Label:         "Out",
+			Source:        true,
+			Configuration: h.output(),
 		},
 		{
 			Name:          v1alpha1.SettingsPort,
@@ -123,6 +305,29 @@ func (h *Component) Ports() []module.Port {
 			Configuration: h.settings,
 		},
 	}
+	if h.settings.Mode == ModeStream {
+		ports = append(ports, module.Port{
+			Name:          SummaryPort,
+			Label:         "Summary",
+			Position:      module.Right,
+			Source:        true,
+			Configuration: Summary{},
+		})
+	}
+	if h.settings.LearnShape {
+		ports = append(ports, module.Port{
+			Name:          LearnPort,
+			Label:         "Learn",
+			Position:      module.Left,
+			Configuration: LearnRequest{},
+		}, module.Port{
+			Name:          ShapePort,
+			Label:         "Shape",
+			Position:      module.Right,
+			Source:        true,
+			Configuration: Shape{},
+		})
+	}
 	if !h.settings.EnableErrorPort {
 		return ports
 	}
@@ -135,6 +340,24 @@ func (h *Component) Ports() []module.Port {
 	})
 }
 
+// output is the response port's shape, which follows the mode: one decoded
+// value, a list of them, or one element at a time.
+func (h *Component) output() any {
+	switch h.settings.Mode {
+	case ModeLines:
+		return Lines{
+			Records: []any{h.settings.Decoded},
+		}
+	case ModeStream:
+		return Item{
+			Decoded: h.settings.Decoded,
+		}
+	}
+	return Output{
+		Decoded: h.settings.Decoded,
+	}
+}
+
 func (h *Component) Instance() module.Component {
 	return &Component{
 		settings: Settings{},
@@ -0,0 +1,763 @@
+package decode
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func decoded(t *testing.T, encoded string, settings Settings) Output {
+	t.Helper()
+	port, msg, err := run(t, Request{Encoded: encoded}, settings)
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if port != ResponsePort {
+		t.Fatalf("emitted on %q, want %q", port, ResponsePort)
+	}
+	out, ok := msg.(Output)
+	if !ok {
+		t.Fatalf("emitted %T", msg)
+	}
+	return out
+}
+
+func rejected(t *testing.T, encoded string, settings Settings) Error {
+	t.Helper()
+	settings.EnableErrorPort = true
+	port, msg, err := run(t, Request{Encoded: encoded}, settings)
+	if err != nil {
+		t.Fatalf("with the error port on, the run must not fail: %v", err)
+	}
+	if port != ErrorPort {
+		t.Fatalf("emitted on %q, want %q", port, ErrorPort)
+	}
+	return msg.(Error)
+}
+
+func TestDecodesAnObject(t *testing.T) {
+	out := decoded(t, `{"user":{"id":7}}`, Settings{})
+	user, _ := out.Decoded.(map[string]any)["user"].(map[string]any)
+	if user["id"] != float64(7) {
+		t.Fatalf("decoded = %v", out.Decoded)
+	}
+}
+
+func TestMalformedInputFails(t *testing.T) {
+	if _, _, err := run(t, Request{Encoded: `{"a":`}, Settings{}); err == nil {
+		t.Fatal("truncated JSON was accepted")
+	}
+}
+
+var userShape = map[string]any{
+	"user": map[string]any{"id": float64(1), "name": "example"},
+	"tags": []any{"example"},
+}
+
+// Without enforcement the example only describes the port, which is the
+// behaviour every existing flow was built against.
+func TestShapeIsAHintUnlessEnforced(t *testing.T) {
+	decoded(t, `{"user":{}}`, Settings{Decoded: userShape})
+}
+
+// The reason enforcement exists: a missing field is reported where the JSON
+// entered the flow, naming the path, not resolved to null two nodes later.
+func TestEnforcedShapeReportsMissingAndMistypedPaths(t *testing.T) {
+	e := rejected(t, `{"user":{"id":"7"},"tags":["a",3]}`, Settings{Decoded: userShape, EnforceShape: true})
+
+	want := map[string]Violation{
+		"/user/id":   {Path: "/user/id", Expected: "number", Actual: "string"},
+		"/user/name": {Path: "/user/name", Expected: "string", Actual: "missing"},
+		"/tags/1":    {Path: "/tags/1", Expected: "string", Actual: "number"},
+	}
+	if len(e.Violations) != len(want) {
+		t.Fatalf("violations = %v, want %d", e.Violations, len(want))
+	}
+	for _, v := range e.Violations {
+		if want[v.Path] != v {
+			t.Errorf("unexpected violation %+v", v)
+		}
+	}
+	if e.Error == "" {
+		t.Error("the error port carried no message")
+	}
+}
+
+func TestEnforcedShapeAcceptsAConformingDocument(t *testing.T) {
+	decoded(t, `{"user":{"id":7,"name":"ann","extra":true},"tags":[],"more":1}`, Settings{Decoded: userShape, EnforceShape: true})
+}
+
+// A null, an empty object or an empty array in the example declares the key
+// without constraining what it holds.
+func TestOpenExampleValuesAcceptAnything(t *testing.T) {
+	shape := map[string]any{"meta": nil, "attrs": map[string]any{}, "list": []any{}}
+	decoded(t, `{"meta":"x","attrs":{"a":1},"list":[1,"two"]}`, Settings{Decoded: shape, EnforceShape: true})
+
+	e := rejected(t, `{"attrs":{},"list":[]}`, Settings{Decoded: shape, EnforceShape: true})
+	if len(e.Violations) != 1 || e.Violations[0].Path != "/meta" || e.Violations[0].Expected != "any" {
+		t.Fatalf("violations = %v, want /meta reported missing", e.Violations)
+	}
+}
+
+func TestEnforcedShapeWithoutErrorPortFails(t *testing.T) {
+	if _, _, err := run(t, Request{Encoded: `{}`}, Settings{Decoded: userShape, EnforceShape: true}); err == nil {
+		t.Fatal("a non-conforming document was accepted")
+	}
+}
+
+func TestViolationPathsAreEscaped(t *testing.T) {
+	e := rejected(t, `{}`, Settings{Decoded: map[string]any{"a/b~c": "x"}, EnforceShape: true})
+	if len(e.Violations) != 1 || e.Violations[0].Path != "/a~1b~0c" {
+		t.Fatalf("violations = %v, want the key escaped per RFC 6901", e.Violations)
+	}
+}
+
+func TestContextIsCarried(t *testing.T) {
+	_, msg, err := run(t, Request{Context: "trace-1", Encoded: `1`}, Settings{})
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if msg.(Output).Context != "trace-1" {
+		t.Fatalf("context = %v, want it carried", msg.(Output).Context)
+	}
+}
+
+func TestUnknownPortIsRefused(t *testing.T) {
+	c := &Component{}
+	if res := c.Handle(context.Background(), nil, "nope", Request{}); res.Err() == nil {
+		t.Fatal("an unknown port was accepted")
+	}
+}
+
+func lines(t *testing.T, encoded string, settings Settings) Lines {
+	t.Helper()
+	settings.Mode = ModeLines
+	port, msg, err := run(t, Request{Encoded: encoded}, settings)
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if port != ResponsePort {
+		t.Fatalf("emitted on %q, want %q", port, ResponsePort)
+	}
+	out, ok := msg.(Lines)
+	if !ok {
+		t.Fatalf("emitted %T", msg)
+	}
+	return out
+}
+
+// The reason lines mode exists: the second object no longer fails the message.
+func TestLinesDecodesEachLine(t *testing.T) {
+	out := lines(t, "{\"n\":1}\n{\"n\":2}\r\n\n{\"n\":3}", Settings{})
+	if out.Count != 3 {
+		t.Fatalf("count = %d, want 3 — a blank line is not a record", out.Count)
+	}
+	records := out.Records.([]any)
+	if records[2].(map[string]any)["n"] != float64(3) {
+		t.Fatalf("records = %v", records)
+	}
+	if len(out.Errors) != 0 || out.Truncated {
+		t.Fatalf("errors = %v, truncated = %v", out.Errors, out.Truncated)
+	}
+}
+
+// One torn write costs one line, and says which.
+func TestLinesCollectsBadLinesWithTheirNumbers(t *testing.T) {
+	out := lines(t, "{\"n\":1}\n{\"n\":\n\n{\"n\":3}\n", Settings{})
+	if out.Count != 2 {
+		t.Fatalf("count = %d, want the two good lines", out.Count)
+	}
+	if len(out.Errors) != 1 || out.Errors[0].Line != 2 {
+		t.Fatalf("errors = %v, want line 2 reported", out.Errors)
+	}
+}
+
+func TestLinesMaxRecordsTruncatesAndReportsIt(t *testing.T) {
+	out := lines(t, "1\n2\n3\n4\n", Settings{MaxRecords: 2})
+	if out.Count != 2 || !out.Truncated {
+		t.Fatalf("count = %d, truncated = %v, want the 2-record ceiling reported", out.Count, out.Truncated)
+	}
+}
+
+func TestLinesReadsAJSONTextSequence(t *testing.T) {
+	out := lines(t, "\x1e{\"n\":1}\n\x1e{\"n\":\n 2}\n\x1e{bad\n", Settings{})
+	if out.Count != 2 {
+		t.Fatalf("count = %d, want 2 — a record may span lines in a sequence", out.Count)
+	}
+	if len(out.Errors) != 1 || out.Errors[0].Line != 4 {
+		t.Fatalf("errors = %v, want the record starting on line 4 reported", out.Errors)
+	}
+}
+
+func TestLinesEnforcesTheShapePerRecord(t *testing.T) {
+	out := lines(t, "{\"id\":1}\n{\"id\":\"x\"}\n", Settings{Decoded: map[string]any{"id": float64(0)}, EnforceShape: true})
+	if out.Count != 1 || len(out.Errors) != 1 || out.Errors[0].Line != 2 {
+		t.Fatalf("count = %d, errors = %v, want line 2 rejected for its shape", out.Count, out.Errors)
+	}
+}
+
+func TestLinesEmptyInputIsNotAnError(t *testing.T) {
+	out := lines(t, "", Settings{})
+	if out.Count != 0 || out.Records == nil {
+		t.Fatalf("records = %v, want an empty list, not null", out.Records)
+	}
+}
+
+// The reason the setting exists: 2^53 + 1 does not survive a float64.
+const snowflake = `{"id":9007199254740993,"amount":12.10,"small":7}`
+
+func TestFloatNumbersAreTheDefault(t *testing.T) {
+	out := decoded(t, snowflake, Settings{})
+	if _, ok := out.Decoded.(map[string]any)["small"].(float64); !ok {
+		t.Fatalf("small = %#v, want a float64 when numbers is unset", out.Decoded.(map[string]any)["small"])
+	}
+}
+
+func TestNumbersAsStringKeepTheLiteral(t *testing.T) {
+	got := decoded(t, snowflake, Settings{Numbers: NumbersString}).Decoded.(map[string]any)
+	if got["id"] != "9007199254740993" || got["amount"] != "12.10" {
+		t.Fatalf("decoded = %v, want the literal text", got)
+	}
+}
+
+func TestNumbersAsInt64WhenTheyFit(t *testing.T) {
+	got := decoded(t, `{"id":9007199254740993,"amount":12.10,"huge":99999999999999999999}`, Settings{Numbers: NumbersInt64}).Decoded.(map[string]any)
+	if got["id"] != int64(9007199254740993) {
+		t.Errorf("id = %#v, want the exact int64", got["id"])
+	}
+	if n, ok := got["amount"].(json.Number); !ok || n.String() != "12.10" {
+		t.Errorf("amount = %#v, want an exact decimal for a fraction", got["amount"])
+	}
+	if n, ok := got["huge"].(json.Number); !ok || n.String() != "99999999999999999999" {
+		t.Errorf("huge = %#v, want an exact decimal past int64", got["huge"])
+	}
+}
+
+func TestNumbersAsDecimalSurviveReencoding(t *testing.T) {
+	got := decoded(t, snowflake, Settings{Numbers: NumbersDecimal}).Decoded
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"id":9007199254740993`) || !strings.Contains(string(data), `"amount":12.10`) {
+		t.Fatalf("re-encoded = %s, want the numbers exactly as sent", data)
+	}
+}
+
+func TestExactNumbersStillRefuseTrailingContent(t *testing.T) {
+	if _, _, err := run(t, Request{Encoded: `{"a":1} {"b":2}`}, Settings{Numbers: NumbersDecimal}); err == nil {
+		t.Fatal("a second value after the document was accepted")
+	}
+}
+
+func TestExactNumbersConformToANumberShape(t *testing.T) {
+	decoded(t, snowflake, Settings{Numbers: NumbersInt64, EnforceShape: true, Decoded: map[string]any{"id": float64(1), "amount": float64(1)}})
+}
+
+func TestStrictRejectsCommentsAndTrailingCommas(t *testing.T) {
+	for _, in := range []string{"{\"a\":1 // note\n}", `{"a":[1,2,],}`} {
+		if _, _, err := run(t, Request{Encoded: in}, Settings{}); err == nil {
+			t.Errorf("%q was accepted by the strict dialect", in)
+		}
+	}
+}
+
+func TestStrictErrorsCarryLineAndColumn(t *testing.T) {
+	_, _, err := run(t, Request{Encoded: "{\n  \"a\": 1,\n  \"b\": x\n}"}, Settings{})
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("err = %v, want the line of the offending token", err)
+	}
+}
+
+const tsconfig = `{
+  // compiler settings
+  "compilerOptions": {
+    "strict": true, /* always */
+    "paths": ["src/*", "lib/*",],
+  },
+}`
+
+func TestJSONCTakesCommentsAndTrailingCommas(t *testing.T) {
+	got := decoded(t, tsconfig, Settings{Dialect: DialectJSONC}).Decoded.(map[string]any)
+	options := got["compilerOptions"].(map[string]any)
+	if options["strict"] != true || len(options["paths"].([]any)) != 2 {
+		t.Fatalf("decoded = %v", got)
+	}
+}
+
+func TestJSONCStillRequiresQuotedKeys(t *testing.T) {
+	if _, _, err := run(t, Request{Encoded: `{a: 1}`}, Settings{Dialect: DialectJSONC}); err == nil {
+		t.Fatal("an unquoted key was accepted by JSONC")
+	}
+}
+
+const config5 = `// JSON5
+{
+  unquoted: 'and you can quote me on that',
+  singleQuotes: 'I can use "double quotes" here',
+  lineBreaks: "Look, Mom! \
+No \\n's!",
+  hexadecimal: 0xdecaf,
+  leadingDecimalPoint: .8675309, andTrailing: 8675309.,
+  positiveSign: +1,
+  trailingComma: 'in objects', andIn: ['arrays',],
+  "backwardsCompatible": "with JSON",
+}`
+
+func TestJSON5TakesTheSpecExample(t *testing.T) {
+	got := decoded(t, config5, Settings{Dialect: DialectJSON5}).Decoded.(map[string]any)
+	want := map[string]any{
+		"unquoted":            "and you can quote me on that",
+		"singleQuotes":        `I can use "double quotes" here`,
+		"lineBreaks":          `Look, Mom! No \n's!`,
+		"hexadecimal":         float64(0xdecaf),
+		"leadingDecimalPoint": 0.8675309,
+		"andTrailing":         float64(8675309),
+		"positiveSign":        float64(1),
+		"backwardsCompatible": "with JSON",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %#v, want %#v", key, got[key], value)
+		}
+	}
+}
+
+func TestJSON5NumbersFollowTheNumbersSetting(t *testing.T) {
+	got := decoded(t, `{id: 9007199254740993}`, Settings{Dialect: DialectJSON5, Numbers: NumbersInt64}).Decoded.(map[string]any)
+	if got["id"] != int64(9007199254740993) {
+		t.Fatalf("id = %#v", got["id"])
+	}
+}
+
+// Infinity is legal JSON5, but there is no JSON to hand it on as.
+func TestJSON5RefusesNonFiniteNumbers(t *testing.T) {
+	if _, _, err := run(t, Request{Encoded: `{a: Infinity}`}, Settings{Dialect: DialectJSON5}); err == nil {
+		t.Fatal("Infinity was accepted")
+	}
+}
+
+func TestLenientErrorsCarryLineAndColumn(t *testing.T) {
+	_, _, err := run(t, Request{Encoded: "{\n  a: 1,\n  b: 'x\n}"}, Settings{Dialect: DialectJSON5})
+	if err == nil || !strings.Contains(err.Error(), "json5: line 3, column 8") {
+		t.Fatalf("err = %v, want json5: line 3, column 8", err)
+	}
+}
+
+// The default decoder keeps the last of two keys; another parser keeps the
+// first. That is the gap strictSyntax closes.
+func TestDuplicateKeysAreAcceptedUnlessStrict(t *testing.T) {
+	decoded(t, `{"a":1,"a":2}`, Settings{})
+
+	e := rejected(t, `{"user":{"role":"user","role":"admin"}}`, Settings{StrictSyntax: true})
+	if e.Path != "/user/role" || !strings.Contains(e.Error, `duplicate key "role"`) {
+		t.Fatalf("error = %q at %q, want the duplicate named at /user/role", e.Error, e.Path)
+	}
+}
+
+func TestStrictSyntaxRefusesTrailingContent(t *testing.T) {
+	e := rejected(t, `{"a":1} x`, Settings{StrictSyntax: true})
+	if !strings.Contains(e.Error, "after the top-level value") {
+		t.Fatalf("error = %q", e.Error)
+	}
+}
+
+func TestStrictSyntaxRefusesInvalidUTF8(t *testing.T) {
+	e := rejected(t, "{\"list\":[\"ok\",\"bad \xff\"]}", Settings{StrictSyntax: true})
+	if e.Path != "/list/1" {
+		t.Fatalf("error = %q at %q, want /list/1", e.Error, e.Path)
+	}
+}
+
+func TestStrictSyntaxRefusesLoneSurrogates(t *testing.T) {
+	e := rejected(t, `{"name":"\ud800x"}`, Settings{StrictSyntax: true})
+	if e.Path != "/name" || !strings.Contains(e.Error, "surrogate") {
+		t.Fatalf("error = %q at %q", e.Error, e.Path)
+	}
+	got := decoded(t, `{"emoji":"😀"}`, Settings{StrictSyntax: true}).Decoded.(map[string]any)
+	if got["emoji"] != "😀" {
+		t.Fatalf("emoji = %q, want a paired surrogate decoded", got["emoji"])
+	}
+}
+
+func TestStrictSyntaxLimitsDepth(t *testing.T) {
+	deep := strings.Repeat("[", 4) + strings.Repeat("]", 4)
+	decoded(t, deep, Settings{StrictSyntax: true, MaxDepth: 4})
+	e := rejected(t, `{"a":`+deep+`}`, Settings{StrictSyntax: true, MaxDepth: 4})
+	if e.Path != "/a/0/0/0" {
+		t.Fatalf("error = %q at %q, want the path where the limit was crossed", e.Error, e.Path)
+	}
+}
+
+// The dialect parser recurses, so without a limit a long run of [ would
+// overflow the stack, which no recover catches.
+func TestLenientDialectsLimitDepth(t *testing.T) {
+	deep := strings.Repeat("[", 1_000_000)
+	e := rejected(t, deep, Settings{Dialect: DialectJSONC})
+	if !strings.Contains(e.Error, "nesting deeper than 10000 levels") {
+		t.Fatalf("error = %q", e.Error)
+	}
+	decoded(t, strings.Repeat("[", 100)+strings.Repeat("]", 100), Settings{Dialect: DialectJSONC})
+}
+
+func TestStrictSyntaxAppliesToLenientDialects(t *testing.T) {
+	e := rejected(t, `{a: 1, 'a': 2}`, Settings{Dialect: DialectJSON5, StrictSyntax: true})
+	if e.Path != "/a" {
+		t.Fatalf("error = %q at %q", e.Error, e.Path)
+	}
+}
+
+const envelope = `{"data":{"items":[{"id":1},{"id":2}]},"meta":{"page":1}}`
+
+func TestSelectEmitsTheSubtree(t *testing.T) {
+	for _, sel := range []string{"/data/items", "$.data.items"} {
+		got := decoded(t, envelope, Settings{Select: sel}).Decoded
+		if items, ok := got.([]any); !ok || len(items) != 2 {
+			t.Errorf("%s: decoded = %v, want the items array", sel, got)
+		}
+	}
+	got := decoded(t, envelope, Settings{Select: "$.data.items[1].id"}).Decoded
+	if got != float64(2) {
+		t.Errorf("decoded = %v, want 2", got)
+	}
+}
+
+// A missing envelope is the sender's error, and must read as one rather than
+// as an empty result.
+func TestSelectMissingPathIsAnError(t *testing.T) {
+	e := rejected(t, `{"data":{}}`, Settings{Select: "/data/items"})
+	if !strings.Contains(e.Error, `/data has no key "items"`) {
+		t.Fatalf("error = %q, want the missing key named", e.Error)
+	}
+}
+
+func TestSelectedValueIsWhatTheShapeDescribes(t *testing.T) {
+	shape := []any{map[string]any{"id": float64(0)}}
+	decoded(t, envelope, Settings{Select: "/data/items", Decoded: shape, EnforceShape: true})
+	e := rejected(t, `{"data":{"items":[{"id":"x"}]}}`, Settings{Select: "/data/items", Decoded: shape, EnforceShape: true})
+	if len(e.Violations) != 1 || e.Violations[0].Path != "/0/id" {
+		t.Fatalf("violations = %v, want paths relative to the selection", e.Violations)
+	}
+}
+
+func TestSelectAppliesPerRecordInLinesMode(t *testing.T) {
+	out := lines(t, "{\"event\":{\"n\":1}}\n{\"other\":1}\n", Settings{Select: "/event/n"})
+	if out.Count != 1 || out.Records.([]any)[0] != float64(1) || len(out.Errors) != 1 {
+		t.Fatalf("records = %v, errors = %v", out.Records, out.Errors)
+	}
+}
+
+const webhook = `{"type":"order","payload":"{\"id\":1,\"meta\":\"{\\\"source\\\":\\\"api\\\"}\"}","note":"[not json"}`
+
+// The reason unwrapping exists: one node instead of a chain of them.
+func TestUnwrapPathsReplacesEncodedFields(t *testing.T) {
+	got := decoded(t, webhook, Settings{UnwrapPaths: []string{"/payload", "/payload/meta"}}).Decoded.(map[string]any)
+	payload, ok := got["payload"].(map[string]any)
+	if !ok || payload["id"] != float64(1) {
+		t.Fatalf("payload = %#v, want the decoded object", got["payload"])
+	}
+	if meta, ok := payload["meta"].(map[string]any); !ok || meta["source"] != "api" {
+		t.Fatalf("meta = %#v, want the second layer decoded", payload["meta"])
+	}
+}
+
+func TestUnwrapPathReachesThroughAnEncodedParent(t *testing.T) {
+	got := decoded(t, webhook, Settings{UnwrapPaths: []string{"/payload/meta"}}).Decoded.(map[string]any)
+	meta := got["payload"].(map[string]any)["meta"].(map[string]any)
+	if meta["source"] != "api" {
+		t.Fatalf("meta = %v", meta)
+	}
+}
+
+func TestUnwrapPathWildcardMatchesEveryElement(t *testing.T) {
+	got := decoded(t, `{"events":[{"body":"{\"n\":1}"},{"body":"[2]"},{"other":1}]}`, Settings{UnwrapPaths: []string{"/events/*/body"}}).Decoded.(map[string]any)
+	events := got["events"].([]any)
+	if events[0].(map[string]any)["body"].(map[string]any)["n"] != float64(1) {
+		t.Errorf("events[0] = %v", events[0])
+	}
+	if _, ok := events[1].(map[string]any)["body"].([]any); !ok {
+		t.Errorf("events[1] = %v", events[1])
+	}
+}
+
+// A configured path that looks like JSON but is not is the sender's error.
+func TestUnwrapPathWithBrokenJSONIsAnError(t *testing.T) {
+	e := rejected(t, webhook, Settings{UnwrapPaths: []string{"/note"}})
+	if !strings.Contains(e.Error, "unwrap /note") {
+		t.Fatalf("error = %q", e.Error)
+	}
+}
+
+func TestUnwrapAllLeavesTextAlone(t *testing.T) {
+	got := decoded(t, webhook, Settings{UnwrapAll: true}).Decoded.(map[string]any)
+	if got["note"] != "[not json" || got["type"] != "order" {
+		t.Fatalf("decoded = %v, want plain strings untouched", got)
+	}
+	meta := got["payload"].(map[string]any)["meta"].(map[string]any)
+	if meta["source"] != "api" {
+		t.Fatalf("meta = %v, want every layer unwrapped", meta)
+	}
+}
+
+func TestUnwrapDepthBoundsTheLayers(t *testing.T) {
+	got := decoded(t, webhook, Settings{UnwrapAll: true, UnwrapDepth: 1}).Decoded.(map[string]any)
+	if _, ok := got["payload"].(map[string]any)["meta"].(string); !ok {
+		t.Fatalf("meta = %#v, want the second layer left encoded at depth 1", got["payload"].(map[string]any)["meta"])
+	}
+}
+
+// A string encoding a string encoding an object is two layers, not a quoted
+// word: both come off.
+func TestUnwrapPeelsAStringEncodedTwice(t *testing.T) {
+	got := decoded(t, `{"p":"\"{\\\"id\\\":1}\"","word":"\"quoted\""}`, Settings{UnwrapAll: true}).Decoded.(map[string]any)
+	if p, ok := got["p"].(map[string]any); !ok || p["id"] != float64(1) {
+		t.Fatalf("p = %#v", got["p"])
+	}
+	if got["word"] != `"quoted"` {
+		t.Fatalf("word = %#v, want a quoted word left alone", got["word"])
+	}
+}
+
+func TestSelectReachesIntoAnUnwrappedField(t *testing.T) {
+	got := decoded(t, webhook, Settings{UnwrapPaths: []string{"/payload"}, Select: "/payload/id"}).Decoded
+	if got != float64(1) {
+		t.Fatalf("decoded = %v", got)
+	}
+}
+
+type emission struct {
+	port string
+	msg  interface{}
+}
+
+func stream(t *testing.T, encoded string, settings Settings) ([]Item, Summary) {
+	t.Helper()
+	settings.Mode = ModeStream
+	c := (&Component{}).Instance().(*Component)
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+	var got []emission
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		got = append(got, emission{port, msg})
+		return module.Result{}
+	}, RequestPort, Request{Context: "export-1", Encoded: encoded})
+	if res.Err() != nil {
+		t.Fatalf("handle: %v", res.Err())
+	}
+	if len(got) == 0 || got[len(got)-1].port != SummaryPort {
+		t.Fatalf("emissions = %v, want the summary last", got)
+	}
+	var items []Item
+	for _, e := range got[:len(got)-1] {
+		if e.port != ResponsePort {
+			t.Fatalf("emitted on %q before the summary", e.port)
+		}
+		items = append(items, e.msg.(Item))
+	}
+	return items, got[len(got)-1].msg.(Summary)
+}
+
+func TestStreamEmitsEachElement(t *testing.T) {
+	items, summary := stream(t, ` [{"n":0}, {"n":1}, {"n":2}] `, Settings{})
+	if len(items) != 3 || summary.Count != 3 || !summary.Complete {
+		t.Fatalf("items = %v, summary = %+v", items, summary)
+	}
+	for i, item := range items {
+		if item.Index != i || item.Context != "export-1" || item.Decoded.(map[string]any)["n"] != float64(i) {
+			t.Errorf("item %d = %+v", i, item)
+		}
+	}
+}
+
+func TestStreamSkipsElementsThatFailTheirChecks(t *testing.T) {
+	items, summary := stream(t, `[{"id":1},{"id":"x"},{"id":3}]`, Settings{Decoded: map[string]any{"id": float64(0)}, EnforceShape: true})
+	if len(items) != 2 || items[1].Index != 2 {
+		t.Fatalf("items = %v, want elements 0 and 2", items)
+	}
+	if len(summary.Errors) != 1 || summary.Errors[0].Index != 1 || !summary.Complete {
+		t.Fatalf("summary = %+v", summary)
+	}
+}
+
+// A stream that breaks off must say so: the items before the break were
+// emitted, and the flow needs to know they are not the whole export.
+func TestStreamReportsAnIncompleteArray(t *testing.T) {
+	items, summary := stream(t, `[{"n":0},{"n":1},{"n":`, Settings{})
+	if len(items) != 2 {
+		t.Fatalf("items = %v, want the two before the break", items)
+	}
+	if summary.Complete || len(summary.Errors) != 1 || summary.Errors[0].Index != 2 {
+		t.Fatalf("summary = %+v, want it incomplete at index 2", summary)
+	}
+}
+
+func TestStreamRefusesANonArray(t *testing.T) {
+	items, summary := stream(t, `{"items":[]}`, Settings{})
+	if len(items) != 0 || summary.Complete || len(summary.Errors) != 1 {
+		t.Fatalf("items = %v, summary = %+v", items, summary)
+	}
+}
+
+// Stream mode frames elements as strict JSON, so a dialect it would ignore
+// is refused rather than failing at the first comment.
+func TestStreamRefusesLenientDialects(t *testing.T) {
+	items, summary := stream(t, "[1, // one\n2,]", Settings{Dialect: DialectJSONC})
+	if len(items) != 0 || summary.Complete || len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0].Error, "strict JSON only") {
+		t.Fatalf("items = %v, summary = %+v", items, summary)
+	}
+}
+
+func TestStreamMaxRecordsTruncates(t *testing.T) {
+	items, summary := stream(t, `[1,2,3,4]`, Settings{MaxRecords: 2})
+	if len(items) != 2 || !summary.Truncated {
+		t.Fatalf("items = %v, summary = %+v", items, summary)
+	}
+}
+
+func TestStreamStopsWhenDownstreamFails(t *testing.T) {
+	c := (&Component{}).Instance().(*Component)
+	_ = c.OnSettings(context.Background(), Settings{Mode: ModeStream})
+	calls := 0
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		calls++
+		return module.Fail(context.Canceled)
+	}, RequestPort, Request{Encoded: `[1,2,3]`})
+	if res.Err() == nil || calls != 1 {
+		t.Fatalf("err = %v after %d calls, want the first failure returned", res.Err(), calls)
+	}
+}
+
+func TestStreamAddsTheSummaryPort(t *testing.T) {
+	c := (&Component{}).Instance().(*Component)
+	_ = c.OnSettings(context.Background(), Settings{Mode: ModeStream})
+	for _, p := range c.Ports() {
+		if p.Name == SummaryPort {
+			return
+		}
+	}
+	t.Fatal("stream mode has no summary port")
+}
+
+func handleAll(t *testing.T, c *Component, port string, msgs ...any) map[string][]any {
+	t.Helper()
+	got := map[string][]any{}
+	for _, msg := range msgs {
+		res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+			got[port] = append(got[port], msg)
+			return module.Result{}
+		}, port, msg)
+		if res.Err() != nil {
+			t.Fatalf("handle: %v", res.Err())
+		}
+	}
+	return got
+}
+
+func learning(t *testing.T, settings Settings) *Component {
+	t.Helper()
+	c := (&Component{}).Instance().(*Component)
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// The shape goes out once, after the Nth payload, alongside the responses —
+// learning must not hold anything up or change what is decoded.
+func TestLearnShapeFromTheFirstPayloads(t *testing.T) {
+	c := learning(t, Settings{LearnShape: true, LearnSamples: 2})
+	got := handleAll(t, c, RequestPort,
+		Request{Encoded: `{"id":1,"name":"a"}`},
+		Request{Encoded: `{"id":2,"tags":["x"]}`},
+		Request{Encoded: `{"id":3,"extra":true}`},
+	)
+	if len(got[ResponsePort]) != 3 {
+		t.Fatalf("responses = %d, want every payload decoded", len(got[ResponsePort]))
+	}
+	if len(got[ShapePort]) != 1 {
+		t.Fatalf("shapes = %d, want exactly one", len(got[ShapePort]))
+	}
+	shape := got[ShapePort][0].(Shape)
+	if shape.Samples != 2 {
+		t.Errorf("samples = %d", shape.Samples)
+	}
+	want := map[string]any{"id": float64(1), "name": "a", "tags": []any{"x"}}
+	if got := mustJSON(t, shape.Example); got != mustJSON(t, want) {
+		t.Errorf("example = %s, want %s", got, mustJSON(t, want))
+	}
+	if required := shape.Schema["required"]; mustJSON(t, required) != `["id"]` {
+		t.Errorf("required = %v", required)
+	}
+}
+
+func TestLearnShapeCountsRecordsInLinesMode(t *testing.T) {
+	c := learning(t, Settings{LearnShape: true, LearnSamples: 3, Mode: ModeLines})
+	got := handleAll(t, c, RequestPort, Request{Encoded: "{\"a\":1}\n{\"a\":2}\n{\"a\":3,\"b\":1}\n{\"c\":1}\n"})
+	if len(got[ShapePort]) != 1 || got[ShapePort][0].(Shape).Samples != 3 {
+		t.Fatalf("shape = %v", got[ShapePort])
+	}
+	if _, ok := got[ShapePort][0].(Shape).Example.(map[string]any)["c"]; ok {
+		t.Error("learned from a record past learnSamples")
+	}
+}
+
+// A corpus sent to the learn port is decoded with the node's settings, so the
+// proposal describes the selected value.
+func TestLearnPortTakesACorpus(t *testing.T) {
+	c := learning(t, Settings{LearnShape: true, Select: "/data"})
+	got := handleAll(t, c, LearnPort, LearnRequest{Context: "ctx", Samples: "{\"data\":{\"x\":1}}\nnot json\n{\"data\":{\"x\":2,\"y\":\"z\"}}\n"})
+	shape := got[ShapePort][0].(Shape)
+	if shape.Samples != 2 || len(shape.Errors) != 1 || shape.Errors[0].Line != 2 || shape.Context != "ctx" {
+		t.Fatalf("shape = %+v", shape)
+	}
+	if mustJSON(t, shape.Example) != `{"x":1,"y":"z"}` {
+		t.Errorf("example = %s", mustJSON(t, shape.Example))
+	}
+}
+
+func TestLearnPortsOnlyWhenLearning(t *testing.T) {
+	for _, learn := range []bool{false, true} {
+		c := learning(t, Settings{LearnShape: learn})
+		found := 0
+		for _, p := range c.Ports() {
+			if p.Name == LearnPort || p.Name == ShapePort {
+				found++
+			}
+		}
+		if (found == 2) != learn {
+			t.Errorf("learnShape %v: %d learn ports", learn, found)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,603 @@
+package decode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
+)
+
+const (
+	DialectStrict = "strict"
+	DialectJSONC  = "jsonc"
+	DialectJSON5  = "json5"
+)
+
+// parser reads the lenient dialects, and any dialect under strictSyntax.
+// Plain strict JSON goes to the regular decoder instead, so most of what is
+// below exists to accept what a person typing a config file writes, and to
+// say where it went wrong in terms of the lines they typed.
+//
+// Numbers come out as json.Number, normalised to a literal strict JSON would
+// accept, so the numbers setting applies to them exactly as it does to
+// anything else.
+type parser struct {
+	src     []byte
+	pos     int
+	dialect string
+
+	// strict refuses what the regular decoder lets through silently: a
+	// repeated key, invalid UTF-8 and an unpaired surrogate. Nesting past
+	// maxDepth is refused either way, since the parser recurses and a deep
+	// enough document would overflow the stack. path tracks where the
+	// parser is so a refusal can name it.
+	strict   bool
+	maxDepth int
+	depth    int
+	path     []string
+}
+
+// syntaxError is a strict refusal, carrying the path it happened at so the
+// error port can hand it back to the sender as a field rather than prose.
+type syntaxError struct {
+	path string
+	err  error
+}
+
+func (e *syntaxError) Error() string {
+	return e.err.Error()
+}
+
+func parse(src []byte, dialect string, strict bool, maxDepth int) (any, error) {
+	p := &parser{src: src, dialect: dialect, strict: strict, maxDepth: maxDepth}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		if p.strict {
+			return nil, p.reject("content after the top-level value")
+		}
+		return nil, p.errorf("unexpected %s after the top-level value", p.describe())
+	}
+	return v, nil
+}
+
+func (p *parser) json5() bool {
+	return p.dialect == DialectJSON5
+}
+
+// errorf reports a position the way an editor shows it: 1-based line, and a
+// column counted in characters rather than bytes.
+func (p *parser) errorf(format string, args ...any) error {
+	line, col := position(p.src, p.pos)
+	return fmt.Errorf("%s: line %d, column %d: %s", p.dialect, line, col, fmt.Sprintf(format, args...))
+}
+
+// reject is errorf for the strict checks: the message names the path as well
+// as the position, since "line 1, column 80412" is no help to whoever has to
+// fix the sender.
+func (p *parser) reject(format string, args ...any) error {
+	path := pointer.Format(p.path)
+	where := path
+	if where == "" {
+		where = "the top level"
+	}
+	return &syntaxError{path: path, err: p.errorf("%s at %s", fmt.Sprintf(format, args...), where)}
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth <= p.maxDepth {
+		return nil
+	}
+	if p.strict {
+		return p.reject("nesting deeper than %d levels", p.maxDepth)
+	}
+	return p.errorf("nesting deeper than %d levels", p.maxDepth)
+}
+
+func position(src []byte, offset int) (int, int) {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	before := src[:offset]
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	if i := bytes.LastIndexByte(before, '\n'); i >= 0 {
+		before = before[i+1:]
+	}
+	return line, utf8.RuneCount(before) + 1
+}
+
+func (p *parser) describe() string {
+	if p.pos >= len(p.src) {
+		return "end of input"
+	}
+	r, _ := utf8.DecodeRune(p.src[p.pos:])
+	return fmt.Sprintf("%q", r)
+}
+
+// skip passes whitespace and, outside strict, comments.
+func (p *parser) skip() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case p.comment("//"):
+			end := bytes.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 1
+			}
+		case p.comment("/*"):
+			end := bytes.Index(p.src[p.pos+2:], []byte("*/"))
+			if end < 0 {
+				return p.errorf("comment is never closed")
+			}
+			p.pos += end + 4
+		case p.json5() && c >= utf8.RuneSelf:
+			// JSON5 takes every Unicode space separator, plus the byte order
+			// mark a Windows editor leaves at the top of the file.
+			r, size := utf8.DecodeRune(p.src[p.pos:])
+			if r != '\uFEFF' && r != '\u2028' && r != '\u2029' && !unicode.Is(unicode.Zs, r) {
+				return nil
+			}
+			p.pos += size
+		case p.json5() && (c == '\v' || c == '\f'):
+			p.pos++
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *parser) comment(opener string) bool {
+	return p.dialect != DialectStrict && bytes.HasPrefix(p.src[p.pos:], []byte(opener))
+}
+
+func (p *parser) value() (any, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of input")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"' || (c == '\'' && p.json5()):
+		return p.string()
+	case c == '-' || (c >= '0' && c <= '9') || (p.json5() && (c == '+' || c == '.' || c == 'I' || c == 'N')):
+		return p.number()
+	case p.literal("true"):
+		return true, nil
+	case p.literal("false"):
+		return false, nil
+	case p.literal("null"):
+		return nil, nil
+	default:
+		return nil, p.errorf("unexpected %s", p.describe())
+	}
+}
+
+func (p *parser) literal(word string) bool {
+	if !bytes.HasPrefix(p.src[p.pos:], []byte(word)) {
+		return false
+	}
+	p.pos += len(word)
+	return true
+}
+
+func (p *parser) object() (any, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.pos++ // {
+	obj := map[string]any{}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '}' {
+		p.pos++
+		return obj, nil
+	}
+	for {
+		keyAt := p.pos
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.path = append(p.path, key)
+		if _, seen := obj[key]; seen && p.strict {
+			// Which of the two a consumer acts on is up to its parser — last
+			// wins here, first wins elsewhere — and that disagreement is how
+			// a signed payload says one thing to the verifier and another to
+			// the handler.
+			p.pos = keyAt
+			return nil, p.reject("duplicate key %q", key)
+		}
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+			return nil, p.errorf("expected ':' after object key, found %s", p.describe())
+		}
+		p.pos++
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+		p.path = p.path[:len(p.path)-1]
+
+		closed, err := p.next('}')
+		if err != nil {
+			return nil, err
+		}
+		if closed {
+			return obj, nil
+		}
+	}
+}
+
+func (p *parser) array() (any, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.pos++ // [
+	arr := make([]any, 0)
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == ']' {
+		p.pos++
+		return arr, nil
+	}
+	for {
+		p.path = append(p.path, strconv.Itoa(len(arr)))
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		p.path = p.path[:len(p.path)-1]
+
+		closed, err := p.next(']')
+		if err != nil {
+			return nil, err
+		}
+		if closed {
+			return arr, nil
+		}
+	}
+}
+
+// next consumes what follows a member: a comma, or the closing bracket. Both
+// lenient dialects take a trailing comma, which is the edit a person makes
+// most often when they delete the last entry of a list.
+func (p *parser) next(closing byte) (bool, error) {
+	if err := p.skip(); err != nil {
+		return false, err
+	}
+	if p.pos >= len(p.src) {
+		return false, p.errorf("expected ',' or '%c', found end of input", closing)
+	}
+	switch p.src[p.pos] {
+	case closing:
+		p.pos++
+		return true, nil
+	case ',':
+		p.pos++
+	default:
+		return false, p.errorf("expected ',' or '%c', found %s", closing, p.describe())
+	}
+	if err := p.skip(); err != nil {
+		return false, err
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == closing {
+		if p.dialect == DialectStrict {
+			return false, p.errorf("trailing comma before '%c'", closing)
+		}
+		p.pos++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *parser) key() (string, error) {
+	if p.pos >= len(p.src) {
+		return "", p.errorf("expected an object key, found end of input")
+	}
+	c := p.src[p.pos]
+	if c == '"' || (c == '\'' && p.json5()) {
+		return p.string()
+	}
+	if p.json5() {
+		return p.identifier()
+	}
+	return "", p.errorf("expected a quoted object key, found %s", p.describe())
+}
+
+// identifier reads an unquoted JSON5 key: an ECMAScript identifier name, which
+// is what a person writes when they forget the quotes.
+func (p *parser) identifier() (string, error) {
+	start := p.pos
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRune(p.src[p.pos:])
+		first := p.pos == start
+		if r == '$' || r == '_' || unicode.IsLetter(r) || (!first && (unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Pc, r))) {
+			p.pos += size
+			continue
+		}
+		break
+	}
+	if p.pos == start {
+		return "", p.errorf("expected an object key, found %s", p.describe())
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+func (p *parser) string() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			return "", p.errorf("string is never closed")
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\\':
+			if err := p.escape(&sb); err != nil {
+				return "", err
+			}
+		case c < 0x20:
+			return "", p.errorf("unescaped control character %q in string", rune(c))
+		default:
+			r, size := utf8.DecodeRune(p.src[p.pos:])
+			if r == utf8.RuneError && size == 1 && p.strict {
+				return "", p.reject("invalid UTF-8 in string")
+			}
+			sb.WriteRune(r)
+			p.pos += size
+		}
+	}
+}
+
+func (p *parser) escape(sb *strings.Builder) error {
+	p.pos++ // backslash
+	if p.pos >= len(p.src) {
+		return p.errorf("string is never closed")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case '"', '\\', '/':
+		sb.WriteByte(c)
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'u':
+		at := p.pos - 2
+		r, err := p.hex(4)
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) && bytes.HasPrefix(p.src[p.pos:], []byte(`\u`)) {
+			save := p.pos
+			p.pos += 2
+			low, err := p.hex(4)
+			if err == nil {
+				if pair := utf16.DecodeRune(r, low); pair != unicode.ReplacementChar {
+					sb.WriteRune(pair)
+					return nil
+				}
+			}
+			p.pos = save
+		}
+		if utf16.IsSurrogate(r) && p.strict {
+			p.pos = at
+			return p.reject("unpaired surrogate \\u%04X in string", r)
+		}
+		sb.WriteRune(r)
+	default:
+		if !p.json5() {
+			p.pos--
+			return p.errorf("invalid escape '\\%c' in string", c)
+		}
+		return p.escape5(sb, c)
+	}
+	return nil
+}
+
+// escape5 handles what JSON5 adds: \' \v \0 \xHH, a backslash before a line
+// break continuing the string on the next line, and any other character
+// escaping to itself.
+func (p *parser) escape5(sb *strings.Builder, c byte) error {
+	switch c {
+	case '\'':
+		sb.WriteByte('\'')
+	case 'v':
+		sb.WriteByte('\v')
+	case '0':
+		if p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos--
+			return p.errorf("octal escapes are not allowed in JSON5")
+		}
+		sb.WriteByte(0)
+	case 'x':
+		r, err := p.hex(2)
+		if err != nil {
+			return err
+		}
+		sb.WriteRune(r)
+	case '\r':
+		if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+			p.pos++
+		}
+	case '\n':
+	default:
+		if c >= '1' && c <= '9' {
+			p.pos--
+			return p.errorf("invalid escape '\\%c' in string", c)
+		}
+		p.pos--
+		r, size := utf8.DecodeRune(p.src[p.pos:])
+		p.pos += size
+		if r != '\u2028' && r != '\u2029' {
+			sb.WriteRune(r)
+		}
+	}
+	return nil
+}
+
+func (p *parser) hex(digits int) (rune, error) {
+	if p.pos+digits > len(p.src) {
+		return 0, p.errorf("truncated escape in string")
+	}
+	var r rune
+	for _, c := range p.src[p.pos : p.pos+digits] {
+		var d byte
+		switch {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c >= 'a' && c <= 'f':
+			d = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, p.errorf("invalid hex digit %q in escape", rune(c))
+		}
+		r = r<<4 | rune(d)
+	}
+	p.pos += digits
+	return r, nil
+}
+
+var errNonFinite = errors.New("is valid JSON5 but has no JSON equivalent, so nothing downstream could carry it")
+
+func (p *parser) number() (any, error) {
+	start := p.pos
+	negative := false
+	if c := p.src[p.pos]; c == '-' || c == '+' {
+		if c == '+' && !p.json5() {
+			return nil, p.errorf("unexpected '+'")
+		}
+		negative = c == '-'
+		p.pos++
+	}
+
+	if p.json5() {
+		for _, word := range []string{"Infinity", "NaN"} {
+			if p.literal(word) {
+				return nil, p.errorf("%s %v", string(p.src[start:p.pos]), errNonFinite)
+			}
+		}
+		if bytes.HasPrefix(p.src[p.pos:], []byte("0x")) || bytes.HasPrefix(p.src[p.pos:], []byte("0X")) {
+			return p.hexNumber(start, negative)
+		}
+	}
+
+	var literal strings.Builder
+	if negative {
+		literal.WriteByte('-')
+	}
+
+	intStart := p.pos
+	p.digits()
+	intPart := string(p.src[intStart:p.pos])
+	switch {
+	case intPart == "" && (!p.json5() || p.pos >= len(p.src) || p.src[p.pos] != '.'):
+		return nil, p.errorf("invalid number")
+	case len(intPart) > 1 && intPart[0] == '0':
+		p.pos = intStart
+		return nil, p.errorf("invalid number: leading zero")
+	case intPart == "":
+		// JSON5 allows .5; strict JSON spells it 0.5.
+		intPart = "0"
+	}
+	literal.WriteString(intPart)
+
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos++
+		fracStart := p.pos
+		p.digits()
+		frac := string(p.src[fracStart:p.pos])
+		if frac == "" && (!p.json5() || p.pos == intStart+1) {
+			return nil, p.errorf("invalid number: no digits after the decimal point")
+		}
+		if frac != "" {
+			literal.WriteString("." + frac)
+		}
+	}
+
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		literal.WriteByte('e')
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			literal.WriteByte(p.src[p.pos])
+			p.pos++
+		}
+		expStart := p.pos
+		p.digits()
+		if p.pos == expStart {
+			return nil, p.errorf("invalid number: no digits in the exponent")
+		}
+		literal.Write(p.src[expStart:p.pos])
+	}
+	return json.Number(literal.String()), nil
+}
+
+func (p *parser) hexNumber(start int, negative bool) (any, error) {
+	p.pos += 2
+	digitsStart := p.pos
+	for p.pos < len(p.src) && strings.IndexByte("0123456789abcdefABCDEF", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	n, ok := new(big.Int).SetString(string(p.src[digitsStart:p.pos]), 16)
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("invalid hexadecimal number")
+	}
+	if negative {
+		n.Neg(n)
+	}
+	return json.Number(n.String()), nil
+}
+
+func (p *parser) digits() {
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+}
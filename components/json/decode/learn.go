@@ -0,0 +1,115 @@
+package decode
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tiny-systems/encoding-module/components/json/infer"
+	"github.com/tiny-systems/module/module"
+)
+
+const (
+	LearnPort = "learn"
+	ShapePort = "shape"
+
+	defaultLearnSamples = 20
+)
+
+// LearnRequest is a corpus of samples to learn the shape from at once.
+type LearnRequest struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the shape"`
+	Samples string  `json:"samples" required:"true" format:"textarea" title:"Samples" description:"Sample payloads, one JSON document per line, or as an RFC 7464 sequence for pretty-printed ones. Each is decoded with this node's settings — unwrap, select — so the shape describes what the response port carries."`
+}
+
+// Shape is a proposal for the decoded setting.
+type Shape struct {
+	Context Context        `json:"context,omitempty"`
+	Samples int            `json:"samples" title:"Samples" description:"How many decoded values the shape was learned from."`
+	Example any            `json:"example" title:"Example" description:"A representative value: every field seen, with the most common type at each position. Paste it into the decoded setting. With enforceShape on, every field it names becomes required — remove the optional ones first; the schema's required list says which they are."`
+	Schema  map[string]any `json:"schema" title:"Schema" description:"JSON Schema (draft 2020-12) satisfied by every sample: optional fields are left out of required, and a position that held several types lists them all. Suitable for json_schema_validate."`
+	Errors  []LineError    `json:"errors,omitempty" title:"Errors" description:"Samples that did not decode and were not learned from."`
+}
+
+// respond emits msg on the response port and then shows learn the decoded
+// values it carried. A failed response is returned as it is, and nothing is
+// learned from a value the flow did not accept.
+func (h *Component) respond(ctx context.Context, handler module.Handler, reqCtx Context, msg any, values ...any) module.Result {
+	res := handler(ctx, ResponsePort, msg)
+	if res.Err() != nil || !h.settings.LearnShape {
+		return res
+	}
+	if learned := h.learn(ctx, handler, reqCtx, values...); learned.Err() != nil {
+		return learned
+	}
+	return res
+}
+
+// learn observes decoded values until learnSamples have been seen, then emits
+// the shape once. It is called after the response has gone out, so learning
+// never delays or changes what the flow receives.
+func (h *Component) learn(ctx context.Context, handler module.Handler, reqCtx Context, values ...any) module.Result {
+	h.learnMu.Lock()
+	if h.learned {
+		h.learnMu.Unlock()
+		return module.Result{}
+	}
+	want := h.learnSamples()
+	for _, v := range values {
+		if h.learner.Samples() >= want {
+			break
+		}
+		h.learner.Observe(v)
+	}
+	if h.learner.Samples() < want {
+		h.learnMu.Unlock()
+		return module.Result{}
+	}
+	h.learned = true
+	shape := Shape{
+		Context: reqCtx,
+		Samples: h.learner.Samples(),
+		Example: h.learner.Example(),
+		Schema:  h.learner.Schema(),
+	}
+	h.learnMu.Unlock()
+
+	return handler(ctx, ShapePort, shape)
+}
+
+// handleLearn learns from a corpus in one go, independently of what the
+// request port has observed.
+func (h *Component) handleLearn(ctx context.Context, handler module.Handler, in LearnRequest) module.Result {
+	var learner infer.Learner
+	var errs []LineError
+	for _, rec := range split([]byte(in.Samples)) {
+		if rec.err != nil {
+			errs = append(errs, LineError{Line: rec.line, Error: rec.err.Error()})
+			continue
+		}
+		v, err := h.decode(rec.data)
+		if err != nil {
+			if len(errs) < maxLineErrors {
+				errs = append(errs, LineError{Line: rec.line, Error: err.Error()})
+			}
+			continue
+		}
+		learner.Observe(v)
+	}
+	if learner.Samples() == 0 {
+		return h.handleError(ctx, handler, in.Context, fmt.Errorf("no sample in the corpus decoded, so there is nothing to learn from"))
+	}
+	return handler(ctx, ShapePort, Shape{
+		Context: in.Context,
+		Samples: learner.Samples(),
+		Example: learner.Example(),
+		Schema:  learner.Schema(),
+		Errors:  errs,
+	})
+}
+
+func (h *Component) learnSamples() int {
+	if h.settings.LearnSamples <= 0 {
+		return defaultLearnSamples
+	}
+	return h.settings.LearnSamples
+}
@@ -0,0 +1,137 @@
+package decode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/tiny-systems/module/module"
+)
+
+const (
+	// recordSeparator opens every record of an RFC 7464 JSON text sequence.
+	recordSeparator = 0x1E
+
+	defaultMaxRecords = 10000
+
+	// maxLineErrors bounds the error list, so a file that is not JSON Lines at
+	// all cannot produce a message larger than the file itself.
+	maxLineErrors = 100
+)
+
+// Records is the decoded list in lines mode. Each element takes the decoded
+// shape, so the setting describes one record, not the list.
+type Records any
+
+// Lines is what the response port carries in lines mode.
+type Lines struct {
+	Context   Context     `json:"context"`
+	Records   Records     `json:"records" configurable:"true" title:"Records" description:"One decoded value per line. Wire through array_split so each record arrives as its own message."`
+	Count     int         `json:"count" title:"Count"`
+	Truncated bool        `json:"truncated" title:"Truncated" description:"True when the input had more records than maxRecords and the rest were dropped."`
+	Errors    []LineError `json:"errors" title:"Line Errors" description:"Lines that did not decode, and were left out of records. Check it: a shipper that wrote one corrupt line should not cost the rest of the batch, but it should not go unnoticed either."`
+}
+
+// LineError is one line that was skipped.
+type LineError struct {
+	Line  int    `json:"line" title:"Line" description:"1-based line number in the input."`
+	Error string `json:"error" title:"Error"`
+}
+
+// record is one candidate value and where it starts in the input.
+type record struct {
+	line int
+	data []byte
+	err  error
+}
+
+// handleLines decodes every line on its own. A bad line is collected rather
+// than failing the message, because a log batch with one torn write is still
+// a log batch.
+func (h *Component) handleLines(ctx context.Context, handler module.Handler, in Request) module.Result {
+	maxRecords := h.settings.MaxRecords
+	if maxRecords <= 0 {
+		maxRecords = defaultMaxRecords
+	}
+
+	out := Lines{
+		Context: in.Context,
+		Errors:  []LineError{},
+	}
+	records := make([]any, 0, 16)
+
+	for _, rec := range split([]byte(in.Encoded)) {
+		if len(records) >= maxRecords {
+			out.Truncated = true
+			break
+		}
+		if rec.err != nil {
+			out.addError(rec.line, rec.err)
+			continue
+		}
+		value, err := h.decode(rec.data)
+		if err != nil {
+			out.addError(rec.line, err)
+			continue
+		}
+		records = append(records, value)
+	}
+
+	out.Records = records
+	out.Count = len(records)
+	return h.respond(ctx, handler, in.Context, out, records...)
+}
+
+func (l *Lines) addError(line int, err error) {
+	if len(l.Errors) < maxLineErrors {
+		l.Errors = append(l.Errors, LineError{Line: line, Error: err.Error()})
+	}
+}
+
+// split cuts the input into records, skipping blank lines. Input that carries
+// a record separator anywhere is read as an RFC 7464 sequence, where a value
+// may span lines; anything else is newline-delimited.
+func split(input []byte) []record {
+	if bytes.IndexByte(input, recordSeparator) >= 0 {
+		return splitSequence(input)
+	}
+
+	var records []record
+	line := 0
+	for len(input) > 0 {
+		line++
+		var current []byte
+		if i := bytes.IndexByte(input, '\n'); i >= 0 {
+			current, input = input[:i], input[i+1:]
+		} else {
+			current, input = input, nil
+		}
+		current = bytes.TrimSpace(current)
+		if len(current) == 0 {
+			continue
+		}
+		records = append(records, record{line: line, data: current})
+	}
+	return records
+}
+
+func splitSequence(input []byte) []record {
+	var records []record
+	line := 1
+	for i, chunk := range bytes.Split(input, []byte{recordSeparator}) {
+		start := line
+		line += bytes.Count(chunk, []byte{'\n'})
+		trimmed := bytes.TrimSpace(chunk)
+		if len(trimmed) == 0 {
+			continue
+		}
+		if i == 0 {
+			// Text before the first separator is not a record, and a sender
+			// that wrote one has framed the sequence wrong.
+			records = append(records, record{line: start, err: fmt.Errorf("content before the first record separator")})
+			continue
+		}
+		records = append(records, record{line: start, data: trimmed})
+	}
+	return records
+}
@@ -0,0 +1,59 @@
+package decode
+
+import (
+	"strconv"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	NumbersFloat   = "float"
+	NumbersString  = "string"
+	NumbersInt64   = "int64"
+	NumbersDecimal = "decimal"
+)
+
+// numbers converts every json.Number in v to the form the numbers setting
+// asks for. The decoder only ever produces json.Number here, so the literal
+// text the sender wrote is still available: nothing has been rounded yet.
+func numbers(v any, mode string) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for key, child := range t {
+			t[key] = numbers(child, mode)
+		}
+		return t
+	case []any:
+		for i, child := range t {
+			t[i] = numbers(child, mode)
+		}
+		return t
+	case json.Number:
+		return number(t, mode)
+	default:
+		return v
+	}
+}
+
+func number(n json.Number, mode string) any {
+	switch mode {
+	case NumbersString:
+		return n.String()
+	case NumbersInt64:
+		// An integer that fits becomes one. Anything else — a fraction, or an
+		// integer past int64 — stays the exact literal rather than becoming a
+		// float64, which is the rounding this setting exists to avoid.
+		if i, err := strconv.ParseInt(n.String(), 10, 64); err == nil {
+			return i
+		}
+		return n
+	case NumbersDecimal:
+		return n
+	default:
+		f, err := n.Float64()
+		if err != nil {
+			return n
+		}
+		return f
+	}
+}
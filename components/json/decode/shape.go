@@ -0,0 +1,110 @@
+package decode
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
+)
+
+// Violation is one place where a decoded document departs from the decoded
+// shape.
+type Violation struct {
+	Path     string `json:"path" title:"Path" description:"JSON Pointer to the offending value. Empty for the document itself."`
+	Expected string `json:"expected" title:"Expected" description:"The type the decoded shape declares here."`
+	Actual   string `json:"actual" title:"Actual" description:"The type the document carries here, or missing when the key is absent."`
+}
+
+// shapeError carries the violations so the error port can list them rather
+// than leave the sender to parse a sentence.
+type shapeError struct {
+	violations []Violation
+}
+
+func (e *shapeError) Error() string {
+	first := e.violations[0]
+	path := first.Path
+	if path == "" {
+		path = "the document"
+	}
+	msg := fmt.Sprintf("decoded document does not match the decoded shape: %s is %s, want %s", path, first.Actual, first.Expected)
+	if len(e.violations) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.violations)-1)
+	}
+	return msg
+}
+
+// conform checks value against the example. The example is a contract only as
+// far as it goes: a key it names must be present with the same type, an array
+// element must look like its first element, and anything it leaves open — a
+// null, an empty object, an empty array — accepts whatever arrives. Keys the
+// example does not name are allowed, since an example is written from the
+// fields a flow reads, not from everything a sender might add.
+func conform(example, value any, path string, violations []Violation) []Violation {
+	if example == nil {
+		return violations
+	}
+	want, got := typeOf(example), typeOf(value)
+	if want != got {
+		return append(violations, Violation{Path: path, Expected: want, Actual: got})
+	}
+
+	switch ex := example.(type) {
+	case map[string]any:
+		obj := value.(map[string]any)
+		// Sorted so the same document reports the same violations in the same
+		// order every time, which is what makes the list diffable.
+		keys := make([]string, 0, len(ex))
+		for key := range ex {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "/" + pointer.Escape(key)
+			v, ok := obj[key]
+			if !ok {
+				violations = append(violations, Violation{Path: child, Expected: expected(ex[key]), Actual: "missing"})
+				continue
+			}
+			violations = conform(ex[key], v, child, violations)
+		}
+	case []any:
+		if len(ex) == 0 {
+			return violations
+		}
+		for i, v := range value.([]any) {
+			violations = conform(ex[0], v, path+"/"+strconv.Itoa(i), violations)
+		}
+	}
+	return violations
+}
+
+// expected names what a missing key should have held. A null in the example
+// declares the key without declaring its type.
+func expected(example any) string {
+	if example == nil {
+		return "any"
+	}
+	return typeOf(example)
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, float32, int, int64, int32, json.Number:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,131 @@
+package decode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/module/module"
+)
+
+// Item is what the response port carries in stream mode: one element of the
+// top-level array.
+type Item struct {
+	Context Context `json:"context"`
+	Index   int     `json:"index" title:"Index" description:"0-based position of the element in the array."`
+	Decoded Decoded `json:"decoded" configurable:"true"`
+}
+
+// Summary is emitted once, after the last item, so the flow can tell a stream
+// that ended from one that is still running.
+type Summary struct {
+	Context   Context     `json:"context"`
+	Count     int         `json:"count" title:"Count" description:"Items emitted on the response port."`
+	Truncated bool        `json:"truncated" title:"Truncated" description:"True when the array had more elements than maxRecords and the rest were not emitted."`
+	Complete  bool        `json:"complete" title:"Complete" description:"False when the input broke off or was malformed partway through. The items already emitted are the ones before that point, and the last entry in errors says where."`
+	Errors    []ItemError `json:"errors" title:"Item Errors" description:"Elements that were skipped, and why."`
+}
+
+// ItemError is one element that was not emitted.
+type ItemError struct {
+	Index int    `json:"index" title:"Index" description:"Position of the element, or of the point where the array stopped parsing."`
+	Error string `json:"error" title:"Error"`
+}
+
+// handleStream reads the top-level array one element at a time and emits each
+// as it is read. Nothing holds the whole array: json_decode followed by
+// array_split would materialise it twice, which for an export of a few
+// hundred megabytes is the difference between running and being killed.
+//
+// Each element goes through the same decode as a whole document would —
+// unwrap, select, shape — so a flow switching to stream mode keeps its
+// settings.
+func (h *Component) handleStream(ctx context.Context, handler module.Handler, in Request) module.Result {
+	maxRecords := h.settings.MaxRecords
+	if maxRecords <= 0 {
+		maxRecords = defaultMaxRecords
+	}
+
+	summary := Summary{
+		Context: in.Context,
+		Errors:  []ItemError{},
+	}
+
+	// Elements are framed by the regular decoder, which reads strict JSON
+	// only: a comment or trailing comma would fail partway through, so a
+	// lenient dialect is refused before anything is emitted.
+	if h.settings.Dialect != "" && h.settings.Dialect != DialectStrict {
+		summary.Errors = append(summary.Errors, ItemError{Index: 0, Error: fmt.Sprintf("stream mode reads strict JSON only, not %s: use document or lines mode for it", h.settings.Dialect)})
+		return handler(ctx, SummaryPort, summary)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(in.Encoded))
+	if err := open(dec); err != nil {
+		summary.Errors = append(summary.Errors, ItemError{Index: 0, Error: err.Error()})
+		return handler(ctx, SummaryPort, summary)
+	}
+
+	index := 0
+	for ; dec.More(); index++ {
+		if err := ctx.Err(); err != nil {
+			return module.Fail(err)
+		}
+		if summary.Count >= maxRecords {
+			summary.Truncated = true
+			summary.Complete = true
+			return handler(ctx, SummaryPort, summary)
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			summary.Errors = append(summary.Errors, ItemError{Index: index, Error: err.Error()})
+			return handler(ctx, SummaryPort, summary)
+		}
+		value, err := h.decode(raw)
+		if err != nil {
+			if len(summary.Errors) < maxLineErrors {
+				summary.Errors = append(summary.Errors, ItemError{Index: index, Error: err.Error()})
+			}
+			continue
+		}
+
+		if res := h.respond(ctx, handler, in.Context, Item{Context: in.Context, Index: index, Decoded: value}, value); res.Err() != nil {
+			return res
+		}
+		summary.Count++
+	}
+
+	if err := closeArray(dec); err != nil {
+		summary.Errors = append(summary.Errors, ItemError{Index: index, Error: err.Error()})
+		return handler(ctx, SummaryPort, summary)
+	}
+	summary.Complete = true
+	return handler(ctx, SummaryPort, summary)
+}
+
+func open(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("stream mode: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("stream mode expects a top-level array, found %v", tok)
+	}
+	return nil
+}
+
+func closeArray(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("array is not closed: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != ']' {
+		return fmt.Errorf("expected the end of the array, found %v", tok)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("content after the top-level array")
+	}
+	return nil
+}
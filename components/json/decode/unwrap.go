@@ -0,0 +1,167 @@
+package decode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
+)
+
+const (
+	defaultUnwrapDepth = 3
+
+	// wildcard in an unwrap path matches every key of an object or element of
+	// an array, for a list of events that each carry an encoded body.
+	wildcard = "*"
+)
+
+// unwrap replaces string fields holding encoded JSON with what they encode.
+// It runs before select, so a selection can reach into an unwrapped payload.
+func (h *Component) unwrap(doc any) (any, error) {
+	depth := h.settings.UnwrapDepth
+	if depth <= 0 {
+		depth = defaultUnwrapDepth
+	}
+	if h.settings.UnwrapAll {
+		return h.unwrapAll(doc, depth), nil
+	}
+	for _, path := range h.settings.UnwrapPaths {
+		tokens, err := pointer.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = h.unwrapAt(doc, tokens, path, depth); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// unwrapAt follows tokens down to the configured field. A path the document
+// does not have is skipped: senders omit optional fields, and that is not the
+// kind of mistake this setting is for.
+func (h *Component) unwrapAt(node any, tokens []string, path string, depth int) (any, error) {
+	if len(tokens) == 0 {
+		s, ok := node.(string)
+		if !ok {
+			// Already structured — a sender that stopped double-encoding
+			// should not break the flow that worked around it.
+			return node, nil
+		}
+		v, _, err := h.peel(s, depth)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap %s: %w", path, err)
+		}
+		return v, nil
+	}
+
+	token, rest := tokens[0], tokens[1:]
+	var err error
+	switch n := node.(type) {
+	case map[string]any:
+		if token == wildcard {
+			for key, child := range n {
+				if n[key], err = h.unwrapAt(child, rest, path, depth); err != nil {
+					return nil, err
+				}
+			}
+			return n, nil
+		}
+		if child, ok := n[token]; ok {
+			if n[token], err = h.unwrapAt(child, rest, path, depth); err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		if token == wildcard {
+			for i, child := range n {
+				if n[i], err = h.unwrapAt(child, rest, path, depth); err != nil {
+					return nil, err
+				}
+			}
+			return n, nil
+		}
+		if i, ierr := pointer.Index(token, len(n)); ierr == nil {
+			if n[i], err = h.unwrapAt(n[i], rest, path, depth); err != nil {
+				return nil, err
+			}
+		}
+	case string:
+		// The path runs through a string: an encoded parent the author did
+		// not list separately. Peel it so the rest of the path can be
+		// followed.
+		v, layers, perr := h.peel(n, depth)
+		if perr != nil || layers == 0 {
+			return node, nil
+		}
+		return h.unwrapAt(v, tokens, path, depth-layers)
+	}
+	return node, nil
+}
+
+// unwrapAll walks the whole tree. A string that fails to decode is left as it
+// was: unlike a configured path, nobody said this one was JSON.
+func (h *Component) unwrapAll(node any, depth int) any {
+	switch n := node.(type) {
+	case map[string]any:
+		for key, child := range n {
+			n[key] = h.unwrapAll(child, depth)
+		}
+	case []any:
+		for i, child := range n {
+			n[i] = h.unwrapAll(child, depth)
+		}
+	case string:
+		v, layers, err := h.peel(n, depth)
+		if err != nil || layers == 0 {
+			return node
+		}
+		return h.unwrapAll(v, depth-layers)
+	}
+	return node
+}
+
+// peel decodes s for as long as it holds an encoded object or array, up to
+// depth layers, and reports how many it took off. A string holding an encoded
+// string is a layer only when that inner string is itself an object or array:
+// "\"quoted\"" is a quoted word, not a payload.
+func (h *Component) peel(s string, depth int) (any, int, error) {
+	var v any = s
+	layers := 0
+	for layers < depth {
+		str, ok := v.(string)
+		if !ok {
+			break
+		}
+		trimmed := strings.TrimSpace(str)
+		if trimmed == "" {
+			break
+		}
+		switch trimmed[0] {
+		case '{', '[':
+		case '"':
+			inner, err := h.unmarshal([]byte(trimmed))
+			if err != nil {
+				return v, layers, nil
+			}
+			next, ok := inner.(string)
+			if !ok || !structured(next) {
+				return v, layers, nil
+			}
+		default:
+			return v, layers, nil
+		}
+		next, err := h.unmarshal([]byte(trimmed))
+		if err != nil {
+			return nil, layers, err
+		}
+		v = next
+		layers++
+	}
+	return v, layers, nil
+}
+
+func structured(s string) bool {
+	s = strings.TrimSpace(s)
+	return s != "" && (s[0] == '{' || s[0] == '[')
+}
@@ -0,0 +1,445 @@
+// Package diff compares two JSON documents structurally.
+//
+// Comparing API responses across environments, or a live config against the
+// one in git, came down to comparing two json_encode outputs as strings — which
+// says only that something differs, and says it for key order alone. This
+// names each difference by path, with both values, and can be told what not
+// to count: volatile fields, array order, float noise.
+package diff
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "json_diff"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+
+	KindAdded   = "added"
+	KindRemoved = "removed"
+	KindChanged = "changed"
+
+	defaultMaxDifferences = 1000
+
+	// wildcard in a path matches every key of an object or element of an
+	// array, as it does in json_decode's unwrap paths.
+	wildcard = "*"
+)
+
+type Context any
+
+type Document any
+
+type Request struct {
+	Context Context  `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the differences"`
+	Left    Document `json:"left" required:"true" configurable:"true" title:"Left" description:"The document compared from — the baseline, the expected config, the production response."`
+	Right   Document `json:"right" required:"true" configurable:"true" title:"Right" description:"The document compared to."`
+}
+
+type Response struct {
+	Context     Context      `json:"context,omitempty" title:"Context"`
+	Equal       bool         `json:"equal" title:"Equal" description:"True when no difference was found, after ignored paths and tolerance. Branch on this."`
+	Differences []Difference `json:"differences" title:"Differences"`
+	Count       int          `json:"count" title:"Count" description:"Differences found, including any left out of differences by maxDifferences."`
+	Truncated   bool         `json:"truncated" title:"Truncated" description:"True when there were more differences than maxDifferences."`
+}
+
+// Difference is one place the documents disagree.
+type Difference struct {
+	Kind  string `json:"kind" enum:"added,removed,changed" title:"Kind" description:"Added: only right has it. Removed: only left has it. Changed: both have it, with different values."`
+	Path  string `json:"path" title:"Path" description:"JSON Pointer to the value. In an array compared by key, the index is left's for removed and changed elements and right's for added ones."`
+	Left  any    `json:"left" title:"Left" description:"Left's value; null when added."`
+	Right any    `json:"right" title:"Right" description:"Right's value; null when removed."`
+	Key   any    `json:"key,omitempty" title:"Key" description:"The element's key, in an array compared by key."`
+}
+
+// ArrayKey says how to compare the arrays at a path.
+type ArrayKey struct {
+	Path string `json:"path" required:"true" title:"Path" description:"JSON Pointer to the array, such as /items or /services/*/ports (* matches every key or element)."`
+	Key  string `json:"key" title:"Key" description:"Field that identifies an element, such as id. Elements with the same key are compared with each other wherever they sit. Empty compares the array as a set of whole values: order is ignored and each element is either in both or not."`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+type Settings struct {
+	IgnorePaths     []string   `json:"ignorePaths" title:"Ignore Paths" description:"JSON Pointers left out of the comparison, such as /updatedAt or /items/*/etag — fields expected to differ between environments or runs."`
+	UnorderedArrays []ArrayKey `json:"unorderedArrays" title:"Unordered Arrays" description:"Arrays whose order does not matter. Every other array is compared position by position."`
+	Tolerance       float64    `json:"tolerance" title:"Numeric Tolerance" description:"Numbers this close count as equal, such as 0.000001 for values that went through float arithmetic on one side. 0 compares numbers exactly."`
+	MaxDifferences  int        `json:"maxDifferences" default:"1000" title:"Max Differences" description:"Most differences listed for one comparison. Count still says how many there were."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "JSON Diff",
+		Info: "Compares a left and a right document and lists each difference — added, removed or changed — by JSON " +
+			"Pointer, with both values. Object key order never counts. Branch on equal. " +
+			"List volatile fields such as timestamps and etags in ignorePaths, name arrays whose order does not matter " +
+			"in unorderedArrays (by a key field such as id, or as plain sets), and set a numeric tolerance for values " +
+			"that went through float arithmetic. For a patch that turns one document into the other, use json_patch " +
+			"in generate mode instead.",
+		Tags: []string{"json"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+	in, ok := msg.(Request)
+	if !ok {
+		return module.Fail(fmt.Errorf("invalid message"))
+	}
+
+	cmp, err := c.comparer()
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, err)
+	}
+	left, err := value.Normalize(in.Left)
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, fmt.Errorf("left: %w", err))
+	}
+	right, err := value.Normalize(in.Right)
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, fmt.Errorf("right: %w", err))
+	}
+
+	cmp.compare([]string{}, left, right)
+	return handler(ctx, ResponsePort, Response{
+		Context:     in.Context,
+		Equal:       cmp.count == 0,
+		Differences: cmp.diffs,
+		Count:       cmp.count,
+		Truncated:   cmp.count > len(cmp.diffs),
+	})
+}
+
+func (c *Component) comparer() (*comparer, error) {
+	cmp := &comparer{
+		tolerance: c.settings.Tolerance,
+		max:       c.settings.MaxDifferences,
+		diffs:     []Difference{},
+	}
+	if cmp.max <= 0 {
+		cmp.max = defaultMaxDifferences
+	}
+	if cmp.tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+	for _, path := range c.settings.IgnorePaths {
+		tokens, err := pointer.Parse(path)
+		if err != nil {
+			return nil, fmt.Errorf("ignore path: %w", err)
+		}
+		cmp.ignore = append(cmp.ignore, tokens)
+	}
+	for _, ak := range c.settings.UnorderedArrays {
+		tokens, err := pointer.Parse(ak.Path)
+		if err != nil {
+			return nil, fmt.Errorf("unordered array: %w", err)
+		}
+		cmp.unordered = append(cmp.unordered, unordered{path: tokens, key: ak.Key})
+	}
+	return cmp, nil
+}
+
+type unordered struct {
+	path []string
+	key  string
+}
+
+// comparer walks both documents together, collecting differences.
+type comparer struct {
+	ignore    [][]string
+	unordered []unordered
+	tolerance float64
+	max       int
+
+	diffs []Difference
+	count int
+}
+
+func (c *comparer) compare(path []string, left, right any) {
+	if c.ignored(path) {
+		return
+	}
+	switch l := left.(type) {
+	case map[string]any:
+		if r, ok := right.(map[string]any); ok {
+			c.compareObjects(path, l, r)
+			return
+		}
+	case []any:
+		if r, ok := right.([]any); ok {
+			if u, ok := c.unorderedAt(path); ok {
+				c.compareUnordered(path, l, r, u.key)
+			} else {
+				c.compareOrdered(path, l, r)
+			}
+			return
+		}
+	}
+	if !c.same(left, right) {
+		c.add(Difference{Kind: KindChanged, Path: pointer.Format(path), Left: left, Right: right})
+	}
+}
+
+// compareObjects goes through keys in sorted order, so the same two documents
+// always list their differences the same way.
+func (c *comparer) compareObjects(path []string, left, right map[string]any) {
+	keys := make([]string, 0, len(left)+len(right))
+	for key := range left {
+		keys = append(keys, key)
+	}
+	for key := range right {
+		if _, ok := left[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		p := child(path, key)
+		lv, inLeft := left[key]
+		rv, inRight := right[key]
+		switch {
+		case !inRight:
+			c.addUnlessIgnored(p, Difference{Kind: KindRemoved, Left: lv})
+		case !inLeft:
+			c.addUnlessIgnored(p, Difference{Kind: KindAdded, Right: rv})
+		default:
+			c.compare(p, lv, rv)
+		}
+	}
+}
+
+func (c *comparer) compareOrdered(path []string, left, right []any) {
+	for i := 0; i < len(left) || i < len(right); i++ {
+		p := child(path, strconv.Itoa(i))
+		switch {
+		case i >= len(right):
+			c.addUnlessIgnored(p, Difference{Kind: KindRemoved, Left: left[i]})
+		case i >= len(left):
+			c.addUnlessIgnored(p, Difference{Kind: KindAdded, Right: right[i]})
+		default:
+			c.compare(p, left[i], right[i])
+		}
+	}
+}
+
+// compareUnordered pairs elements by key, or by being equal when there is no
+// key or an element lacks it. A pair with the same key is compared field by
+// field; anything left unpaired was added or removed.
+func (c *comparer) compareUnordered(path []string, left, right []any, key string) {
+	paired := make([]bool, len(right))
+	for i, lv := range left {
+		lk, hasKey := keyOf(lv, key)
+		match := -1
+		for j, rv := range right {
+			if paired[j] {
+				continue
+			}
+			if hasKey {
+				if rk, ok := keyOf(rv, key); ok && value.Equal(lk, rk) {
+					match = j
+					break
+				}
+			} else if c.equal(child(path, strconv.Itoa(i)), lv, rv) {
+				match = j
+				break
+			}
+		}
+		p := child(path, strconv.Itoa(i))
+		if match < 0 {
+			c.addUnlessIgnored(p, Difference{Kind: KindRemoved, Left: lv, Key: lk})
+			continue
+		}
+		paired[match] = true
+		before := len(c.diffs)
+		c.compare(p, lv, right[match])
+		// Differences inside a keyed element are attributed to its key, so a
+		// reader can find the element without working out the index.
+		for k := before; k < len(c.diffs); k++ {
+			c.diffs[k].Key = lk
+		}
+	}
+	for j, rv := range right {
+		if !paired[j] {
+			rk, _ := keyOf(rv, key)
+			c.addUnlessIgnored(child(path, strconv.Itoa(j)), Difference{Kind: KindAdded, Right: rv, Key: rk})
+		}
+	}
+}
+
+func keyOf(element any, key string) (any, bool) {
+	if key == "" {
+		return nil, false
+	}
+	obj, ok := element.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	id, ok := obj[key]
+	return id, ok && id != nil
+}
+
+// equal reports whether two values at path have no difference at all, under
+// the same rules compare uses: ignored paths and unordered arrays below path
+// count as they would for the pair.
+func (c *comparer) equal(path []string, left, right any) bool {
+	probe := &comparer{ignore: c.ignore, unordered: c.unordered, tolerance: c.tolerance, max: 1}
+	probe.compare(path, left, right)
+	return probe.count == 0
+}
+
+func (c *comparer) same(left, right any) bool {
+	ln, lok := left.(json.Number)
+	rn, rok := right.(json.Number)
+	if !lok || !rok || c.tolerance == 0 {
+		return value.Equal(left, right)
+	}
+	lr, lok := new(big.Rat).SetString(string(ln))
+	rr, rok := new(big.Rat).SetString(string(rn))
+	if !lok || !rok {
+		return value.Equal(left, right)
+	}
+	delta, _ := new(big.Rat).Sub(lr, rr).Float64()
+	if delta < 0 {
+		delta = -delta
+	}
+	return delta <= c.tolerance
+}
+
+func (c *comparer) addUnlessIgnored(path []string, d Difference) {
+	if c.ignored(path) {
+		return
+	}
+	d.Path = pointer.Format(path)
+	c.add(d)
+}
+
+func (c *comparer) add(d Difference) {
+	c.count++
+	if len(c.diffs) < c.max {
+		c.diffs = append(c.diffs, d)
+	}
+}
+
+func (c *comparer) ignored(path []string) bool {
+	for _, pattern := range c.ignore {
+		if matches(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *comparer) unorderedAt(path []string) (unordered, bool) {
+	for _, u := range c.unordered {
+		if matches(u.path, path) {
+			return u, true
+		}
+	}
+	return unordered{}, false
+}
+
+func matches(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i, token := range pattern {
+		if token != wildcard && token != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func child(path []string, token string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), token)
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+func (c *Component) Ports() []module.Port {
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: Request{},
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: Response{},
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
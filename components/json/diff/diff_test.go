@@ -0,0 +1,190 @@
+package diff
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func compare(t *testing.T, left, right string, settings Settings) Response {
+	t.Helper()
+	port, msg, err := run(t, Request{Left: parse(t, left), Right: parse(t, right)}, settings)
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if port != ResponsePort {
+		t.Fatalf("emitted on %q", port)
+	}
+	return msg.(Response)
+}
+
+func parse(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// summary is each difference as "kind path", for comparing in one line.
+func summary(out Response) []string {
+	s := make([]string, len(out.Differences))
+	for i, d := range out.Differences {
+		s[i] = d.Kind + " " + d.Path
+	}
+	return s
+}
+
+func expect(t *testing.T, out Response, want ...string) {
+	t.Helper()
+	got := summary(out)
+	if len(got) != len(want) {
+		t.Fatalf("differences = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("differences = %v, want %v", got, want)
+		}
+	}
+	if out.Equal != (len(want) == 0) || out.Count != len(want) {
+		t.Fatalf("equal = %v, count = %d with %d differences", out.Equal, out.Count, len(want))
+	}
+}
+
+// Key order and number spelling are how two encoders differ, not how two
+// documents do.
+func TestEqualDocuments(t *testing.T) {
+	out := compare(t, `{"a":1,"b":[true,null,"x"],"c":{"d":1.0}}`, `{"c":{"d":1},"b":[true,null,"x"],"a":1e0}`, Settings{})
+	expect(t, out)
+}
+
+func TestAddedRemovedChanged(t *testing.T) {
+	out := compare(t,
+		`{"name":"api","replicas":2,"env":{"LOG":"debug","OLD":"1"},"ports":[80,443]}`,
+		`{"name":"api","replicas":3,"env":{"LOG":"debug","NEW":"2"},"ports":[80,443,8080]}`,
+		Settings{})
+	expect(t, out, "added /env/NEW", "removed /env/OLD", "added /ports/2", "changed /replicas")
+
+	d := out.Differences[3]
+	if d.Left != json.Number("2") || d.Right != json.Number("3") {
+		t.Errorf("changed replicas: left = %v, right = %v", d.Left, d.Right)
+	}
+	if out.Differences[0].Left != nil || out.Differences[1].Right != nil {
+		t.Errorf("added/removed carry a value on the missing side: %+v", out.Differences[:2])
+	}
+}
+
+// A value replaced by one of another type is one change, not a list of
+// everything inside it.
+func TestTypeChangeIsOneDifference(t *testing.T) {
+	out := compare(t, `{"a":{"b":1,"c":2}}`, `{"a":[1,2]}`, Settings{})
+	expect(t, out, "changed /a")
+}
+
+func TestPathsAreEscaped(t *testing.T) {
+	out := compare(t, `{"a/b":{"m~n":1}}`, `{"a/b":{"m~n":2}}`, Settings{})
+	expect(t, out, "changed /a~1b/m~0n")
+}
+
+func TestIgnorePaths(t *testing.T) {
+	left := `{"updatedAt":"mon","items":[{"id":1,"etag":"a","qty":1},{"id":2,"etag":"b","qty":1}]}`
+	right := `{"updatedAt":"tue","items":[{"id":1,"etag":"c","qty":1},{"id":2,"etag":"d","qty":5}]}`
+	out := compare(t, left, right, Settings{IgnorePaths: []string{"/updatedAt", "/items/*/etag"}})
+	expect(t, out, "changed /items/1/qty")
+}
+
+// An ignored path is ignored when it is only on one side, too.
+func TestIgnoredAddition(t *testing.T) {
+	out := compare(t, `{}`, `{"traceId":"x"}`, Settings{IgnorePaths: []string{"/traceId"}})
+	expect(t, out)
+}
+
+func TestOrderedArraysCompareByPosition(t *testing.T) {
+	out := compare(t, `[1,2,3]`, `[1,3]`, Settings{})
+	expect(t, out, "changed /1", "removed /2")
+}
+
+// Elements with the same key are compared wherever they sit, and differences
+// inside them carry the key.
+func TestUnorderedArrayByKey(t *testing.T) {
+	left := `{"users":[{"id":"a","role":"admin"},{"id":"b","role":"dev"},{"id":"c","role":"dev"}]}`
+	right := `{"users":[{"id":"c","role":"dev"},{"id":"d","role":"ops"},{"id":"a","role":"owner"}]}`
+	out := compare(t, left, right, Settings{UnorderedArrays: []ArrayKey{{Path: "/users", Key: "id"}}})
+	expect(t, out, "changed /users/0/role", "removed /users/1", "added /users/1")
+
+	if out.Differences[0].Key != "a" || out.Differences[1].Key != "b" || out.Differences[2].Key != "d" {
+		t.Errorf("keys = %v, %v, %v", out.Differences[0].Key, out.Differences[1].Key, out.Differences[2].Key)
+	}
+	if out.Differences[2].Right.(map[string]any)["role"] != "ops" {
+		t.Errorf("added element = %v", out.Differences[2].Right)
+	}
+}
+
+func TestUnorderedArrayAsSet(t *testing.T) {
+	settings := Settings{UnorderedArrays: []ArrayKey{{Path: "/tags"}}}
+	expect(t, compare(t, `{"tags":["a","b","c"]}`, `{"tags":["c","a","b"]}`, settings))
+	expect(t, compare(t, `{"tags":["a","b","b"]}`, `{"tags":["b","a","x"]}`, settings), "removed /tags/2", "added /tags/2")
+}
+
+// Elements of a set pair up under the rules that apply where they sit, so
+// ones that differ only in an ignored field, or in the order of a nested
+// unordered array, are the same element.
+func TestUnorderedArrayAsSetUsesElementPath(t *testing.T) {
+	left := `{"items":[{"sku":"a","updatedAt":1,"tags":["x","y"]},{"sku":"b","updatedAt":2,"tags":[]}]}`
+	right := `{"items":[{"sku":"b","updatedAt":3,"tags":[]},{"sku":"a","updatedAt":4,"tags":["y","x"]}]}`
+	settings := Settings{
+		IgnorePaths:     []string{"/items/*/updatedAt"},
+		UnorderedArrays: []ArrayKey{{Path: "/items"}, {Path: "/items/*/tags"}},
+	}
+	expect(t, compare(t, left, right, settings))
+}
+
+func TestUnorderedArrayPathWildcard(t *testing.T) {
+	left := `{"services":{"web":{"ports":[80,443]},"db":{"ports":[5432]}}}`
+	right := `{"services":{"web":{"ports":[443,80]},"db":{"ports":[5432]}}}`
+	out := compare(t, left, right, Settings{UnorderedArrays: []ArrayKey{{Path: "/services/*/ports"}}})
+	expect(t, out)
+}
+
+func TestTolerance(t *testing.T) {
+	left, right := `{"price":0.30000000000000004,"qty":3}`, `{"price":0.3,"qty":3.0000001}`
+	expect(t, compare(t, left, right, Settings{}), "changed /price", "changed /qty")
+	expect(t, compare(t, left, right, Settings{Tolerance: 0.000001}))
+	expect(t, compare(t, left, right, Settings{Tolerance: 1e-12}), "changed /qty")
+}
+
+func TestMaxDifferencesTruncates(t *testing.T) {
+	out := compare(t, `[1,2,3,4,5]`, `[6,7,8,9,10]`, Settings{MaxDifferences: 2})
+	if len(out.Differences) != 2 || out.Count != 5 || !out.Truncated || out.Equal {
+		t.Fatalf("out = %+v", out)
+	}
+}
+
+func TestBadIgnorePathIsAnError(t *testing.T) {
+	port, msg, err := run(t, Request{Left: 1, Right: 1}, Settings{IgnorePaths: []string{"no-slash"}, EnableErrorPort: true})
+	if err != nil || port != ErrorPort || msg.(Error).Error == "" {
+		t.Fatalf("port = %q, msg = %v, err = %v", port, msg, err)
+	}
+}
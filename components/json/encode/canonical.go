@@ -0,0 +1,165 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/goccy/go-json"
+)
+
+// canonical writes v as RFC 8785 (JSON Canonicalization Scheme): no
+// whitespace, keys sorted by UTF-16 code units, strings with only the escapes
+// JSON requires, and every number spelled the way ECMAScript prints a double.
+//
+// Those are the rules a verifier in any language can reproduce, which is the
+// whole point — a signature over bytes only one encoder can produce is a
+// signature nobody else can check.
+func canonical(w *bytes.Buffer, v any) error {
+	switch t := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		if t {
+			w.WriteString("true")
+		} else {
+			w.WriteString("false")
+		}
+	case json.Number:
+		s, err := esNumber(t.String())
+		if err != nil {
+			return err
+		}
+		w.WriteString(s)
+	case string:
+		canonicalString(w, t)
+	case []any:
+		w.WriteByte('[')
+		for i, item := range t {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			if err := canonical(w, item); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(']')
+	case object:
+		sort.SliceStable(t, func(i, j int) bool { return utf16Less(t[i].key, t[j].key) })
+		w.WriteByte('{')
+		for i, m := range t {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			canonicalString(w, m.key)
+			w.WriteByte(':')
+			if err := canonical(w, m.value); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('}')
+	default:
+		return fmt.Errorf("cannot canonicalize %T", v)
+	}
+	return nil
+}
+
+// utf16Less orders keys the way JavaScript compares strings. It differs from
+// byte order only above the Basic Multilingual Plane, which is exactly where
+// an implementation that sorted by bytes would disagree with everyone else.
+func utf16Less(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+func canonicalString(w *bytes.Buffer, s string) {
+	w.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			w.WriteString(`\"`)
+		case '\\':
+			w.WriteString(`\\`)
+		case '\b':
+			w.WriteString(`\b`)
+		case '\f':
+			w.WriteString(`\f`)
+		case '\n':
+			w.WriteString(`\n`)
+		case '\r':
+			w.WriteString(`\r`)
+		case '\t':
+			w.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				writeEscape(w, r)
+				continue
+			}
+			w.WriteRune(r)
+		}
+	}
+	w.WriteByte('"')
+}
+
+// esNumber spells a number as ECMAScript's Number.prototype.toString would.
+// RFC 8785 defines numbers as IEEE 754 doubles, so an integer past 2^53 is
+// rounded here by design: that is the value every other implementation will
+// sign, too.
+func esNumber(literal string) (string, error) {
+	f, err := strconv.ParseFloat(literal, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", fmt.Errorf("number %s cannot be canonicalized: it is outside the range of a double", literal)
+	}
+	if f == 0 {
+		return "0", nil // including -0
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// Shortest round-tripping digits and the decimal exponent, as in
+	// d.ddde±x. ECMAScript's n is the position of the decimal point relative
+	// to those digits.
+	sci := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp, _ := strings.Cut(sci, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	e, _ := strconv.Atoi(exp)
+	k, n := len(digits), e+1
+
+	var out string
+	switch {
+	case k <= n && n <= 21:
+		out = digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		out = digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		out = "0." + strings.Repeat("0", -n) + digits
+	default:
+		expSign := "+"
+		if n-1 < 0 {
+			expSign = "-"
+		}
+		abs := n - 1
+		if abs < 0 {
+			abs = -abs
+		}
+		out = digits[:1]
+		if k > 1 {
+			out += "." + digits[1:]
+		}
+		out += "e" + expSign + strconv.Itoa(abs)
+	}
+	return sign + out, nil
+}
@@ -3,6 +3,9 @@ package encode
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
 	"github.com/goccy/go-json"
 	"github.com/tiny-systems/module/api/v1alpha1"
@@ -15,12 +18,42 @@ const (
 	RequestPort   = "request"
 	ResponsePort  = "response"
 	ErrorPort     = "error"
+
+	OutputDocument = "document"
+	OutputLines    = "lines"
+	OutputSequence = "sequence"
+
+	// recordSeparator opens every record of an RFC 7464 JSON text sequence.
+	recordSeparator = 0x1E
+
+	DigestNone   = "none"
+	DigestSHA256 = "sha256"
+	DigestSHA512 = "sha512"
 )
 
 type Context any
 
 type Settings struct {
 	EnableErrorPort bool `json:"enableErrorPort" required:"true" title:"Enable Error Port" description:"If error happen, error port will emit an error message"`
+
+	Output string `json:"output" default:"document" enum:"document,lines,sequence" enumTitles:"One JSON document|JSON Lines (NDJSON)|JSON text sequence (RFC 7464)" title:"Output" description:"Lines writes each element of an array document as one compact JSON value per line, for Elasticsearch _bulk, BigQuery load jobs and log files in object storage. Sequence frames each element with a record separator instead. Both refuse a document that is not an array."`
+
+	Include   []string `json:"include" title:"Include" description:"JSON Pointers to keep, such as /id or /items/*/name (* matches every key or element). Everything else is dropped; the objects and arrays leading to a kept value stay. Empty keeps the whole document. With lines or sequence output the paths apply to each element."`
+	Exclude   []string `json:"exclude" title:"Exclude" description:"JSON Pointers to drop, such as /internal/token or /users/*/password — keep secrets out of an outbound payload at the step that writes it. Applied after include."`
+	OmitNull  bool     `json:"omitNull" title:"Omit Null" description:"Drop object members whose value is null."`
+	OmitEmpty bool     `json:"omitEmpty" title:"Omit Empty" description:"Drop object members that are null, an empty string, an empty array or an empty object, including objects left empty by this. False and 0 are values and are kept. Array elements are never dropped, so positions stay meaningful."`
+	KeyCase   string   `json:"keyCase" default:"none" enum:"none,camel,pascal,snake,kebab" enumTitles:"As given|camelCase|PascalCase|snake_case|kebab-case" title:"Key Case" description:"Rename every object key, at every depth, to the casing the receiving API expects. Include and exclude paths use the keys as they arrive. Two keys that would end up the same are an error."`
+
+	Indent   string `json:"indent" default:"none" enum:"none,2,4,tab" enumTitles:"None (compact)|2 spaces|4 spaces|Tab" title:"Indent" description:"Pretty-print for output a person reads — an attachment, a stored config. Leave compact for anything a machine consumes."`
+	KeyOrder string `json:"keyOrder" default:"sorted" enum:"sorted,given" enumTitles:"Sorted|As given" title:"Key Order" description:"Sorted writes every object's keys in order, so the same document always encodes the same way. As given keeps the order a value carries — raw JSON or a struct; an object built in the flow has none and comes out sorted either way."`
+
+	// Phrased as the negative because escaping is what json_encode has always
+	// done, and a bool's zero value has to keep it.
+	NoHTMLEscape bool `json:"noHTMLEscape" title:"Do Not Escape HTML Characters" description:"Off (default): <, > and & are written as \\u003c, \\u003e and \\u0026, so the output is safe to embed in HTML. On: they are written as they are, for consumers that compare or display the text literally."`
+	ASCIIOnly    bool `json:"asciiOnly" title:"ASCII Only" description:"Write every non-ASCII character as a \\u escape, for legacy receivers that mangle UTF-8. The JSON means the same either way."`
+
+	Canonical bool   `json:"canonical" title:"Canonical (RFC 8785)" description:"Write the JSON Canonicalization Scheme: no whitespace, keys sorted by UTF-16 code units, minimal string escaping, numbers as ECMAScript prints them. The same document then always produces the same bytes, in any language — what a signature, a hash or an idempotency key needs. Overrides indent, key order and escaping. Numbers are IEEE doubles under this scheme, so integers past 2^53 are rounded as every other implementation rounds them."`
+	Digest    string `json:"digest" default:"none" enum:"none,sha256,sha512" enumTitles:"None|SHA-256|SHA-512" title:"Digest" description:"Also emit a hex digest of the encoded bytes. Pair it with canonical so another party can reproduce it."`
 }
 
 type Error struct {
@@ -36,6 +69,8 @@ type Request struct {
 type Response struct {
 	Context Context `json:"context"`
 	Encoded string  `json:"encoded"`
+	Digest  string  `json:"digest,omitempty" title:"Digest" description:"Lower-case hex digest of encoded, when the digest setting is on."`
+	Count   int     `json:"count" title:"Count" description:"Values written: one for a document, one per element for lines and sequence output."`
 }
 
 type Component struct {
@@ -46,7 +81,7 @@ func (h *Component) GetInfo() module.ComponentInfo {
 	return module.ComponentInfo{
 		Name:        ComponentName,
 		Description: "JSON Encoder",
-		Info:        "Encodes input document with JSON",
+		Info:        "Encodes input document with JSON. Numbers decoded exactly by json_decode (numbers set to int64 or decimal) are written back digit for digit. Set indent for output a person reads, turn off HTML escaping for consumers that compare the text literally, and turn on asciiOnly for receivers that cannot take UTF-8. Include, exclude, omitNull, omitEmpty and keyCase shape the document on the way out, so internal fields and secrets never reach the payload. Set output to lines for NDJSON sinks such as Elasticsearch _bulk or BigQuery loads. For anything signed or hashed, turn on canonical (RFC 8785) and a digest, so the other party can reproduce the same bytes.",
 		Tags:        []string{"json"},
 	}
 }
@@ -75,7 +110,7 @@ func (h *Component) Handle(ctx context.Context, handler module.Handler, port str
 		return module.Fail(fmt.Errorf("invalid input"))
 	}
 
-	data, err := json.Marshal(in.Document)
+	data, count, err := h.write(in.Document)
 	if err != nil {
 		if !h.settings.EnableErrorPort {
 			return module.Fail(err)
@@ -90,10 +125,105 @@ func (h *Component) Handle(ctx context.Context, handler module.Handler, port str
 
 	return handler(ctx, ResponsePort, Response{
 		Encoded: buf.String(),
+		Digest:  h.digest(data),
+		Count:   count,
 		Context: in.Context,
 	})
 }
 
+// write produces the output the output setting asks for, and how many values
+// it holds.
+func (h *Component) write(document any) ([]byte, int, error) {
+	f := h.format()
+	if h.settings.Output != OutputLines && h.settings.Output != OutputSequence {
+		data, err := h.encode(document, f)
+		return data, 1, err
+	}
+
+	items, ok := document.([]any)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s output needs an array document, one element per record, but got %s", h.settings.Output, describe(document))
+	}
+	// A record is one line, so indentation would break the framing the
+	// output exists for.
+	f.indent = ""
+
+	var buf bytes.Buffer
+	for i, item := range items {
+		data, err := h.encode(item, f)
+		if err != nil {
+			return nil, 0, fmt.Errorf("element %d: %w", i, err)
+		}
+		if h.settings.Output == OutputSequence {
+			buf.WriteByte(recordSeparator)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), len(items), nil
+}
+
+// encode marshals one value and re-spells the result under f. A node that
+// never had the formatting settings set skips that pass, so its output stays
+// byte-for-byte what json.Marshal has always produced.
+func (h *Component) encode(document any, f format) ([]byte, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	p, err := h.projection()
+	if err != nil {
+		return nil, err
+	}
+	if f == (format{escapeHTML: true}) && h.settings.KeyOrder == "" && p == nil {
+		return data, nil
+	}
+	return reformat(data, f, p)
+}
+
+func describe(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case string:
+		return "a string"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func (h *Component) digest(data []byte) string {
+	switch h.settings.Digest {
+	case DigestSHA256:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	case DigestSHA512:
+		sum := sha512.Sum512(data)
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+func (h *Component) format() format {
+	f := format{
+		canonical:  h.settings.Canonical,
+		keepOrder:  h.settings.KeyOrder == KeysGiven,
+		escapeHTML: !h.settings.NoHTMLEscape,
+		asciiOnly:  h.settings.ASCIIOnly,
+	}
+	switch h.settings.Indent {
+	case Indent2:
+		f.indent = "  "
+	case Indent4:
+		f.indent = "    "
+	case IndentTab:
+		f.indent = "\t"
+	}
+	return f
+}
+
 func (h *Component) Ports() []module.Port {
 	ports := []module.Port{
 		{
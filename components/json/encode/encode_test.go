@@ -0,0 +1,362 @@
+package encode
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func encoded(t *testing.T, document any, settings Settings) Response {
+	t.Helper()
+	port, msg, err := run(t, Request{Document: document}, settings)
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if port != ResponsePort {
+		t.Fatalf("emitted on %q, want %q", port, ResponsePort)
+	}
+	out, ok := msg.(Response)
+	if !ok {
+		t.Fatalf("emitted %T", msg)
+	}
+	return out
+}
+
+func TestEncodesADocument(t *testing.T) {
+	out := encoded(t, map[string]any{"a": []any{float64(1), "two", true, nil}}, Settings{})
+	if out.Encoded != `{"a":[1,"two",true,null]}` {
+		t.Fatalf("encoded = %s", out.Encoded)
+	}
+}
+
+// json_decode's exact number modes hand over json.Number and int64 values;
+// writing them back must not route them through a float64.
+func TestExactNumbersAreWrittenUnchanged(t *testing.T) {
+	out := encoded(t, map[string]any{
+		"id":     int64(9007199254740993),
+		"amount": json.Number("12.10"),
+		"huge":   json.Number("99999999999999999999"),
+	}, Settings{})
+	if out.Encoded != `{"amount":12.10,"huge":99999999999999999999,"id":9007199254740993}` {
+		t.Fatalf("encoded = %s, want every number exactly as decoded", out.Encoded)
+	}
+}
+
+type ordered struct {
+	Zebra string `json:"zebra"`
+	Apple string `json:"apple"`
+}
+
+func TestDefaultsMatchPlainMarshal(t *testing.T) {
+	doc := map[string]any{"html": "<a href=\"x\">&</a>", "u": "é", "list": []any{}, "obj": map[string]any{}}
+	want, _ := json.Marshal(doc)
+	if out := encoded(t, doc, Settings{}); out.Encoded != string(want) {
+		t.Fatalf("encoded = %s, want %s", out.Encoded, want)
+	}
+}
+
+func TestIndent(t *testing.T) {
+	doc := map[string]any{"a": []any{float64(1), map[string]any{"b": nil}}, "c": map[string]any{}}
+	for indent, want := range map[string]string{
+		Indent2:   "{\n  \"a\": [\n    1,\n    {\n      \"b\": null\n    }\n  ],\n  \"c\": {}\n}",
+		Indent4:   "{\n    \"a\": [\n        1,\n        {\n            \"b\": null\n        }\n    ],\n    \"c\": {}\n}",
+		IndentTab: "{\n\t\"a\": [\n\t\t1,\n\t\t{\n\t\t\t\"b\": null\n\t\t}\n\t],\n\t\"c\": {}\n}",
+	} {
+		if out := encoded(t, doc, Settings{Indent: indent}); out.Encoded != want {
+			t.Errorf("%s: encoded =\n%s\nwant\n%s", indent, out.Encoded, want)
+		}
+	}
+}
+
+func TestKeyOrder(t *testing.T) {
+	doc := ordered{Zebra: "z", Apple: "a"}
+	if out := encoded(t, doc, Settings{}); out.Encoded != `{"zebra":"z","apple":"a"}` {
+		t.Errorf("default = %s, want plain marshal output", out.Encoded)
+	}
+	if out := encoded(t, doc, Settings{KeyOrder: KeysGiven}); out.Encoded != `{"zebra":"z","apple":"a"}` {
+		t.Errorf("given = %s, want the struct's order kept", out.Encoded)
+	}
+	if out := encoded(t, doc, Settings{KeyOrder: KeysSorted}); out.Encoded != `{"apple":"a","zebra":"z"}` {
+		t.Errorf("sorted = %s", out.Encoded)
+	}
+}
+
+func TestHTMLEscapingCanBeTurnedOff(t *testing.T) {
+	out := encoded(t, map[string]any{"q": "a<b && c>d"}, Settings{NoHTMLEscape: true})
+	if out.Encoded != `{"q":"a<b && c>d"}` {
+		t.Fatalf("encoded = %s", out.Encoded)
+	}
+}
+
+func TestASCIIOnlyEscapesEverythingElse(t *testing.T) {
+	out := encoded(t, map[string]any{"name": "Zoë 😀", "n": json.Number("1.50")}, Settings{ASCIIOnly: true})
+	if out.Encoded != `{"n":1.50,"name":"Zo\u00eb \ud83d\ude00"}` {
+		t.Fatalf("encoded = %s", out.Encoded)
+	}
+	var back map[string]any
+	if err := json.Unmarshal([]byte(out.Encoded), &back); err != nil || back["name"] != "Zoë 😀" {
+		t.Fatalf("round trip = %v, %v", back, err)
+	}
+}
+
+func canonicalOf(t *testing.T, input string) string {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(input))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	return encoded(t, doc, Settings{Canonical: true}).Encoded
+}
+
+// The example from RFC 8785 section 3.2.2.
+func TestCanonicalMatchesTheRFCExample(t *testing.T) {
+	got := canonicalOf(t, `{
+	  "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+	  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+	  "literals": [null, true, false]
+	}`)
+	want := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`
+	if got != want {
+		t.Fatalf("canonical =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// RFC 8785 section 3.2.3: UTF-16 order puts the emoji before U+FB33, where
+// byte order would not.
+func TestCanonicalSortsByUTF16CodeUnits(t *testing.T) {
+	got := canonicalOf(t, `{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`)
+	want := `{"\r":"Carriage Return","1":"One","` + "\u0080" + `":"Control","` + "\u00f6" + `":"Latin Small Letter O With Diaeresis","` + "\u20ac" + `":"Euro Sign","` + "\U0001F600" + `":"Emoji: Grinning Face","` + "\ufb33" + `":"Hebrew Letter Dalet With Dagesh"}`
+	if got != want {
+		t.Fatalf("canonical =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCanonicalNumbers(t *testing.T) {
+	for literal, want := range map[string]string{
+		"0": "0", "-0": "0", "1": "1", "-1.5": "-1.5", "100": "100", "1e21": "1e+21", "1e20": "100000000000000000000",
+		"123e-20": "1.23e-18", "0.000001": "0.000001", "0.0000001": "1e-7", "9007199254740993": "9007199254740992",
+		"5e-324": "5e-324", "1.7976931348623157e308": "1.7976931348623157e+308",
+	} {
+		if got, err := esNumber(literal); err != nil || got != want {
+			t.Errorf("%s = %s, %v, want %s", literal, got, err, want)
+		}
+	}
+	if _, err := esNumber("1e400"); err == nil {
+		t.Error("a number past the range of a double was accepted")
+	}
+}
+
+func TestDigestOfTheEncodedBytes(t *testing.T) {
+	out := encoded(t, map[string]any{"b": float64(2), "a": float64(1)}, Settings{Canonical: true, Digest: DigestSHA256})
+	// sha256 of {"a":1,"b":2}
+	if out.Encoded != `{"a":1,"b":2}` || out.Digest != "43258cff783fe7036d8a43033f830adfc60ec037382473548ac742b888292777" {
+		t.Fatalf("encoded = %s, digest = %s", out.Encoded, out.Digest)
+	}
+	if out := encoded(t, 1, Settings{Digest: DigestSHA512}); len(out.Digest) != 128 {
+		t.Fatalf("sha512 digest = %q", out.Digest)
+	}
+	if out := encoded(t, 1, Settings{}); out.Digest != "" {
+		t.Fatalf("digest = %q with the setting off", out.Digest)
+	}
+}
+
+var events = []any{
+	map[string]any{"level": "info", "msg": "a<b"},
+	map[string]any{"level": "warn", "n": float64(2)},
+}
+
+func TestLinesWritesOneValuePerLine(t *testing.T) {
+	out := encoded(t, events, Settings{Output: OutputLines, Indent: Indent2})
+	want := "{\"level\":\"info\",\"msg\":\"a\\u003cb\"}\n{\"level\":\"warn\",\"n\":2}\n"
+	if out.Encoded != want {
+		t.Fatalf("encoded = %q, want %q — indent must not break the framing", out.Encoded, want)
+	}
+	if out.Count != 2 {
+		t.Fatalf("count = %d, want 2", out.Count)
+	}
+}
+
+func TestSequenceFramesEachRecord(t *testing.T) {
+	out := encoded(t, events, Settings{Output: OutputSequence, NoHTMLEscape: true})
+	want := "\x1e{\"level\":\"info\",\"msg\":\"a<b\"}\n\x1e{\"level\":\"warn\",\"n\":2}\n"
+	if out.Encoded != want {
+		t.Fatalf("encoded = %q, want %q", out.Encoded, want)
+	}
+}
+
+func TestLinesOfAnEmptyArrayIsEmpty(t *testing.T) {
+	out := encoded(t, []any{}, Settings{Output: OutputLines})
+	if out.Encoded != "" || out.Count != 0 {
+		t.Fatalf("encoded = %q, count = %d", out.Encoded, out.Count)
+	}
+}
+
+func TestLinesRefusesANonArray(t *testing.T) {
+	port, msg, err := run(t, Request{Document: map[string]any{"a": 1}}, Settings{Output: OutputLines, EnableErrorPort: true})
+	if err != nil || port != ErrorPort {
+		t.Fatalf("port = %q, err = %v, want the error port", port, err)
+	}
+	if !strings.Contains(msg.(Error).Error, "needs an array document") {
+		t.Fatalf("error = %q", msg.(Error).Error)
+	}
+}
+
+func TestDocumentCountIsOne(t *testing.T) {
+	if out := encoded(t, events, Settings{}); out.Count != 1 {
+		t.Fatalf("count = %d, want 1 for a single document", out.Count)
+	}
+}
+
+func user() map[string]any {
+	return map[string]any{
+		"id":       float64(7),
+		"internal": map[string]any{"token": "s3cret", "shard": float64(2)},
+		"profile":  map[string]any{"display_name": "Ann", "nick": nil, "tags": []any{}, "bio": ""},
+		"items":    []any{map[string]any{"sku": "a", "cost": float64(1)}, map[string]any{"sku": "b", "cost": float64(2)}},
+	}
+}
+
+func TestExcludeDropsSecrets(t *testing.T) {
+	out := encoded(t, user(), Settings{Exclude: []string{"/internal/token", "/items/*/cost"}})
+	if strings.Contains(out.Encoded, "s3cret") || strings.Contains(out.Encoded, "cost") {
+		t.Fatalf("encoded = %s", out.Encoded)
+	}
+	if !strings.Contains(out.Encoded, `"shard":2`) {
+		t.Fatalf("encoded = %s, want the rest of /internal kept", out.Encoded)
+	}
+}
+
+// Exclude paths name places in the input, so two indexes drop the two
+// elements they name, not the second one after the first has shifted.
+func TestExcludeIndexesTheInput(t *testing.T) {
+	doc := map[string]any{"items": []any{"a", "b", "c", "d"}}
+	out := encoded(t, doc, Settings{Exclude: []string{"/items/0", "/items/1"}})
+	if want := `{"items":["c","d"]}`; out.Encoded != want {
+		t.Fatalf("encoded = %s, want %s", out.Encoded, want)
+	}
+}
+
+func TestIncludeKeepsOnlyThePaths(t *testing.T) {
+	out := encoded(t, user(), Settings{Include: []string{"/id", "/items/*/sku", "/missing"}})
+	want := `{"id":7,"items":[{"sku":"a"},{"sku":"b"}]}`
+	if out.Encoded != want {
+		t.Fatalf("encoded = %s, want %s", out.Encoded, want)
+	}
+}
+
+// A path that runs into a scalar names something inside it, which is not
+// there; the scalar itself is not what was asked for, and may be a secret.
+func TestIncludeDoesNotKeepAScalarOnThePath(t *testing.T) {
+	doc := map[string]any{"token": "s3cr3t", "id": float64(7)}
+	out := encoded(t, doc, Settings{Include: []string{"/token/id", "/id"}})
+	if want := `{"id":7}`; out.Encoded != want {
+		t.Fatalf("encoded = %s, want %s", out.Encoded, want)
+	}
+}
+
+func TestOmitNullAndOmitEmpty(t *testing.T) {
+	doc := map[string]any{"a": nil, "b": "", "c": []any{nil}, "d": map[string]any{"e": nil}, "f": false, "g": float64(0)}
+	if out := encoded(t, doc, Settings{OmitNull: true}); out.Encoded != `{"b":"","c":[null],"d":{},"f":false,"g":0}` {
+		t.Errorf("omitNull: encoded = %s", out.Encoded)
+	}
+	// d is empty once its null member goes, so it goes too; the array element
+	// stays where it is.
+	if out := encoded(t, doc, Settings{OmitEmpty: true}); out.Encoded != `{"c":[null],"f":false,"g":0}` {
+		t.Errorf("omitEmpty: encoded = %s", out.Encoded)
+	}
+}
+
+func TestKeyCase(t *testing.T) {
+	doc := map[string]any{"user_id": float64(1), "HTTPServer": map[string]any{"max-conns": float64(2)}, "createdAt2": "x"}
+	for keyCase, want := range map[string]string{
+		CaseCamel:  `{"createdAt2":"x","httpServer":{"maxConns":2},"userId":1}`,
+		CasePascal: `{"CreatedAt2":"x","HttpServer":{"MaxConns":2},"UserId":1}`,
+		CaseSnake:  `{"created_at2":"x","http_server":{"max_conns":2},"user_id":1}`,
+		CaseKebab:  `{"created-at2":"x","http-server":{"max-conns":2},"user-id":1}`,
+	} {
+		if out := encoded(t, doc, Settings{KeyCase: keyCase}); out.Encoded != want {
+			t.Errorf("%s: encoded = %s, want %s", keyCase, out.Encoded, want)
+		}
+	}
+}
+
+// Paths are written against the keys the document arrives with; renaming
+// comes last so an author never has to guess which spelling to use.
+func TestPathsUseOriginalKeys(t *testing.T) {
+	out := encoded(t, user(), Settings{Include: []string{"/profile/display_name"}, KeyCase: CaseCamel})
+	if out.Encoded != `{"profile":{"displayName":"Ann"}}` {
+		t.Fatalf("encoded = %s", out.Encoded)
+	}
+}
+
+func TestKeyCaseCollisionIsAnError(t *testing.T) {
+	port, msg, _ := run(t, Request{Document: map[string]any{"user_id": 1, "userId": 2}}, Settings{KeyCase: CaseCamel, EnableErrorPort: true})
+	if port != ErrorPort || !strings.Contains(msg.(Error).Error, "both become") {
+		t.Fatalf("port = %q, msg = %v", port, msg)
+	}
+}
+
+func TestProjectionAppliesToEachLine(t *testing.T) {
+	out := encoded(t, []any{user(), user()}, Settings{Output: OutputLines, Include: []string{"/id"}})
+	if out.Encoded != "{\"id\":7}\n{\"id\":7}\n" {
+		t.Fatalf("encoded = %q", out.Encoded)
+	}
+}
+
+func TestMalformedPathIsReported(t *testing.T) {
+	port, msg, _ := run(t, Request{Document: user()}, Settings{Exclude: []string{"internal"}, EnableErrorPort: true})
+	if port != ErrorPort || !strings.Contains(msg.(Error).Error, "exclude") {
+		t.Fatalf("port = %q, msg = %v", port, msg)
+	}
+}
+
+func TestContextIsCarried(t *testing.T) {
+	_, msg, err := run(t, Request{Context: "trace-1", Document: 1}, Settings{})
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if msg.(Response).Context != "trace-1" {
+		t.Fatalf("context = %v, want it carried", msg.(Response).Context)
+	}
+}
+
+func TestUnencodableValueRoutesToErrorPort(t *testing.T) {
+	port, msg, err := run(t, Request{Document: make(chan int)}, Settings{EnableErrorPort: true})
+	if err != nil {
+		t.Fatalf("with the error port on, the run must not fail: %v", err)
+	}
+	if port != ErrorPort || msg.(Error).Error == "" {
+		t.Fatalf("emitted %v on %q, want an error on %q", msg, port, ErrorPort)
+	}
+}
+
+func TestUnknownPortIsRefused(t *testing.T) {
+	c := &Component{}
+	if res := c.Handle(context.Background(), nil, "nope", Request{}); res.Err() == nil {
+		t.Fatal("an unknown port was accepted")
+	}
+}
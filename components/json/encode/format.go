@@ -0,0 +1,230 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	IndentNone = "none"
+	Indent2    = "2"
+	Indent4    = "4"
+	IndentTab  = "tab"
+
+	KeysSorted = "sorted"
+	KeysGiven  = "given"
+)
+
+// object keeps members in the order they were read. A Go map has none, so
+// anything that came in as one is already sorted by the marshaller; what keeps
+// an order of its own is a struct or raw JSON, and that order is the one
+// "given" preserves.
+type object []member
+
+type member struct {
+	key   string
+	value any
+}
+
+// format is how a document is written out: indentation, key order, and which
+// characters are escaped beyond what JSON itself requires.
+type format struct {
+	indent     string
+	keepOrder  bool
+	escapeHTML bool
+	asciiOnly  bool
+
+	// canonical overrides everything above with RFC 8785.
+	canonical bool
+}
+
+// reformat rewrites marshalled JSON under f, after p has dropped and renamed
+// what it was set to; p may be nil. Going through the marshaller first means
+// every Go value the flow can hand over is handled the way it always was; this
+// only changes what is written and how it is spelled.
+func reformat(data []byte, f format, p *projection) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := read(dec)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		if v, err = p.apply(v); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if f.canonical {
+		if err := canonical(&buf, v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	f.write(&buf, v, 0)
+	return buf.Bytes(), nil
+}
+
+// read builds an ordered tree from the token stream. Numbers stay json.Number
+// so their digits are written back exactly as the marshaller produced them.
+func read(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		obj := object{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyTok.(string)
+			if !ok {
+				return nil, fmt.Errorf("object key is %T", keyTok)
+			}
+			value, err := read(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{key: key, value: value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case '[':
+		arr := []any{}
+		for dec.More() {
+			value, err := read(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
+
+func (f format) write(w *bytes.Buffer, v any, depth int) {
+	switch t := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		if t {
+			w.WriteString("true")
+		} else {
+			w.WriteString("false")
+		}
+	case json.Number:
+		w.WriteString(t.String())
+	case string:
+		f.writeString(w, t)
+	case []any:
+		if len(t) == 0 {
+			w.WriteString("[]")
+			return
+		}
+		w.WriteByte('[')
+		for i, item := range t {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			f.newline(w, depth+1)
+			f.write(w, item, depth+1)
+		}
+		f.newline(w, depth)
+		w.WriteByte(']')
+	case object:
+		if len(t) == 0 {
+			w.WriteString("{}")
+			return
+		}
+		if !f.keepOrder {
+			sort.SliceStable(t, func(i, j int) bool { return t[i].key < t[j].key })
+		}
+		w.WriteByte('{')
+		for i, m := range t {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			f.newline(w, depth+1)
+			f.writeString(w, m.key)
+			w.WriteByte(':')
+			if f.indent != "" {
+				w.WriteByte(' ')
+			}
+			f.write(w, m.value, depth+1)
+		}
+		f.newline(w, depth)
+		w.WriteByte('}')
+	}
+}
+
+func (f format) newline(w *bytes.Buffer, depth int) {
+	if f.indent == "" {
+		return
+	}
+	w.WriteByte('\n')
+	w.WriteString(strings.Repeat(f.indent, depth))
+}
+
+const hexDigits = "0123456789abcdef"
+
+func (f format) writeString(w *bytes.Buffer, s string) {
+	w.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == '"':
+			w.WriteString(`\"`)
+		case r == '\\':
+			w.WriteString(`\\`)
+		case r == '\n':
+			w.WriteString(`\n`)
+		case r == '\r':
+			w.WriteString(`\r`)
+		case r == '\t':
+			w.WriteString(`\t`)
+		case r < 0x20:
+			writeEscape(w, r)
+		case f.escapeHTML && (r == '<' || r == '>' || r == '&'):
+			writeEscape(w, r)
+		case r == '\u2028' || r == '\u2029':
+			// Valid JSON, but a line terminator to JavaScript before ES2019;
+			// json.Marshal escapes these too, so doing the same keeps the
+			// output embeddable wherever it was before.
+			writeEscape(w, r)
+		case f.asciiOnly && r >= utf8.RuneSelf:
+			if r > 0xFFFF {
+				hi, lo := utf16.EncodeRune(r)
+				writeEscape(w, hi)
+				writeEscape(w, lo)
+			} else {
+				writeEscape(w, r)
+			}
+		default:
+			w.WriteRune(r)
+		}
+	}
+	w.WriteByte('"')
+}
+
+func writeEscape(w io.ByteWriter, r rune) {
+	for _, b := range []byte{'\\', 'u', hexDigits[r>>12&0xF], hexDigits[r>>8&0xF], hexDigits[r>>4&0xF], hexDigits[r&0xF]} {
+		_ = w.WriteByte(b)
+	}
+}
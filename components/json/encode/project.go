@@ -0,0 +1,310 @@
+package encode
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
+)
+
+const (
+	CaseNone   = "none"
+	CaseCamel  = "camel"
+	CasePascal = "pascal"
+	CaseSnake  = "snake"
+	CaseKebab  = "kebab"
+
+	// wildcard in an include or exclude path matches every key of an object or
+	// element of an array, as it does in json_decode's unwrap paths.
+	wildcard = "*"
+)
+
+// projection is what is dropped or renamed before a value is written. It works
+// on the tree read back from the marshaller, so a struct is projected the same
+// way as a map.
+type projection struct {
+	include   [][]string
+	exclude   [][]string
+	omitNull  bool
+	omitEmpty bool
+	keyCase   string
+}
+
+// projection returns nil when none of its settings are set, so the common case
+// does not read the document back at all.
+func (h *Component) projection() (*projection, error) {
+	s := h.settings
+	keyCase := s.KeyCase
+	if keyCase == CaseNone {
+		keyCase = ""
+	}
+	if len(s.Include) == 0 && len(s.Exclude) == 0 && !s.OmitNull && !s.OmitEmpty && keyCase == "" {
+		return nil, nil
+	}
+
+	p := &projection{omitNull: s.OmitNull, omitEmpty: s.OmitEmpty, keyCase: keyCase}
+	var err error
+	if p.include, err = parseAll(s.Include); err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+	if p.exclude, err = parseAll(s.Exclude); err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	return p, nil
+}
+
+func parseAll(paths []string) ([][]string, error) {
+	all := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		tokens, err := pointer.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, tokens)
+	}
+	return all, nil
+}
+
+// apply runs the steps in the order a reader would expect: paths name keys as
+// they arrive, so include and exclude come before renaming, and a value
+// emptied by exclusion is then stripped like any other.
+func (p *projection) apply(v any) (any, error) {
+	if len(p.include) > 0 {
+		v, _ = include(v, p.include)
+	}
+	if len(p.exclude) > 0 {
+		v = exclude(v, p.exclude)
+	}
+	if p.omitNull || p.omitEmpty {
+		v = p.strip(v)
+	}
+	if p.keyCase != "" {
+		return rename(v, p.keyCase)
+	}
+	return v, nil
+}
+
+// include keeps only what the paths reach, and the objects and arrays on the
+// way to it. A path the document does not have keeps nothing: not even a
+// scalar it runs into, which is what the path was meant to look inside of.
+// ok is false when v itself is not kept.
+func include(v any, paths [][]string) (any, bool) {
+	for _, tokens := range paths {
+		if len(tokens) == 0 {
+			return v, true
+		}
+	}
+	switch t := v.(type) {
+	case object:
+		kept := object{}
+		for _, m := range t {
+			if rest := follow(paths, m.key); len(rest) > 0 {
+				if value, ok := include(m.value, rest); ok {
+					kept = append(kept, member{key: m.key, value: value})
+				}
+			}
+		}
+		return kept, true
+	case []any:
+		kept := []any{}
+		for i, item := range t {
+			if rest := follow(paths, fmt.Sprint(i)); len(rest) > 0 {
+				if value, ok := include(item, rest); ok {
+					kept = append(kept, value)
+				}
+			}
+		}
+		return kept, true
+	}
+	return nil, false
+}
+
+// follow returns what is left of each path whose first token matches key.
+func follow(paths [][]string, key string) [][]string {
+	var rest [][]string
+	for _, tokens := range paths {
+		if tokens[0] == key || tokens[0] == wildcard {
+			rest = append(rest, tokens[1:])
+		}
+	}
+	return rest
+}
+
+// exclude removes what the paths reach. Every path names a place in the
+// document as it arrived: the list says what to leave out, it is not a series
+// of edits, so excluding /items/0 and /items/1 drops the first two elements
+// rather than the first and the third. A path that is empty or the document
+// does not have removes nothing.
+func exclude(v any, paths [][]string) any {
+	var nonEmpty [][]string
+	for _, tokens := range paths {
+		if len(tokens) > 0 {
+			nonEmpty = append(nonEmpty, tokens)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return v
+	}
+	switch t := v.(type) {
+	case object:
+		kept := t[:0]
+		for _, m := range t {
+			rest, drop := excluded(nonEmpty, m.key)
+			if drop {
+				continue
+			}
+			m.value = exclude(m.value, rest)
+			kept = append(kept, m)
+		}
+		return kept
+	case []any:
+		kept := t[:0]
+		for i, item := range t {
+			rest, drop := excluded(nonEmpty, fmt.Sprint(i))
+			if drop {
+				continue
+			}
+			kept = append(kept, exclude(item, rest))
+		}
+		return kept
+	}
+	return v
+}
+
+// excluded returns what is left of the paths through key, and whether one of
+// them ends there, which excludes key altogether.
+func excluded(paths [][]string, key string) ([][]string, bool) {
+	rest := follow(paths, key)
+	for _, tokens := range rest {
+		if len(tokens) == 0 {
+			return nil, true
+		}
+	}
+	return rest, false
+}
+
+// strip drops object members that are null, or empty under omitEmpty. It works
+// bottom-up, so an object left with nothing in it goes too. Array elements are
+// kept: their position means something, and dropping one would shift the rest.
+func (p *projection) strip(v any) any {
+	switch t := v.(type) {
+	case object:
+		kept := t[:0]
+		for _, m := range t {
+			m.value = p.strip(m.value)
+			if p.drop(m.value) {
+				continue
+			}
+			kept = append(kept, m)
+		}
+		return kept
+	case []any:
+		for i, item := range t {
+			t[i] = p.strip(item)
+		}
+	}
+	return v
+}
+
+func (p *projection) drop(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return p.omitEmpty && t == ""
+	case []any:
+		return p.omitEmpty && len(t) == 0
+	case object:
+		return p.omitEmpty && len(t) == 0
+	}
+	return false
+}
+
+// rename converts every object key to keyCase. Two keys that end up the same
+// are an error rather than one silently overwriting the other.
+func rename(v any, keyCase string) (any, error) {
+	switch t := v.(type) {
+	case object:
+		seen := make(map[string]string, len(t))
+		for i, m := range t {
+			key := convertCase(m.key, keyCase)
+			if prev, ok := seen[key]; ok {
+				return nil, fmt.Errorf("keys %q and %q both become %q in %s case", prev, m.key, key, keyCase)
+			}
+			seen[key] = m.key
+			value, err := rename(m.value, keyCase)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = member{key: key, value: value}
+		}
+	case []any:
+		for i, item := range t {
+			value, err := rename(item, keyCase)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = value
+		}
+	}
+	return v, nil
+}
+
+func convertCase(key, keyCase string) string {
+	parts := words(key)
+	if len(parts) == 0 {
+		return key
+	}
+	switch keyCase {
+	case CaseSnake:
+		return strings.ToLower(strings.Join(parts, "_"))
+	case CaseKebab:
+		return strings.ToLower(strings.Join(parts, "-"))
+	}
+	var sb strings.Builder
+	for i, part := range parts {
+		part = strings.ToLower(part)
+		if i == 0 && keyCase == CaseCamel {
+			sb.WriteString(part)
+			continue
+		}
+		r := []rune(part)
+		r[0] = unicode.ToUpper(r[0])
+		sb.WriteString(string(r))
+	}
+	return sb.String()
+}
+
+// words splits a key at separators and at case changes, keeping an acronym
+// together: "HTTPServer_id" is HTTP, Server, id. Digits stay with the word
+// before them.
+func words(key string) []string {
+	var parts []string
+	r := []rune(key)
+	start := -1
+	for i, c := range r {
+		if c == '_' || c == '-' || c == ' ' || c == '.' {
+			if start >= 0 {
+				parts = append(parts, string(r[start:i]))
+			}
+			start = -1
+			continue
+		}
+		if start < 0 {
+			start = i
+			continue
+		}
+		prev := r[i-1]
+		lowerToUpper := unicode.IsUpper(c) && (unicode.IsLower(prev) || unicode.IsDigit(prev))
+		acronymEnd := unicode.IsUpper(c) && unicode.IsUpper(prev) && i+1 < len(r) && unicode.IsLower(r[i+1])
+		if lowerToUpper || acronymEnd {
+			parts = append(parts, string(r[start:i]))
+			start = i
+		}
+	}
+	if start >= 0 {
+		parts = append(parts, string(r[start:]))
+	}
+	return parts
+}
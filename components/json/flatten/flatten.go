@@ -0,0 +1,241 @@
+// Package flatten turns nested documents into flat maps of path keys, and back.
+//
+// Key-value stores, metrics labels, form posts and csv_encode all want one
+// level of keys, and csv_encode can only fmt.Sprint a nested value into a cell.
+// Flattening names every leaf by its path — items.0.name — and unflattening
+// rebuilds the exact document, so a flat sink can be written to and read back.
+package flatten
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "json_flatten"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+
+	ModeFlatten   = "flatten"
+	ModeUnflatten = "unflatten"
+
+	IndexDot      = "dot"
+	IndexBrackets = "brackets"
+
+	defaultSeparator = "."
+)
+
+type Context any
+
+type Document any
+
+// Request is what flatten mode takes.
+type Request struct {
+	Context  Context  `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the result"`
+	Document Document `json:"document" required:"true" configurable:"true" title:"Document" description:"The object or array to flatten. With rows on, an array of them, each flattened on its own."`
+}
+
+// Response is what flatten mode emits.
+type Response struct {
+	Context Context          `json:"context,omitempty" title:"Context"`
+	Flat    map[string]any   `json:"flat,omitempty" title:"Flat" description:"Every leaf of the document under its path key. Empty objects and arrays are leaves too, so nothing is lost. Set when rows is off."`
+	Rows    []map[string]any `json:"rows,omitempty" title:"Rows" description:"Each element flattened, ready for csv_encode. Set when rows is on."`
+}
+
+// UnflattenRequest is what unflatten mode takes.
+type UnflattenRequest struct {
+	Context Context          `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the result"`
+	Flat    map[string]any   `json:"flat,omitempty" configurable:"true" title:"Flat" description:"Path keys and their values, as flatten mode emits them. Used when rows is off."`
+	Rows    []map[string]any `json:"rows,omitempty" configurable:"true" title:"Rows" description:"Several flat maps, each rebuilt on its own. Used when rows is on."`
+}
+
+// UnflattenResponse is what unflatten mode emits.
+type UnflattenResponse struct {
+	Context  Context  `json:"context,omitempty" title:"Context"`
+	Document Document `json:"document" configurable:"true" title:"Document" description:"The nested document; with rows on, an array of them."`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+type Settings struct {
+	Mode       string `json:"mode" default:"flatten" enum:"flatten,unflatten" enumTitles:"Flatten|Unflatten" title:"Mode" description:"Flatten turns a nested document into path keys. Unflatten turns path keys back into the document."`
+	Separator  string `json:"separator" default:"." title:"Separator" description:"Joins the keys of a path, as in user.address.city. Use __ or / when keys contain dots. A key that contains the separator is escaped with a backslash, so it comes back intact."`
+	IndexStyle string `json:"indexStyle" default:"dot" enum:"dot,brackets" enumTitles:"items.0.name|items[0].name" title:"Array Index Style" description:"How an array element's index is written. Dot also escapes an object key that is all digits (\\0), so it is not read back as an index."`
+	MaxDepth   int    `json:"maxDepth" title:"Max Depth" description:"Levels flattened; anything deeper stays nested under the last key. 0 flattens all the way down."`
+
+	Rows bool `json:"rows" title:"Rows" description:"Off (default): one document at a time. On: an array of documents, each flattened or rebuilt on its own — the shape csv_encode and csv_decode work in."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "JSON Flatten",
+		Info: "Flattens a nested document into one level of path keys — {\"user\":{\"tags\":[\"a\"]}} becomes " +
+			"{\"user.tags.0\":\"a\"} — and unflattens path keys back into the document. The round trip is exact: " +
+			"empty objects and arrays are kept as values, and keys that contain the separator are escaped. " +
+			"Put it between json_decode and csv_encode with rows on to get one column per nested field, or in front " +
+			"of a key-value store, metrics labels or a form post.",
+		Tags: []string{"json", "csv"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+
+	switch in := msg.(type) {
+	case Request:
+		k, err := c.keys()
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		doc, err := value.Normalize(in.Document)
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, fmt.Errorf("document: %w", err))
+		}
+		if !c.settings.Rows {
+			flat, err := k.flatten(doc)
+			if err != nil {
+				return c.handleError(ctx, handler, in.Context, err)
+			}
+			return handler(ctx, ResponsePort, Response{Context: in.Context, Flat: flat})
+		}
+		elements, ok := doc.([]any)
+		if !ok {
+			return c.handleError(ctx, handler, in.Context, fmt.Errorf("with rows on the document must be an array, got %s", value.Show(doc)))
+		}
+		rows := make([]map[string]any, len(elements))
+		for i, element := range elements {
+			if rows[i], err = k.flatten(element); err != nil {
+				return c.handleError(ctx, handler, in.Context, fmt.Errorf("row %d: %w", i, err))
+			}
+		}
+		return handler(ctx, ResponsePort, Response{Context: in.Context, Rows: rows})
+
+	case UnflattenRequest:
+		k, err := c.keys()
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		if !c.settings.Rows {
+			doc, err := k.unflatten(in.Flat)
+			if err != nil {
+				return c.handleError(ctx, handler, in.Context, err)
+			}
+			return handler(ctx, ResponsePort, UnflattenResponse{Context: in.Context, Document: doc})
+		}
+		docs := make([]any, len(in.Rows))
+		for i, row := range in.Rows {
+			if docs[i], err = k.unflatten(row); err != nil {
+				return c.handleError(ctx, handler, in.Context, fmt.Errorf("row %d: %w", i, err))
+			}
+		}
+		return handler(ctx, ResponsePort, UnflattenResponse{Context: in.Context, Document: docs})
+	}
+	return module.Fail(fmt.Errorf("invalid message"))
+}
+
+// keys checks the key settings. A separator that could be read as part of an
+// index would make the round trip ambiguous.
+func (c *Component) keys() (*keys, error) {
+	k := &keys{
+		separator: c.settings.Separator,
+		brackets:  c.settings.IndexStyle == IndexBrackets,
+		maxDepth:  c.settings.MaxDepth,
+	}
+	if k.separator == "" {
+		k.separator = defaultSeparator
+	}
+	if strings.ContainsAny(k.separator, `\[]0123456789`) {
+		return nil, fmt.Errorf("separator %q must not contain a digit, a bracket or a backslash", k.separator)
+	}
+	if k.maxDepth < 0 {
+		return nil, fmt.Errorf("max depth must not be negative")
+	}
+	return k, nil
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+func (c *Component) Ports() []module.Port {
+	var request, response any = Request{}, Response{}
+	if c.settings.Mode == ModeUnflatten {
+		request, response = UnflattenRequest{}, UnflattenResponse{}
+	}
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: request,
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: response,
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
@@ -0,0 +1,194 @@
+package flatten
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in any, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func parse(t *testing.T, s string) any {
+	t.Helper()
+	v, err := value.Normalize(json.RawMessage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func flatten(t *testing.T, doc string, settings Settings) map[string]any {
+	t.Helper()
+	_, msg, err := run(t, Request{Document: parse(t, doc)}, settings)
+	if err != nil {
+		t.Fatalf("flatten: %v", err)
+	}
+	return msg.(Response).Flat
+}
+
+func unflatten(t *testing.T, flat map[string]any, settings Settings) any {
+	t.Helper()
+	settings.Mode = ModeUnflatten
+	_, msg, err := run(t, UnflattenRequest{Flat: flat}, settings)
+	if err != nil {
+		t.Fatalf("unflatten: %v", err)
+	}
+	return msg.(UnflattenResponse).Document
+}
+
+func expectFlat(t *testing.T, got map[string]any, want string) {
+	t.Helper()
+	if !value.Equal(any(got), parse(t, want)) {
+		data, _ := json.Marshal(got)
+		t.Fatalf("flat = %s, want %s", data, want)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	got := flatten(t, `{"user":{"name":"Ann","tags":["a","b"]},"active":true,"score":null}`, Settings{})
+	expectFlat(t, got, `{"user.name":"Ann","user.tags.0":"a","user.tags.1":"b","active":true,"score":null}`)
+}
+
+func TestIndexStyleAndSeparator(t *testing.T) {
+	doc := `{"items":[{"name":"x","dims":[1,2]}]}`
+	expectFlat(t, flatten(t, doc, Settings{IndexStyle: IndexBrackets}), `{"items[0].name":"x","items[0].dims[0]":1,"items[0].dims[1]":2}`)
+	expectFlat(t, flatten(t, doc, Settings{Separator: "__"}), `{"items__0__name":"x","items__0__dims__0":1,"items__0__dims__1":2}`)
+	expectFlat(t, flatten(t, `[[1],{"a":2}]`, Settings{IndexStyle: IndexBrackets}), `{"[0][0]":1,"[1].a":2}`)
+}
+
+func TestMaxDepth(t *testing.T) {
+	got := flatten(t, `{"a":{"b":{"c":1}},"d":[[1]]}`, Settings{MaxDepth: 2})
+	expectFlat(t, got, `{"a.b":{"c":1},"d.0":[1]}`)
+}
+
+// The point of the component: whatever went in comes back out, including the
+// documents that look ambiguous once flat.
+func TestRoundTrip(t *testing.T) {
+	docs := []string{
+		`{"user":{"name":"Ann","tags":["a","b"]},"active":true,"score":null}`,
+		`{"empty":{},"none":[],"nested":{"also":[{}]}}`,
+		`{"a.b":{"c.d":1},"back\\slash":2,"trailing.":3}`,
+		`{"0":"zero","1":{"2":"two"},"list":["x"]}`,
+		`{"[x]":1,"a[0]":2,"m":[[1,2],[3]]}`,
+		`[{"a":1},[2,[3]],"s"]`,
+		`{"":{"":1}}`,
+		`{"a_":{"b":1},"a":{"_b":2},"_":{"__":{"___":3}}}`,
+	}
+	for _, style := range []Settings{{}, {IndexStyle: IndexBrackets}, {Separator: "/"}, {Separator: "__"}, {MaxDepth: 1}} {
+		for _, doc := range docs {
+			want := parse(t, doc)
+			got := unflatten(t, flatten(t, doc, style), style)
+			if !value.Equal(got, want) {
+				data, _ := json.Marshal(got)
+				t.Errorf("%+v: %s came back as %s", style, doc, data)
+			}
+		}
+	}
+}
+
+func TestKeysContainingTheSeparatorAreEscaped(t *testing.T) {
+	expectFlat(t, flatten(t, `{"a.b":{"0":1}}`, Settings{}), `{"a\\.b.\\0":1}`)
+}
+
+// With a separator longer than one character, a key can end with the start
+// of it, and the join reads differently unless that end is escaped.
+func TestKeysEndingInPartOfTheSeparatorAreEscaped(t *testing.T) {
+	settings := Settings{Separator: "__"}
+	expectFlat(t, flatten(t, `{"a_":{"b":1},"a":{"_b":2}}`, settings), `{"a\\___b":1,"a___b":2}`)
+	expectFlat(t, flatten(t, `{"a__b":1}`, settings), `{"a\\_\\_b":1}`)
+}
+
+func TestUnflattenHandWrittenKeys(t *testing.T) {
+	got := unflatten(t, map[string]any{"user.name": "Ann", "user.tags.1": "b", "user.tags.0": "a"}, Settings{})
+	if !value.Equal(got, parse(t, `{"user":{"name":"Ann","tags":["a","b"]}}`)) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestUnflattenConflicts(t *testing.T) {
+	cases := []map[string]any{
+		{"a": 1, "a.b": 2},
+		{"a.0": 1, "a.b": 2},
+		{"a.5": 1},
+		{"a[0": 1},
+	}
+	for _, flat := range cases {
+		settings := Settings{Mode: ModeUnflatten, EnableErrorPort: true}
+		if strings.Contains(keysOf(flat), "[") {
+			settings.IndexStyle = IndexBrackets
+		}
+		port, msg, err := run(t, UnflattenRequest{Flat: flat}, settings)
+		if err != nil || port != ErrorPort {
+			t.Errorf("%v: port = %q, msg = %v, err = %v", flat, port, msg, err)
+		}
+	}
+}
+
+func keysOf(flat map[string]any) string {
+	var s []string
+	for k := range flat {
+		s = append(s, k)
+	}
+	return strings.Join(s, " ")
+}
+
+// Rows is the csv_encode bridge: each element gets its own flat map.
+func TestRows(t *testing.T) {
+	_, msg, err := run(t, Request{Document: parse(t, `[{"id":1,"addr":{"city":"Oslo"}},{"id":2,"addr":{"city":"Rome"}}]`)}, Settings{Rows: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := msg.(Response).Rows
+	if len(rows) != 2 || rows[1]["addr.city"] != "Rome" {
+		t.Fatalf("rows = %v", rows)
+	}
+
+	_, msg, err = run(t, UnflattenRequest{Rows: rows}, Settings{Mode: ModeUnflatten, Rows: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.(UnflattenResponse).Document; !value.Equal(got, parse(t, `[{"id":1,"addr":{"city":"Oslo"}},{"id":2,"addr":{"city":"Rome"}}]`)) {
+		t.Fatalf("document = %v", got)
+	}
+}
+
+func TestScalarCannotBeFlattened(t *testing.T) {
+	if _, _, err := run(t, Request{Document: "x"}, Settings{}); err == nil {
+		t.Fatal("flattened a string")
+	}
+}
+
+// {} and [] would both flatten to no keys, and no keys unflatten to {}.
+func TestEmptyArrayCannotBeFlattened(t *testing.T) {
+	if _, _, err := run(t, Request{Document: []any{}}, Settings{}); err == nil {
+		t.Fatal("flattened an empty array")
+	}
+	expectFlat(t, flatten(t, `{"none":[]}`, Settings{}), `{"none":[]}`)
+}
+
+func TestSeparatorMustNotLookLikeAnIndex(t *testing.T) {
+	if _, _, err := run(t, Request{Document: map[string]any{}}, Settings{Separator: "["}); err == nil {
+		t.Fatal("accepted [ as a separator")
+	}
+}
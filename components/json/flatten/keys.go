@@ -0,0 +1,323 @@
+package flatten
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tiny-systems/encoding-module/components/json/value"
+)
+
+// keys writes and reads path keys under one set of settings.
+type keys struct {
+	separator string
+	brackets  bool
+	maxDepth  int
+}
+
+// token is one step of a path: an object key or an array index.
+type token struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func (k *keys) flatten(doc any) (map[string]any, error) {
+	switch d := doc.(type) {
+	case map[string]any:
+	case []any:
+		// An empty object flattens to no keys and comes back as one; an empty
+		// array would too, and come back as the wrong thing.
+		if len(d) == 0 {
+			return nil, fmt.Errorf("an empty array cannot be flattened: it has no keys, and no keys unflatten to {}")
+		}
+	default:
+		return nil, fmt.Errorf("only an object or an array can be flattened, got %s", value.Show(doc))
+	}
+	out := map[string]any{}
+	k.descend(out, "", true, doc, 0)
+	return out, nil
+}
+
+// descend writes the children of a container under prefix. top is set for the
+// document itself, whose children have no prefix to join to.
+func (k *keys) descend(out map[string]any, prefix string, top bool, v any, depth int) {
+	switch val := v.(type) {
+	case map[string]any:
+		for key, child := range val {
+			path := k.escape(key)
+			if !top {
+				path = prefix + k.separator + path
+			}
+			k.visit(out, path, child, depth+1)
+		}
+	case []any:
+		for i, child := range val {
+			path := strconv.Itoa(i)
+			switch {
+			case k.brackets:
+				path = prefix + "[" + path + "]"
+			case !top:
+				path = prefix + k.separator + path
+			}
+			k.visit(out, path, child, depth+1)
+		}
+	}
+}
+
+// visit writes v under path, or its children when it has any and maxDepth
+// allows. An empty object or array is written as it is: it has no leaves to
+// stand for it, and leaving it out would lose it on the way back.
+func (k *keys) visit(out map[string]any, path string, v any, depth int) {
+	deeper := k.maxDepth == 0 || depth < k.maxDepth
+	switch val := v.(type) {
+	case map[string]any:
+		if len(val) > 0 && deeper {
+			k.descend(out, path, false, v, depth)
+			return
+		}
+	case []any:
+		if len(val) > 0 && deeper {
+			k.descend(out, path, false, v, depth)
+			return
+		}
+	}
+	out[path] = v
+}
+
+// escape puts a backslash before each character of whatever in key would
+// otherwise be read as structure: the separator, the backslash itself, an
+// opening bracket in bracket style, and — in dot style — a key made only of
+// digits, which would come back as an array index. The end of a key that is
+// the start of the separator is escaped too: with __, a_ joined to b would
+// read as a and _b.
+func (k *keys) escape(key string) string {
+	if !k.brackets && digits(key) {
+		return `\` + key
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); {
+		switch {
+		case key[i] == '\\':
+			b.WriteString(`\\`)
+			i++
+		case strings.HasPrefix(key[i:], k.separator):
+			escapeRunes(&b, k.separator)
+			i += len(k.separator)
+		case strings.HasPrefix(k.separator, key[i:]):
+			escapeRunes(&b, key[i:])
+			i = len(key)
+		case k.brackets && key[i] == '[':
+			b.WriteString(`\[`)
+			i++
+		default:
+			b.WriteByte(key[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+func escapeRunes(b *strings.Builder, s string) {
+	for _, r := range s {
+		b.WriteByte('\\')
+		b.WriteRune(r)
+	}
+}
+
+// tokenize reads a path key back into its steps. A backslash makes the
+// character after it part of the key, whatever it is.
+func (k *keys) tokenize(path string) ([]token, error) {
+	var tokens []token
+	var cur strings.Builder
+	literal := false
+	// open is false right after a bracketed index, where only the separator
+	// or another index may follow.
+	open := true
+	flush := func() error {
+		s := cur.String()
+		cur.Reset()
+		defer func() { literal = false }()
+		if !k.brackets && !literal && digits(s) {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("key %q: index %s is out of range", path, s)
+			}
+			tokens = append(tokens, token{index: i, isIndex: true})
+			return nil
+		}
+		tokens = append(tokens, token{key: s})
+		return nil
+	}
+
+	for i := 0; i < len(path); {
+		switch {
+		case path[i] == '\\':
+			if i+1 == len(path) {
+				return nil, fmt.Errorf("key %q ends in a lone backslash", path)
+			}
+			_, size := utf8.DecodeRuneInString(path[i+1:])
+			cur.WriteString(path[i+1 : i+1+size])
+			i += 1 + size
+			literal = true
+		case strings.HasPrefix(path[i:], k.separator):
+			if open {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			open = true
+			i += len(k.separator)
+		case k.brackets && path[i] == '[':
+			if open && i > 0 {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 || !digits(path[i+1:i+end]) {
+				return nil, fmt.Errorf("key %q: expected an array index like [0] at position %d", path, i)
+			}
+			n, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("key %q: index %s is out of range", path, path[i+1:i+end])
+			}
+			tokens = append(tokens, token{index: n, isIndex: true})
+			i += end + 1
+			open = false
+		default:
+			if !open {
+				return nil, fmt.Errorf("key %q: expected %q or [ after ] at position %d", path, k.separator, i)
+			}
+			cur.WriteByte(path[i])
+			i++
+		}
+	}
+	if open {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+// tree is the document being rebuilt. A node is a leaf, an object or an array,
+// and the first key to reach it decides which.
+type tree struct {
+	leaf  bool
+	value any
+	keys  map[string]*tree
+	items map[int]*tree
+}
+
+// unflatten rebuilds the document from path keys. Keys are read in sorted
+// order, so when two of them conflict the error is the same every time.
+func (k *keys) unflatten(flat map[string]any) (any, error) {
+	if len(flat) == 0 {
+		return map[string]any{}, nil
+	}
+	paths := make([]string, 0, len(flat))
+	for path := range flat {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	root := &tree{}
+	for _, path := range paths {
+		tokens, err := k.tokenize(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := root.insert(path, tokens, flat[path], len(flat)); err != nil {
+			return nil, err
+		}
+	}
+	return root.build(), nil
+}
+
+// insert places v at the end of tokens. Every array element flattens to at
+// least one key, so an index at or past the number of keys cannot have come
+// from flatten, and would otherwise allocate an array of that length.
+func (t *tree) insert(path string, tokens []token, v any, limit int) error {
+	n := t
+	for _, tok := range tokens {
+		if n.leaf {
+			return fmt.Errorf("key %q goes inside a value another key sets", path)
+		}
+		if tok.isIndex {
+			if n.keys != nil {
+				return fmt.Errorf("key %q uses an array index where other keys use object keys", path)
+			}
+			if tok.index >= limit {
+				return fmt.Errorf("key %q: index %d is past the end of any array %d keys can describe", path, tok.index, limit)
+			}
+			if n.items == nil {
+				n.items = map[int]*tree{}
+			}
+			child, ok := n.items[tok.index]
+			if !ok {
+				child = &tree{}
+				n.items[tok.index] = child
+			}
+			n = child
+			continue
+		}
+		if n.items != nil {
+			return fmt.Errorf("key %q uses an object key where other keys use array indexes", path)
+		}
+		if n.keys == nil {
+			n.keys = map[string]*tree{}
+		}
+		child, ok := n.keys[tok.key]
+		if !ok {
+			child = &tree{}
+			n.keys[tok.key] = child
+		}
+		n = child
+	}
+	if n.leaf || n.keys != nil || n.items != nil {
+		return fmt.Errorf("key %q sets a value where other keys put an object or array", path)
+	}
+	n.leaf, n.value = true, v
+	return nil
+}
+
+// build turns the tree into the generic document. An index missing from an
+// array — possible only in hand-written keys — is left null.
+func (t *tree) build() any {
+	switch {
+	case t.leaf:
+		return t.value
+	case t.items != nil:
+		size := 0
+		for i := range t.items {
+			if i+1 > size {
+				size = i + 1
+			}
+		}
+		arr := make([]any, size)
+		for i, child := range t.items {
+			arr[i] = child.build()
+		}
+		return arr
+	}
+	obj := make(map[string]any, len(t.keys))
+	for key, child := range t.keys {
+		obj[key] = child.build()
+	}
+	return obj
+}
+
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,309 @@
+// Package infer works out the shape of decoded data from samples of it.
+//
+// A node that decodes text — json_decode, csv_decode — can only tell the flow
+// editor what it emits if someone pastes an example into its settings, and in
+// practice nobody does. A Learner watches real values go by instead and
+// proposes both a JSON Schema covering everything it saw and a representative
+// example in the form those settings take.
+//
+// It works on the generic tree every decoder here produces — map[string]any,
+// []any and scalars — so any of them can feed it.
+package infer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	TypeNull    = "null"
+	TypeBoolean = "boolean"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeString  = "string"
+	TypeObject  = "object"
+	TypeArray   = "array"
+
+	// SchemaDialect is the $schema written at the root of a proposed schema.
+	SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+)
+
+// typeOrder is the order types are listed in a schema with several, so the
+// same samples always produce the same schema.
+var typeOrder = []string{TypeObject, TypeArray, TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeNull}
+
+// Learner accumulates samples. The zero value is ready to use; it is not safe
+// for concurrent use.
+type Learner struct {
+	root    node
+	samples int
+}
+
+// node is everything seen at one position in the samples.
+type node struct {
+	types map[string]int
+
+	// fields in the order they were first seen, so the proposed example reads
+	// like the payloads it came from.
+	fields map[string]*node
+	order  []string
+
+	items *node
+
+	// first value seen of each scalar type, for the example.
+	examples map[string]any
+
+	// formats counts strings matching each recognised format; a format is
+	// proposed only when every string here matched it.
+	formats map[string]int
+}
+
+// Observe adds one sample.
+func (l *Learner) Observe(v any) {
+	l.samples++
+	l.root.observe(v)
+}
+
+// Samples is how many samples have been observed.
+func (l *Learner) Samples() int {
+	return l.samples
+}
+
+func (n *node) observe(v any) {
+	if n.types == nil {
+		n.types = map[string]int{}
+	}
+	t := typeOf(v)
+	n.types[t]++
+
+	switch val := v.(type) {
+	case map[string]any:
+		if n.fields == nil {
+			n.fields = map[string]*node{}
+		}
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		// A map has no order; sorting makes the first-seen order of one
+		// sample's keys deterministic at least.
+		sort.Strings(keys)
+		for _, key := range keys {
+			child, ok := n.fields[key]
+			if !ok {
+				child = &node{}
+				n.fields[key] = child
+				n.order = append(n.order, key)
+			}
+			child.observe(val[key])
+		}
+	case []any:
+		for _, item := range val {
+			if n.items == nil {
+				n.items = &node{}
+			}
+			n.items.observe(item)
+		}
+	default:
+		if n.examples == nil {
+			n.examples = map[string]any{}
+		}
+		if _, ok := n.examples[t]; !ok {
+			n.examples[t] = v
+		}
+		if s, ok := v.(string); ok {
+			if n.formats == nil {
+				n.formats = map[string]int{}
+			}
+			for _, f := range formatsOf(s) {
+				n.formats[f]++
+			}
+		}
+	}
+}
+
+// Schema proposes a JSON Schema (draft 2020-12) that every observed sample
+// satisfies. A field is required only when every object at its position had
+// it; a position that held several types lists them all.
+func (l *Learner) Schema() map[string]any {
+	s := l.root.schema()
+	s["$schema"] = SchemaDialect
+	return s
+}
+
+func (n *node) schema() map[string]any {
+	s := map[string]any{}
+	types := n.typeList()
+	switch len(types) {
+	case 0:
+		return s
+	case 1:
+		s["type"] = types[0]
+	default:
+		list := make([]any, len(types))
+		for i, t := range types {
+			list[i] = t
+		}
+		s["type"] = list
+	}
+
+	if n.types[TypeObject] > 0 {
+		props := map[string]any{}
+		var required []any
+		for _, key := range n.order {
+			child := n.fields[key]
+			props[key] = child.schema()
+			if child.count() == n.types[TypeObject] {
+				required = append(required, key)
+			}
+		}
+		s["properties"] = props
+		if len(required) > 0 {
+			s["required"] = required
+		}
+	}
+	if n.items != nil {
+		s["items"] = n.items.schema()
+	}
+	if seen := n.types[TypeString]; seen > 0 && len(types) == 1 {
+		for _, f := range formatNames {
+			if n.formats[f] == seen {
+				s["format"] = f
+				break
+			}
+		}
+	}
+	return s
+}
+
+// typeList folds integer into number when both were seen: 1 and 1.5 at the
+// same position is a number that happened to be whole once.
+func (n *node) typeList() []string {
+	var out []string
+	for _, t := range typeOrder {
+		if n.types[t] == 0 {
+			continue
+		}
+		if t == TypeInteger && n.types[TypeNumber] > 0 {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+func (n *node) count() int {
+	total := 0
+	for _, c := range n.types {
+		total += c
+	}
+	return total
+}
+
+// Example proposes one value with the shape of the samples, in the form
+// json_decode's decoded and csv_decode's rows settings take. It takes the most
+// common type at each position, lists every field ever seen — optional ones
+// too, since an expression may read them — and gives an array one element
+// that merges all the elements seen.
+func (l *Learner) Example() any {
+	return l.root.example()
+}
+
+func (n *node) example() any {
+	t := n.dominant()
+	switch t {
+	case TypeObject:
+		obj := make(map[string]any, len(n.order))
+		for _, key := range n.order {
+			obj[key] = n.fields[key].example()
+		}
+		return obj
+	case TypeArray:
+		if n.items == nil {
+			return []any{}
+		}
+		return []any{n.items.example()}
+	case "":
+		return nil
+	}
+	return n.examples[t]
+}
+
+// dominant is the type seen most often, preferring anything to null: a field
+// that is sometimes null is still a string field.
+func (n *node) dominant() string {
+	best, bestCount := "", 0
+	for _, t := range typeOrder {
+		c := n.types[t]
+		if t == TypeNull && best != "" {
+			continue
+		}
+		if c > bestCount {
+			best, bestCount = t, c
+		}
+	}
+	return best
+}
+
+func typeOf(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case string:
+		return TypeString
+	case map[string]any:
+		return TypeObject
+	case []any:
+		return TypeArray
+	case json.Number:
+		if strings.ContainsAny(string(t), ".eE") {
+			return TypeNumber
+		}
+		return TypeInteger
+	case float64:
+		if t == float64(int64(t)) {
+			return TypeInteger
+		}
+		return TypeNumber
+	case float32:
+		return TypeNumber
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return TypeInteger
+	}
+	return TypeString
+}
+
+// formatNames are the string formats proposed, most specific first.
+var formatNames = []string{"date-time", "date", "uuid", "email", "uri"}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uriPattern   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://\S+$`)
+)
+
+func formatsOf(s string) []string {
+	var out []string
+	if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		out = append(out, "date-time")
+	}
+	if _, err := time.Parse(time.DateOnly, s); err == nil {
+		out = append(out, "date")
+	}
+	if uuidPattern.MatchString(s) {
+		out = append(out, "uuid")
+	}
+	if emailPattern.MatchString(s) {
+		out = append(out, "email")
+	}
+	if uriPattern.MatchString(s) {
+		out = append(out, "uri")
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package infer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func learn(t *testing.T, samples ...string) *Learner {
+	t.Helper()
+	l := &Learner{}
+	for _, s := range samples {
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		l.Observe(v)
+	}
+	return l
+}
+
+func TestOptionalFieldsAreNotRequired(t *testing.T) {
+	l := learn(t, `{"id":1,"name":"a"}`, `{"id":2}`)
+	s := l.Schema()
+	if !reflect.DeepEqual(s["required"], []any{"id"}) {
+		t.Fatalf("required = %v, want only the field every sample had", s["required"])
+	}
+	if _, ok := s["properties"].(map[string]any)["name"]; !ok {
+		t.Fatal("the optional field is missing from properties")
+	}
+	if s["$schema"] != SchemaDialect {
+		t.Errorf("$schema = %v", s["$schema"])
+	}
+}
+
+// A field that held different types lists them all; one that was whole once
+// and fractional once is a number, not integer-or-number.
+func TestUnionTypes(t *testing.T) {
+	props := learn(t, `{"v":1,"n":1}`, `{"v":"x","n":1.5}`, `{"v":null,"n":2}`).Schema()["properties"].(map[string]any)
+	if got := props["v"].(map[string]any)["type"]; !reflect.DeepEqual(got, []any{"string", "integer", "null"}) {
+		t.Errorf("v type = %v", got)
+	}
+	if got := props["n"].(map[string]any)["type"]; got != "number" {
+		t.Errorf("n type = %v", got)
+	}
+}
+
+// Every element of every array feeds one element shape.
+func TestArrayElementsMerge(t *testing.T) {
+	l := learn(t, `{"items":[{"sku":"a"},{"sku":"b","qty":2}]}`, `{"items":[]}`)
+	items := l.Schema()["properties"].(map[string]any)["items"].(map[string]any)["items"].(map[string]any)
+	if !reflect.DeepEqual(items["required"], []any{"sku"}) {
+		t.Errorf("element required = %v", items["required"])
+	}
+	want := map[string]any{"items": []any{map[string]any{"sku": "a", "qty": float64(2)}}}
+	if got := l.Example(); !reflect.DeepEqual(got, want) {
+		t.Errorf("example = %v, want %v", got, want)
+	}
+}
+
+// The example takes the most common type, and a field that is sometimes null
+// is shown with its value rather than as null.
+func TestExamplePrefersValuesToNull(t *testing.T) {
+	got := learn(t, `{"a":null}`, `{"a":null}`, `{"a":"x"}`).Example()
+	if !reflect.DeepEqual(got, map[string]any{"a": "x"}) {
+		t.Fatalf("example = %v", got)
+	}
+}
+
+func TestFormatsNeedEverySampleToMatch(t *testing.T) {
+	props := learn(t,
+		`{"at":"2024-05-01T10:00:00Z","id":"8c5f6f0e-7d4b-4a8e-9e55-2b1c3f0e9a11","mail":"a@b.co"}`,
+		`{"at":"2024-05-02T11:30:00+02:00","id":"not-a-uuid","mail":"c@d.org"}`,
+	).Schema()["properties"].(map[string]any)
+	if props["at"].(map[string]any)["format"] != "date-time" || props["mail"].(map[string]any)["format"] != "email" {
+		t.Errorf("props = %v", props)
+	}
+	if _, ok := props["id"].(map[string]any)["format"]; ok {
+		t.Errorf("id format proposed from one matching sample of two")
+	}
+}
+
+// csv_decode rows are string-valued objects; the learner takes them as they
+// are, which is what makes it shareable between decoders.
+func TestRowsOfStrings(t *testing.T) {
+	l := &Learner{}
+	l.Observe(map[string]any{"name": "a", "amount": "10"})
+	l.Observe(map[string]any{"name": "b", "amount": "12"})
+	if got := l.Example(); !reflect.DeepEqual(got, map[string]any{"name": "a", "amount": "10"}) {
+		t.Fatalf("example = %v", got)
+	}
+	if l.Samples() != 2 {
+		t.Fatalf("samples = %d", l.Samples())
+	}
+}
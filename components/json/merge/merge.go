@@ -0,0 +1,249 @@
+// Package merge combines two JSON documents: defaults with overrides, or a
+// partial update with the record it updates.
+//
+// With the default settings it is exactly RFC 7386 JSON Merge Patch, which is
+// what PATCH endpoints with application/merge-patch+json expect. The array and
+// null settings loosen that for the cases the RFC does not cover well, such as
+// a list of items that should be merged by id rather than replaced.
+package merge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "json_merge"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+
+	ArraysReplace = "replace"
+	ArraysConcat  = "concat"
+	ArraysByKey   = "mergeByKey"
+
+	NullsDelete = "delete"
+	NullsKeep   = "keep"
+)
+
+type Context any
+
+type Document any
+
+type Request struct {
+	Context Context  `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the merged document"`
+	Target  Document `json:"target" required:"true" configurable:"true" title:"Target" description:"The document merged into: the defaults, or the record being updated."`
+	Patch   Document `json:"patch" required:"true" configurable:"true" title:"Patch" description:"What is merged in: the overrides, or the partial update. Where both have a value, the patch wins."`
+}
+
+type Response struct {
+	Context Context  `json:"context,omitempty" title:"Context"`
+	Merged  Document `json:"merged" configurable:"true" title:"Merged"`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+type Settings struct {
+	Arrays   string `json:"arrays" default:"replace" enum:"replace,concat,mergeByKey" enumTitles:"Replace|Concatenate|Merge by key" title:"Arrays" description:"What happens where both documents have an array. Replace (RFC 7386): the patch's array wins whole. Concatenate: the patch's elements are appended to the target's. Merge by key: objects with the same key field are merged, and the rest of the patch's elements appended."`
+	MergeKey string `json:"mergeKey" title:"Merge Key" description:"Field that identifies an array element for merge by key, such as id or sku. Elements without it are appended rather than matched."`
+	Nulls    string `json:"nulls" default:"delete" enum:"delete,keep" enumTitles:"Delete the key|Keep as null" title:"Nulls" description:"What a null in the patch means. Delete (RFC 7386): remove the key from the target. Keep: set the key to null, for APIs where null is a value rather than an instruction."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "JSON Merge",
+		Info: "Merges a patch document into a target document: defaults with overrides, or a partial update into a " +
+			"cached record. Objects merge key by key at every depth and the patch wins where both have a value. " +
+			"With the default settings this is RFC 7386 JSON Merge Patch: arrays are replaced whole and a null in the " +
+			"patch deletes the key. Set arrays to concatenate or merge by key for lists of items, and nulls to keep " +
+			"when null is a value the target should hold. Neither input is changed.",
+		Tags: []string{"json"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+	in, ok := msg.(Request)
+	if !ok {
+		return module.Fail(fmt.Errorf("invalid message"))
+	}
+
+	if c.settings.Arrays == ArraysByKey && c.settings.MergeKey == "" {
+		return c.handleError(ctx, handler, in.Context, fmt.Errorf("arrays is set to merge by key but mergeKey is empty — name the field that identifies an element, such as id"))
+	}
+
+	// Normalizing copies both sides, so merging in place below cannot reach
+	// the caller's values.
+	target, err := value.Normalize(in.Target)
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, fmt.Errorf("target: %w", err))
+	}
+	patch, err := value.Normalize(in.Patch)
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, fmt.Errorf("patch: %w", err))
+	}
+
+	return handler(ctx, ResponsePort, Response{
+		Context: in.Context,
+		Merged:  c.merge(target, patch),
+	})
+}
+
+// merge follows RFC 7386: a patch that is not an object replaces the target,
+// and an object patch is applied member by member, recursively. The array and
+// null settings change only the two places the RFC fixes an answer.
+func (c *Component) merge(target, patch any) any {
+	if p, ok := patch.([]any); ok {
+		if t, ok := target.([]any); ok {
+			return c.mergeArrays(t, p)
+		}
+	}
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for key, pv := range p {
+		if pv == nil && c.settings.Nulls != NullsKeep {
+			delete(t, key)
+			continue
+		}
+		if tv, ok := t[key]; ok {
+			t[key] = c.merge(tv, pv)
+			continue
+		}
+		// A new key takes the patch's value, with its own nulls removed the
+		// way merging it into an empty object would.
+		t[key] = c.merge(nil, pv)
+	}
+	return t
+}
+
+func (c *Component) mergeArrays(target, patch []any) any {
+	switch c.settings.Arrays {
+	case ArraysConcat:
+		return append(target, patch...)
+	case ArraysByKey:
+		return c.mergeByKey(target, patch)
+	}
+	return patch
+}
+
+// mergeByKey keeps the target's order, merges each patch element into the
+// target element with the same key, and appends the ones that matched nothing
+// as merged into nothing, so their nulls go the way a matched element's do.
+func (c *Component) mergeByKey(target, patch []any) []any {
+	key := c.settings.MergeKey
+	for _, pe := range patch {
+		id, ok := keyOf(pe, key)
+		if !ok {
+			target = append(target, c.merge(nil, pe))
+			continue
+		}
+		matched := false
+		for i, te := range target {
+			if tid, ok := keyOf(te, key); ok && value.Equal(tid, id) {
+				target[i] = c.merge(te, pe)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			target = append(target, c.merge(nil, pe))
+		}
+	}
+	return target
+}
+
+func keyOf(element any, key string) (any, bool) {
+	obj, ok := element.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	id, ok := obj[key]
+	return id, ok && id != nil
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+func (c *Component) Ports() []module.Port {
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: Request{},
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: Response{},
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
@@ -0,0 +1,160 @@
+package merge
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func parse(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func merged(t *testing.T, target, patch string, settings Settings) any {
+	t.Helper()
+	port, msg, err := run(t, Request{Target: parse(t, target), Patch: parse(t, patch)}, settings)
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if port != ResponsePort {
+		t.Fatalf("emitted on %q, want %q", port, ResponsePort)
+	}
+	return msg.(Response).Merged
+}
+
+func check(t *testing.T, got any, want string) {
+	t.Helper()
+	w, err := value.Normalize(parse(t, want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !value.Equal(got, w) {
+		data, _ := json.Marshal(got)
+		t.Errorf("merged = %s, want %s", data, want)
+	}
+}
+
+// The test cases from RFC 7386 appendix A, under the default settings.
+func TestRFC7386Examples(t *testing.T) {
+	for _, tc := range [][3]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		check(t, merged(t, tc[0], tc[1], Settings{}), tc[2])
+	}
+}
+
+func TestDefaultsAndOverrides(t *testing.T) {
+	got := merged(t,
+		`{"retries":3,"timeout":{"connect":5,"read":30},"tags":["a"]}`,
+		`{"timeout":{"read":60},"tags":["b"]}`, Settings{})
+	check(t, got, `{"retries":3,"timeout":{"connect":5,"read":60},"tags":["b"]}`)
+}
+
+func TestArraysConcat(t *testing.T) {
+	got := merged(t, `{"tags":["a"],"n":1}`, `{"tags":["b","a"]}`, Settings{Arrays: ArraysConcat})
+	check(t, got, `{"tags":["a","b","a"],"n":1}`)
+}
+
+// Elements matched by key merge; the rest keep their place or are appended.
+func TestArraysMergeByKey(t *testing.T) {
+	got := merged(t,
+		`{"items":[{"id":1,"qty":1,"note":"x"},{"id":2,"qty":5},"loose"]}`,
+		`{"items":[{"id":2,"qty":6},{"id":3,"qty":1},{"qty":9},{"id":1.0,"note":null}]}`,
+		Settings{Arrays: ArraysByKey, MergeKey: "id"})
+	check(t, got, `{"items":[{"id":1,"qty":1},{"id":2,"qty":6},"loose",{"id":3,"qty":1},{"qty":9}]}`)
+}
+
+// Whether an element was already there must not decide what its nulls do:
+// under nulls=delete they go from an appended element as from a merged one.
+func TestMergeByKeyDeletesNullsInAppendedElements(t *testing.T) {
+	patch := `{"items":[{"id":1,"note":null,"qty":2},{"note":null,"qty":3}]}`
+	settings := Settings{Arrays: ArraysByKey, MergeKey: "id"}
+	check(t, merged(t, `{"items":[{"id":1,"note":"x"}]}`, patch, settings), `{"items":[{"id":1,"qty":2},{"qty":3}]}`)
+	check(t, merged(t, `{"items":[]}`, patch, settings), `{"items":[{"id":1,"qty":2},{"qty":3}]}`)
+}
+
+func TestMergeByKeyNeedsAKey(t *testing.T) {
+	port, msg, err := run(t, Request{Target: parse(t, `{}`), Patch: parse(t, `{}`)}, Settings{Arrays: ArraysByKey, EnableErrorPort: true})
+	if err != nil || port != ErrorPort {
+		t.Fatalf("port = %q, err = %v", port, err)
+	}
+	if !strings.Contains(msg.(Error).Error, "mergeKey") {
+		t.Errorf("error = %q", msg.(Error).Error)
+	}
+}
+
+func TestNullsKeep(t *testing.T) {
+	got := merged(t, `{"a":"b","c":{"d":1}}`, `{"a":null,"c":{"d":null},"e":null}`, Settings{Nulls: NullsKeep})
+	check(t, got, `{"a":null,"c":{"d":null},"e":null}`)
+}
+
+// The merge works on copies: a flow that reuses its defaults object for the
+// next message must find it unchanged.
+func TestInputsAreNotChanged(t *testing.T) {
+	target := map[string]any{"a": map[string]any{"b": float64(1)}, "list": []any{"x"}}
+	patch := map[string]any{"a": map[string]any{"b": nil, "c": float64(2)}, "list": []any{"y"}}
+	merged := func() any {
+		_, msg, err := run(t, Request{Target: target, Patch: patch}, Settings{Arrays: ArraysConcat})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.(Response).Merged
+	}()
+	check(t, merged, `{"a":{"c":2},"list":["x","y"]}`)
+	if want := map[string]any{"a": map[string]any{"b": float64(1)}, "list": []any{"x"}}; !reflect.DeepEqual(target, want) {
+		t.Fatalf("target changed to %v", target)
+	}
+}
+
+func TestContextIsCarried(t *testing.T) {
+	_, msg, err := run(t, Request{Context: "ctx-1", Target: parse(t, `{}`), Patch: parse(t, `{}`)}, Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.(Response).Context != "ctx-1" {
+		t.Fatalf("context = %v", msg.(Response).Context)
+	}
+}
@@ -0,0 +1,163 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
+	"github.com/tiny-systems/encoding-module/components/json/value"
+)
+
+// Operation is one step of a patch.
+type Operation struct {
+	Op    string `json:"op" required:"true" enum:"add,remove,replace,move,copy,test" enumTitles:"Add|Remove|Replace|Move|Copy|Test" title:"Op"`
+	Path  string `json:"path" required:"true" title:"Path" description:"JSON Pointer to the target, such as /items/0/name. /items/- means after the last element."`
+	From  string `json:"from,omitempty" title:"From" description:"JSON Pointer to the source, for move and copy."`
+	Value any    `json:"value,omitempty" configurable:"true" title:"Value" description:"The value for add, replace and test."`
+
+	// noValue is set when the operation was read from JSON without a value
+	// member, which for add, replace and test is an error rather than null.
+	noValue bool
+}
+
+// UnmarshalJSON notes whether value was there at all.
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	type plain Operation
+	var p struct {
+		plain
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*o = Operation(p.plain)
+	o.Value, o.noValue = nil, len(p.Value) == 0
+	if o.noValue {
+		return nil
+	}
+	return json.Unmarshal(p.Value, &o.Value)
+}
+
+// MarshalJSON writes value for the operations that have one, null included —
+// "replace with null" and "no value" are different patches.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	type plain struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		From  string `json:"from,omitempty"`
+		Value *any   `json:"value,omitempty"`
+	}
+	p := plain{Op: o.Op, Path: o.Path, From: o.From}
+	switch o.Op {
+	case OpAdd, OpReplace, OpTest:
+		if !o.noValue {
+			p.Value = &o.Value
+		}
+	}
+	return json.Marshal(p)
+}
+
+// opError is an operation that could not be applied.
+type opError struct {
+	index int
+	op    string
+	err   error
+}
+
+func (e *opError) Error() string {
+	return fmt.Sprintf("operation %d (%s): %v", e.index, e.op, e.err)
+}
+
+func (e *opError) Unwrap() error {
+	return e.err
+}
+
+// apply runs ops against a copy of doc, so a failure partway leaves nothing
+// half-changed: the caller gets either the fully patched document or an error.
+func apply(doc any, ops []Operation) (any, error) {
+	doc, err := value.Normalize(doc)
+	if err != nil {
+		return nil, fmt.Errorf("document: %w", err)
+	}
+	for i, op := range ops {
+		if doc, err = applyOne(doc, op); err != nil {
+			return nil, &opError{index: i, op: op.Op, err: err}
+		}
+	}
+	return doc, nil
+}
+
+func applyOne(doc any, op Operation) (any, error) {
+	path, err := pointer.Parse(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case OpAdd, OpReplace, OpTest:
+		if op.noValue {
+			return nil, fmt.Errorf("%s needs a value", op.Op)
+		}
+		want, err := value.Normalize(op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+		switch op.Op {
+		case OpAdd:
+			return pointer.Add(doc, path, want)
+		case OpReplace:
+			return pointer.Replace(doc, path, want)
+		}
+		current, err := pointer.Get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !value.Equal(current, want) {
+			return nil, fmt.Errorf("test failed: %s is %s, want %s", where(op.Path), value.Show(current), value.Show(want))
+		}
+		return doc, nil
+
+	case OpRemove:
+		doc, _, err = pointer.Remove(doc, path)
+		return doc, err
+
+	case OpMove, OpCopy:
+		from, err := pointer.Parse(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		if op.Op == OpMove {
+			if op.From == op.Path {
+				return doc, nil
+			}
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("cannot move %s into its own child %s", where(op.From), op.Path)
+			}
+			var moved any
+			if doc, moved, err = pointer.Remove(doc, from); err != nil {
+				return nil, err
+			}
+			return pointer.Add(doc, path, moved)
+		}
+		source, err := pointer.Get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		// A copy must not share maps or slices with its source, or a later
+		// operation on one would change both.
+		copied, err := value.Normalize(source)
+		if err != nil {
+			return nil, err
+		}
+		return pointer.Add(doc, path, copied)
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+func where(path string) string {
+	if path == "" {
+		return "the document"
+	}
+	return path
+}
@@ -0,0 +1,102 @@
+package patch
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/tiny-systems/encoding-module/components/json/pointer"
+	"github.com/tiny-systems/encoding-module/components/json/value"
+)
+
+// generate returns operations that turn original into modified. Objects are
+// compared key by key and arrays element by element after setting aside the
+// elements both ends have in common, so one insertion near the start of a
+// long list is one add rather than a replace of every element after it.
+func generate(original, modified any) ([]Operation, error) {
+	a, err := value.Normalize(original)
+	if err != nil {
+		return nil, fmt.Errorf("original: %w", err)
+	}
+	b, err := value.Normalize(modified)
+	if err != nil {
+		return nil, fmt.Errorf("modified: %w", err)
+	}
+	return diff([]Operation{}, []string{}, a, b), nil
+}
+
+func diff(ops []Operation, path []string, a, b any) []Operation {
+	if value.Equal(a, b) {
+		return ops
+	}
+	switch x := a.(type) {
+	case map[string]any:
+		if y, ok := b.(map[string]any); ok {
+			return diffObjects(ops, path, x, y)
+		}
+	case []any:
+		if y, ok := b.([]any); ok {
+			return diffArrays(ops, path, x, y)
+		}
+	}
+	return append(ops, Operation{Op: OpReplace, Path: pointer.Format(path), Value: b})
+}
+
+// diffObjects visits keys in sorted order so the same two documents always
+// produce the same patch.
+func diffObjects(ops []Operation, path []string, a, b map[string]any) []Operation {
+	for _, key := range sortedKeys(a) {
+		if _, ok := b[key]; !ok {
+			ops = append(ops, Operation{Op: OpRemove, Path: pointer.Format(child(path, key))})
+		}
+	}
+	for _, key := range sortedKeys(b) {
+		av, ok := a[key]
+		if !ok {
+			ops = append(ops, Operation{Op: OpAdd, Path: pointer.Format(child(path, key)), Value: b[key]})
+			continue
+		}
+		ops = diff(ops, child(path, key), av, b[key])
+	}
+	return ops
+}
+
+func diffArrays(ops []Operation, path []string, a, b []any) []Operation {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && value.Equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && value.Equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// What both middles have, change in place; then remove what original has
+	// beyond that, from the highest index down so each index still means what
+	// it did, or insert what modified has.
+	common := min(len(middleA), len(middleB))
+	for i := 0; i < common; i++ {
+		ops = diff(ops, child(path, strconv.Itoa(prefix+i)), middleA[i], middleB[i])
+	}
+	for i := len(middleA) - 1; i >= common; i-- {
+		ops = append(ops, Operation{Op: OpRemove, Path: pointer.Format(child(path, strconv.Itoa(prefix+i)))})
+	}
+	for i := common; i < len(middleB); i++ {
+		ops = append(ops, Operation{Op: OpAdd, Path: pointer.Format(child(path, strconv.Itoa(prefix+i))), Value: middleB[i]})
+	}
+	return ops
+}
+
+func child(path []string, token string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), token)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
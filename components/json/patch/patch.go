@@ -0,0 +1,200 @@
+// Package patch applies and generates RFC 6902 JSON Patch documents.
+//
+// Flows kept editing documents in js_eval — set this field, drop that one,
+// check a version before writing — and diffing them the same way. A patch is
+// the standard form for both: it can be stored, sent to an API that accepts
+// application/json-patch+json, and its test operation turns an update into a
+// compare-and-swap.
+package patch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "json_patch"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+
+	ModeApply    = "apply"
+	ModeGenerate = "generate"
+
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+	OpMove    = "move"
+	OpCopy    = "copy"
+	OpTest    = "test"
+)
+
+type Context any
+
+type Document any
+
+// Request is what apply mode takes.
+type Request struct {
+	Context  Context     `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the result"`
+	Document Document    `json:"document" required:"true" configurable:"true" title:"Document" description:"The document to change."`
+	Patch    []Operation `json:"patch" required:"true" title:"Patch" description:"Operations applied in order. Either all of them apply or none do."`
+}
+
+// Response is what apply mode emits.
+type Response struct {
+	Context  Context  `json:"context,omitempty" title:"Context"`
+	Document Document `json:"document" configurable:"true" title:"Document" description:"The patched document."`
+	Applied  int      `json:"applied" title:"Applied" description:"Operations applied."`
+}
+
+// GenerateRequest is what generate mode takes.
+type GenerateRequest struct {
+	Context  Context  `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the result"`
+	Original Document `json:"original" required:"true" configurable:"true" title:"Original" description:"The document as it was."`
+	Modified Document `json:"modified" required:"true" configurable:"true" title:"Modified" description:"The document as it should be."`
+}
+
+// GenerateResponse is what generate mode emits.
+type GenerateResponse struct {
+	Context Context     `json:"context,omitempty" title:"Context"`
+	Patch   []Operation `json:"patch" title:"Patch" description:"Operations that turn original into modified. Empty when they are equal."`
+	Equal   bool        `json:"equal" title:"Equal"`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+	Index   int     `json:"index" title:"Index" description:"0-based position of the operation that failed, or -1 when the error is not about one operation."`
+	Op      string  `json:"op,omitempty" title:"Op" description:"The failed operation's op. A test here means the document was not in the state the patch expected — someone else changed it first."`
+}
+
+type Settings struct {
+	Mode string `json:"mode" default:"apply" enum:"apply,generate" enumTitles:"Apply a patch|Generate a patch" title:"Mode" description:"Apply changes a document with a patch. Generate compares two documents and emits the patch between them."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run. A failed test operation is an error, so this is how a flow branches on it."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "JSON Patch",
+		Info: "Applies an RFC 6902 JSON Patch — add, remove, replace, move, copy and test — to a document, or generates " +
+			"the patch between two documents. " +
+			"Apply is atomic: if any operation fails the document is left as it was and the error names the operation " +
+			"by index. Put a test operation first to check a version or etag field, and route the error port back to a " +
+			"re-read: that is optimistic concurrency in a flow. " +
+			"Generate emits a short patch rather than a replace of the whole document; applying it to original gives " +
+			"modified.",
+		Tags: []string{"json"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+
+	switch in := msg.(type) {
+	case Request:
+		doc, err := apply(in.Document, in.Patch)
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		return handler(ctx, ResponsePort, Response{
+			Context:  in.Context,
+			Document: doc,
+			Applied:  len(in.Patch),
+		})
+	case GenerateRequest:
+		ops, err := generate(in.Original, in.Modified)
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		return handler(ctx, ResponsePort, GenerateResponse{
+			Context: in.Context,
+			Patch:   ops,
+			Equal:   len(ops) == 0,
+		})
+	}
+	return module.Fail(fmt.Errorf("invalid message"))
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	out := Error{Context: reqCtx, Error: err.Error(), Index: -1}
+	if opErr, ok := err.(*opError); ok {
+		out.Index, out.Op = opErr.index, opErr.op
+	}
+	return handler(ctx, ErrorPort, out)
+}
+
+func (c *Component) Ports() []module.Port {
+	var request, response any = Request{}, Response{}
+	if c.settings.Mode == ModeGenerate {
+		request, response = GenerateRequest{}, GenerateResponse{}
+	}
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: request,
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: response,
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
@@ -0,0 +1,239 @@
+package patch
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in any, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+// parse gives a test document the shape apply produces: maps, slices and
+// json.Number.
+func parse(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	n, err := value.Normalize(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func ops(t *testing.T, s string) []Operation {
+	t.Helper()
+	var out []Operation
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func patched(t *testing.T, doc, patch string) any {
+	t.Helper()
+	port, msg, err := run(t, Request{Document: parse(t, doc), Patch: ops(t, patch)}, Settings{})
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if port != ResponsePort {
+		t.Fatalf("emitted on %q, want %q", port, ResponsePort)
+	}
+	return msg.(Response).Document
+}
+
+// Examples from RFC 6902 appendix A.
+func TestRFC6902Examples(t *testing.T) {
+	for _, tc := range []struct{ name, doc, patch, want string }{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"add nested", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{"append", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{"null value", `{"foo":null}`, `[{"op":"test","path":"/foo","value":null}]`, `{"foo":null}`},
+		{"escaped keys", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{"copy", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`},
+		{"replace root", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	} {
+		got := patched(t, tc.doc, tc.patch)
+		if want := parse(t, tc.want); !value.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, want)
+		}
+	}
+}
+
+// 1 and 1.0 are the same JSON number, so a test written either way passes.
+func TestTestComparesNumbersByValue(t *testing.T) {
+	patched(t, `{"v":1}`, `[{"op":"test","path":"/v","value":1.0}]`)
+	patched(t, `{"v":[1,{"a":2e0}]}`, `[{"op":"test","path":"/v","value":[1,{"a":2}]}]`)
+}
+
+// The point of test: a version check that fails stops the update and says
+// which operation stopped it.
+func TestFailedTestRoutesToErrorPortWithIndex(t *testing.T) {
+	in := Request{
+		Document: parse(t, `{"version":3,"name":"a"}`),
+		Patch:    ops(t, `[{"op":"replace","path":"/name","value":"b"},{"op":"test","path":"/version","value":2}]`),
+	}
+	port, msg, err := run(t, in, Settings{EnableErrorPort: true})
+	if err != nil || port != ErrorPort {
+		t.Fatalf("port = %q, err = %v, want the error port", port, err)
+	}
+	e := msg.(Error)
+	if e.Index != 1 || e.Op != OpTest {
+		t.Fatalf("index = %d, op = %q, want 1, test", e.Index, e.Op)
+	}
+	if !strings.Contains(e.Error, "/version is 3, want 2") {
+		t.Errorf("error = %q", e.Error)
+	}
+}
+
+// An operation that fails after others applied must not leave them applied to
+// the caller's document.
+func TestApplyIsAtomic(t *testing.T) {
+	doc := map[string]any{"a": map[string]any{"b": float64(1)}, "list": []any{"x"}}
+	in := Request{Document: doc, Patch: ops(t, `[
+		{"op":"replace","path":"/a/b","value":2},
+		{"op":"add","path":"/list/0","value":"y"},
+		{"op":"remove","path":"/missing"}
+	]`)}
+	_, _, err := run(t, in, Settings{})
+	if err == nil {
+		t.Fatal("a patch with a failing operation succeeded")
+	}
+	want := map[string]any{"a": map[string]any{"b": float64(1)}, "list": []any{"x"}}
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("document changed to %v", doc)
+	}
+}
+
+func TestInvalidOperationsAreRefused(t *testing.T) {
+	for _, patch := range []string{
+		`[{"op":"add","path":"/a/b/c","value":1}]`,
+		`[{"op":"replace","path":"/nope","value":1}]`,
+		`[{"op":"add","path":"/list/5","value":1}]`,
+		`[{"op":"move","from":"/a","path":"/a/b"}]`,
+		`[{"op":"remove","path":"/list/01"}]`,
+		`[{"op":"frobnicate","path":"/a"}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+	} {
+		in := Request{Document: parse(t, `{"a":{},"list":[1]}`), Patch: ops(t, patch)}
+		if port, _, err := run(t, in, Settings{}); err == nil {
+			t.Errorf("%s: accepted, emitted on %q", patch, port)
+		}
+	}
+}
+
+// RFC 6902 requires value for add, replace and test: one left out is not
+// null, and a test without it must not pass against a null.
+func TestMissingValueIsRefused(t *testing.T) {
+	for _, op := range []string{OpAdd, OpReplace, OpTest} {
+		in := Request{
+			Document: parse(t, `{"x":null}`),
+			Patch:    ops(t, `[{"op":"test","path":"/x","value":null},{"op":"`+op+`","path":"/x"}]`),
+		}
+		port, msg, err := run(t, in, Settings{EnableErrorPort: true})
+		if err != nil || port != ErrorPort {
+			t.Fatalf("%s: port = %q, err = %v, want the error port", op, port, err)
+		}
+		if e := msg.(Error); e.Index != 1 || !strings.Contains(e.Error, "needs a value") {
+			t.Errorf("%s: error = %+v", op, e)
+		}
+	}
+}
+
+// A copied value must be independent of its source.
+func TestCopyDoesNotAlias(t *testing.T) {
+	got := patched(t, `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`)
+	if want := parse(t, `{"a":{"b":1},"c":{"b":2}}`); !value.Equal(got, want) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func generated(t *testing.T, original, modified string) GenerateResponse {
+	t.Helper()
+	port, msg, err := run(t, GenerateRequest{Original: parse(t, original), Modified: parse(t, modified)}, Settings{Mode: ModeGenerate})
+	if err != nil || port != ResponsePort {
+		t.Fatalf("port = %q, err = %v", port, err)
+	}
+	return msg.(GenerateResponse)
+}
+
+func TestGenerateRoundTrips(t *testing.T) {
+	for _, tc := range [][2]string{
+		{`{"a":1,"b":{"c":[1,2,3]}}`, `{"a":1,"b":{"c":[1,2,3,4]},"d":null}`},
+		{`{"a":[1,2,3,4,5]}`, `{"a":[1,9,2,3,4,5]}`},
+		{`{"a":[1,2,3,4,5]}`, `{"a":[1,5]}`},
+		{`{"a":[{"id":1,"v":"x"},{"id":2,"v":"y"}]}`, `{"a":[{"id":1,"v":"x"},{"id":2,"v":"z"}]}`},
+		{`{"a":"x"}`, `[1,2]`},
+		{`{"a/b":{"m~n":1}}`, `{"a/b":{"m~n":2}}`},
+	} {
+		out := generated(t, tc[0], tc[1])
+		got := patched(t, tc[0], mustMarshal(t, out.Patch))
+		if !value.Equal(got, parse(t, tc[1])) {
+			t.Errorf("%s -> %s: patch %s gave %v", tc[0], tc[1], mustMarshal(t, out.Patch), got)
+		}
+	}
+}
+
+// One insertion near the start of a list is one operation, not a replace of
+// every element after it.
+func TestGenerateIsMinimal(t *testing.T) {
+	out := generated(t, `{"a":[1,2,3,4,5],"keep":{"x":1}}`, `{"a":[1,9,2,3,4,5],"keep":{"x":1}}`)
+	if got := mustMarshal(t, out.Patch); got != `[{"op":"add","path":"/a/1","value":9}]` {
+		t.Fatalf("patch = %s", got)
+	}
+	out = generated(t, `{"a":{"b":1}}`, `{"a":{"b":null}}`)
+	if got := mustMarshal(t, out.Patch); got != `[{"op":"replace","path":"/a/b","value":null}]` {
+		t.Fatalf("patch = %s, want the null value written", got)
+	}
+}
+
+// Equal documents give an empty patch, [], which applies as a no-op; null
+// is not a patch at all.
+func TestGenerateEqualDocuments(t *testing.T) {
+	out := generated(t, `{"a":[1,{"b":2}]}`, `{"a":[1.0,{"b":2}]}`)
+	if !out.Equal || len(out.Patch) != 0 {
+		t.Fatalf("equal = %v, patch = %v", out.Equal, out.Patch)
+	}
+	if got := mustMarshal(t, out.Patch); got != "[]" {
+		t.Fatalf("patch = %s, want []", got)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,144 @@
+package pointer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Add puts value at tokens the way RFC 6902 add does: an object member is set
+// whether or not it existed, an array element is inserted before the index,
+// and "-" appends. The parent must exist. It returns the new root, which is
+// value itself when tokens is empty.
+//
+// Edits happen in place on the maps and slices they reach; a caller that needs
+// the original intact copies it first.
+func Add(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return edit(doc, tokens, 0, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			index := len(node)
+			if token != "-" {
+				// The length itself is allowed: inserting there appends.
+				i, err := Index(token, len(node)+1)
+				if errors.Is(err, ErrNotFound) {
+					return nil, fmt.Errorf("%s: index %s is past the end of an array of %d: %w", where(tokens[:len(tokens)-1]), token, len(node), ErrNotFound)
+				}
+				if err != nil {
+					return nil, err
+				}
+				index = i
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		return nil, notContainer(parent, tokens)
+	})
+}
+
+// Remove deletes what tokens point at and returns the new root and the value
+// that was removed. An array closes the gap.
+func Remove(doc any, tokens []string) (any, any, error) {
+	if len(tokens) == 0 {
+		return nil, nil, errors.New("the whole document cannot be removed")
+	}
+	var removed any
+	root, err := edit(doc, tokens, 0, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, missing(tokens)
+			}
+			removed = value
+			delete(node, token)
+			return node, nil
+		case []any:
+			index, err := Index(token, len(node))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", where(tokens[:len(tokens)-1]), err)
+			}
+			removed = node[index]
+			return append(node[:index], node[index+1:]...), nil
+		}
+		return nil, notContainer(parent, tokens)
+	})
+	return root, removed, err
+}
+
+// Replace sets the value at tokens, which must already exist.
+func Replace(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return edit(doc, tokens, 0, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, missing(tokens)
+			}
+			node[token] = value
+			return node, nil
+		case []any:
+			index, err := Index(token, len(node))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", where(tokens[:len(tokens)-1]), err)
+			}
+			node[index] = value
+			return node, nil
+		}
+		return nil, notContainer(parent, tokens)
+	})
+}
+
+// edit walks to the parent of the last token and lets leaf change it. Each
+// level stores what the level below returned, because appending to a slice can
+// move it.
+func edit(node any, tokens []string, depth int, leaf func(parent any, token string) (any, error)) (any, error) {
+	if depth == len(tokens)-1 {
+		return leaf(node, tokens[depth])
+	}
+	token := tokens[depth]
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("%s has no key %q: %w", where(tokens[:depth]), token, ErrNotFound)
+		}
+		updated, err := edit(child, tokens, depth+1, leaf)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []any:
+		index, err := Index(token, len(n))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where(tokens[:depth]), err)
+		}
+		updated, err := edit(n[index], tokens, depth+1, leaf)
+		if err != nil {
+			return nil, err
+		}
+		n[index] = updated
+		return n, nil
+	}
+	return nil, fmt.Errorf("%s is %s, not an object or array, so it has no %q: %w", where(tokens[:depth]), kind(node), token, ErrNotFound)
+}
+
+func missing(tokens []string) error {
+	last := len(tokens) - 1
+	return fmt.Errorf("%s has no key %q: %w", where(tokens[:last]), tokens[last], ErrNotFound)
+}
+
+func notContainer(parent any, tokens []string) error {
+	last := len(tokens) - 1
+	return fmt.Errorf("%s is %s, not an object or array, so it has no %q: %w", where(tokens[:last]), kind(parent), tokens[last], ErrNotFound)
+}
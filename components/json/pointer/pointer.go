@@ -0,0 +1,203 @@
+// Package pointer resolves RFC 6901 JSON Pointers against decoded JSON — the
+// map[string]any and []any trees every component in this module hands around.
+//
+// It is shared rather than written into each component because the details
+// are easy to get subtly different: the ~0/~1 escaping, what an array index
+// may look like, and what the error says when a path is missing. A flow that
+// uses the same pointer in json_decode and json_patch should get the same
+// answer from both.
+package pointer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is wrapped by every error that means "the path does not exist",
+// as opposed to "the path is malformed".
+var ErrNotFound = errors.New("not found")
+
+var (
+	escaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	unescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// Escape encodes a key as a reference token.
+func Escape(token string) string {
+	return escaper.Replace(token)
+}
+
+// Format joins reference tokens into a pointer. No tokens is the whole
+// document, which RFC 6901 writes as the empty string.
+func Format(tokens []string) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteByte('/')
+		sb.WriteString(Escape(token))
+	}
+	return sb.String()
+}
+
+// Parse splits a pointer into unescaped reference tokens.
+func Parse(s string) ([]string, error) {
+	if s == "" {
+		return []string{}, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("json pointer %q must be empty or start with /", s)
+	}
+	parts := strings.Split(s[1:], "/")
+	for i, part := range parts {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(part, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("json pointer %q: ~ must be followed by 0 or 1", s)
+		}
+		parts[i] = unescaper.Replace(part)
+	}
+	return parts, nil
+}
+
+// Compile accepts either a pointer or a simple JSONPath — whichever a person
+// is likely to paste — and returns reference tokens. A JSONPath starts with $.
+func Compile(expr string) ([]string, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "$") {
+		return ParsePath(expr)
+	}
+	return Parse(expr)
+}
+
+// ParsePath reads the subset of JSONPath that names exactly one location:
+// $, .name, ['name'], ["name"] and [index]. Wildcards, slices and filters can
+// select several values, and a selection that silently became a list would be
+// a different output shape.
+func ParsePath(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", s)
+	}
+	tokens := []string{}
+	rest := s[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" || name == "*" {
+				return nil, fmt.Errorf("json path %q: expected a member name after '.'", s)
+			}
+			tokens = append(tokens, name)
+			rest = rest[end:]
+		case '[':
+			end := closing(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: unclosed '['", s)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				tokens = append(tokens, unquote(inner[1:len(inner)-1]))
+				continue
+			}
+			if _, err := strconv.Atoi(inner); err != nil || strings.HasPrefix(inner, "-") {
+				return nil, fmt.Errorf("json path %q: [%s] selects more than one value; only names and indexes are supported", s, inner)
+			}
+			tokens = append(tokens, inner)
+		default:
+			return nil, fmt.Errorf("json path %q: unexpected %q", s, rest[0])
+		}
+	}
+	return tokens, nil
+}
+
+// closing finds the ] that ends a bracket, skipping one inside a quoted name.
+func closing(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote == 0 && c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func unquote(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// Get returns the value the tokens point at. A miss names the deepest part of
+// the path that did exist, which is usually where the sender's payload and
+// the flow author's expectation part ways.
+func Get(doc any, tokens []string) (any, error) {
+	current := doc
+	for i, token := range tokens {
+		switch node := current.(type) {
+		case map[string]any:
+			next, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%s has no key %q: %w", where(tokens[:i]), token, ErrNotFound)
+			}
+			current = next
+		case []any:
+			index, err := Index(token, len(node))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", where(tokens[:i]), err)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("%s is %s, not an object or array, so it has no %q: %w", where(tokens[:i]), kind(current), token, ErrNotFound)
+		}
+	}
+	return current, nil
+}
+
+// Index reads an array reference token. RFC 6901 allows only plain decimal
+// digits, no leading zero and no sign.
+func Index(token string, length int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index >= length {
+		return 0, fmt.Errorf("index %s is out of range for an array of %d: %w", token, length, ErrNotFound)
+	}
+	return index, nil
+}
+
+func where(tokens []string) string {
+	if len(tokens) == 0 {
+		return "the document"
+	}
+	return Format(tokens)
+}
+
+func kind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
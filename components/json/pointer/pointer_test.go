@@ -0,0 +1,152 @@
+package pointer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// The examples from RFC 6901 section 5.
+func TestRFC6901Examples(t *testing.T) {
+	doc := map[string]any{
+		"foo": []any{"bar", "baz"}, "": float64(0), "a/b": float64(1), "c%d": float64(2),
+		"e^f": float64(3), "g|h": float64(4), "i\\j": float64(5), "k\"l": float64(6), " ": float64(7), "m~n": float64(8),
+	}
+	for ptr, want := range map[string]any{
+		"/foo/0": "bar", "/": float64(0), "/a~1b": float64(1), "/c%d": float64(2), "/e^f": float64(3),
+		"/g|h": float64(4), "/i\\j": float64(5), "/k\"l": float64(6), "/ ": float64(7), "/m~0n": float64(8),
+	} {
+		tokens, err := Parse(ptr)
+		if err != nil {
+			t.Fatalf("%s: %v", ptr, err)
+		}
+		got, err := Get(doc, tokens)
+		if err != nil || got != want {
+			t.Errorf("%s = %v, %v, want %v", ptr, got, err, want)
+		}
+	}
+	whole, _ := Get(doc, mustParse(t, ""))
+	if !reflect.DeepEqual(whole, doc) {
+		t.Error("the empty pointer must be the whole document")
+	}
+}
+
+func mustParse(t *testing.T, s string) []string {
+	t.Helper()
+	tokens, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestFormatEscapesAndRoundTrips(t *testing.T) {
+	tokens := []string{"a/b", "m~n", "0"}
+	if got := Format(tokens); got != "/a~1b/m~0n/0" {
+		t.Fatalf("format = %q", got)
+	}
+	if got := mustParse(t, Format(tokens)); !reflect.DeepEqual(got, tokens) {
+		t.Fatalf("round trip = %q", got)
+	}
+}
+
+func TestParseRejectsMalformedPointers(t *testing.T) {
+	for _, bad := range []string{"foo", "/a~2", "/a~"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+func TestMissingPathsWrapNotFound(t *testing.T) {
+	doc := map[string]any{"data": map[string]any{"items": []any{"x"}}, "n": float64(1)}
+	for _, ptr := range []string{"/data/item", "/data/items/1", "/n/x"} {
+		_, err := Get(doc, mustParse(t, ptr))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: err = %v, want ErrNotFound", ptr, err)
+		}
+	}
+	_, err := Get(doc, mustParse(t, "/data/item"))
+	if err.Error() != `/data has no key "item": not found` {
+		t.Errorf("err = %q, want the deepest existing path named", err)
+	}
+}
+
+func TestArrayIndexesAreStrict(t *testing.T) {
+	doc := []any{"a", "b"}
+	for _, bad := range []string{"01", "-1", "+1", "-", "x"} {
+		if _, err := Get(doc, []string{bad}); err == nil {
+			t.Errorf("index %q was accepted", bad)
+		}
+	}
+}
+
+func TestCompileTakesPointersAndPaths(t *testing.T) {
+	for expr, want := range map[string][]string{
+		"/data/items":          {"data", "items"},
+		"$.data.items[0].name": {"data", "items", "0", "name"},
+		"$['a.b'][\"c]d\"]":    {"a.b", "c]d"},
+		"$":                    {},
+		" $.x ":                {"x"},
+		"$.data['it\\'s'][2]":  {"data", "it's", "2"},
+	} {
+		got, err := Compile(expr)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q = %q, %v, want %q", expr, got, err, want)
+		}
+	}
+}
+
+// A path that can match several values would change the output from a value
+// to a list, so it is refused rather than half-supported.
+func TestCompileRefusesMultiValuePaths(t *testing.T) {
+	for _, bad := range []string{"$.items[*]", "$.items.*", "$..name", "$.items[0:2]", "$.items[-1]"} {
+		if _, err := Compile(bad); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+func TestAddInsertsAndAppends(t *testing.T) {
+	doc := map[string]any{"list": []any{"a", "c"}}
+	root, err := Add(doc, mustParse(t, "/list/1"), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, err = Add(root, mustParse(t, "/list/-"), "d"); err != nil {
+		t.Fatal(err)
+	}
+	if root, err = Add(root, mustParse(t, "/list/4"), "e"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"list": []any{"a", "b", "c", "d", "e"}}
+	if !reflect.DeepEqual(root, want) {
+		t.Fatalf("got %v", root)
+	}
+	if _, err := Add(root, mustParse(t, "/list/9"), "x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound past the end", err)
+	}
+	if _, err := Add(root, mustParse(t, "/missing/x"), "x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound for a missing parent", err)
+	}
+}
+
+func TestRemoveAndReplaceNeedTheTarget(t *testing.T) {
+	doc := map[string]any{"a": float64(1), "list": []any{"x", "y"}}
+	root, removed, err := Remove(doc, mustParse(t, "/list/0"))
+	if err != nil || removed != "x" {
+		t.Fatalf("removed %v, %v", removed, err)
+	}
+	if root, err = Replace(root, mustParse(t, "/a"), "z"); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]any{"a": "z", "list": []any{"y"}}; !reflect.DeepEqual(root, want) {
+		t.Fatalf("got %v", root)
+	}
+	if _, err := Replace(root, mustParse(t, "/b"), 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("replace of a missing key: err = %v", err)
+	}
+	if _, _, err := Remove(root, mustParse(t, "/list/1")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("remove past the end: err = %v", err)
+	}
+}
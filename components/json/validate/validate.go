@@ -0,0 +1,310 @@
+// Package validate checks documents against JSON Schema 2020-12.
+//
+// json_decode's decoded setting describes a shape by example, which is enough
+// to wire a flow but not to hold a partner to a contract: it has no formats,
+// patterns, oneOf or $ref. Partners publish real schemas, often several that
+// reference each other, and this validates against them as published.
+package validate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "json_schema_validate"
+
+	RequestPort = "request"
+	ValidPort   = "valid"
+	InvalidPort = "invalid"
+	ErrorPort   = "error"
+
+	defaultMaxErrors = 100
+
+	// baseURL is the main schema's address when it has no $id of its own, so
+	// relative references in it still resolve against something.
+	baseURL = "https://schema.invalid/schema.json"
+)
+
+type Context any
+
+type Document any
+
+type Request struct {
+	Context  Context  `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the result"`
+	Document Document `json:"document" required:"true" configurable:"true" title:"Document" description:"The document to validate."`
+}
+
+// Valid is emitted when the document satisfies the schema.
+type Valid struct {
+	Context  Context  `json:"context,omitempty" title:"Context"`
+	Document Document `json:"document" configurable:"true" title:"Document"`
+}
+
+// Invalid is emitted when it does not, with every reason found.
+type Invalid struct {
+	Context   Context     `json:"context,omitempty" title:"Context"`
+	Document  Document    `json:"document" configurable:"true" title:"Document"`
+	Errors    []Violation `json:"errors" title:"Errors"`
+	Truncated bool        `json:"truncated" title:"Truncated" description:"True when there were more errors than maxErrors and the rest were left out."`
+}
+
+// Violation is one way the document fails the schema.
+type Violation struct {
+	InstancePath   string `json:"instancePath" title:"Instance Path" description:"JSON Pointer to the value that failed, such as /items/2/email. Empty is the whole document."`
+	SchemaPath     string `json:"schemaPath" title:"Schema Path" description:"JSON Pointer to the keyword that failed, as reached from the main schema, through any $ref."`
+	SchemaLocation string `json:"schemaLocation" title:"Schema Location" description:"Absolute URI of that keyword, naming the schema it is in by $id."`
+	Message        string `json:"message" title:"Message"`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+type Settings struct {
+	Schema     string   `json:"schema" required:"true" format:"textarea" title:"Schema" description:"The JSON Schema documents are checked against. Draft 2020-12 unless its $schema says otherwise."`
+	References []string `json:"references" format:"textarea" title:"Referenced Schemas" description:"Further schemas the main one refers to with $ref. Each must have an $id, and is found by it; nothing is fetched over the network."`
+
+	// Phrased as the negative because partners who put a format in a schema
+	// mean it, while 2020-12 itself treats format as a note.
+	IgnoreFormat bool `json:"ignoreFormat" title:"Ignore Format" description:"Off (default): format keywords such as email, date-time and uuid are checked. On: they are treated as annotations only, as draft 2020-12 does unless told otherwise."`
+	MaxErrors    int  `json:"maxErrors" default:"100" title:"Max Errors" description:"Most errors reported for one document."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run. An invalid document is not an error — it goes to the invalid port; this is for a schema that does not compile or a document that cannot be read."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+
+	// schema is compiled once per settings change rather than per message;
+	// compileErr is reported on each message until the settings are fixed.
+	schema     *jsonschema.Schema
+	compileErr error
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "JSON Schema Validate",
+		Info: "Validates a document against a JSON Schema (draft 2020-12, with formats, patterns, oneOf and $ref). " +
+			"Valid documents go out on the valid port unchanged; invalid ones go out on the invalid port with every " +
+			"error found, each naming the value that failed by JSON Pointer, the schema keyword that failed it, and " +
+			"why. Put a partner's published schemas in references when the main one refers to them by $id — " +
+			"nothing is fetched over the network. " +
+			"The error port is for a schema that does not compile, not for an invalid document.",
+		Tags: []string{"json", "schema", "validation"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	c.schema, c.compileErr = compile(in)
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+	in, ok := msg.(Request)
+	if !ok {
+		return module.Fail(fmt.Errorf("invalid message"))
+	}
+
+	if c.compileErr != nil {
+		return c.handleError(ctx, handler, in.Context, c.compileErr)
+	}
+	if c.schema == nil {
+		return c.handleError(ctx, handler, in.Context, fmt.Errorf("no schema configured"))
+	}
+
+	doc, err := normalize(in.Document)
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, fmt.Errorf("document: %w", err))
+	}
+
+	err = c.schema.Validate(doc)
+	if err == nil {
+		return handler(ctx, ValidPort, Valid{Context: in.Context, Document: in.Document})
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return c.handleError(ctx, handler, in.Context, err)
+	}
+
+	maxErrors := c.settings.MaxErrors
+	if maxErrors <= 0 {
+		maxErrors = defaultMaxErrors
+	}
+	violations := leaves(nil, ve)
+	out := Invalid{Context: in.Context, Document: in.Document, Errors: violations}
+	if len(violations) > maxErrors {
+		out.Errors, out.Truncated = violations[:maxErrors], true
+	}
+	return handler(ctx, InvalidPort, out)
+}
+
+// compile loads the main schema and its references. A reference to anything
+// not configured is an error rather than a download: a flow that quietly
+// fetched schemas at run time would fail whenever the partner's site did.
+func compile(s Settings) (*jsonschema.Schema, error) {
+	if strings.TrimSpace(s.Schema) == "" {
+		return nil, nil
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = !s.IgnoreFormat
+	c.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("%s is not the schema or one of its references; add it to references", url)
+	}
+
+	for i, ref := range s.References {
+		id, err := idOf(ref)
+		if err != nil {
+			return nil, fmt.Errorf("reference %d: %w", i, err)
+		}
+		if id == "" {
+			return nil, fmt.Errorf("reference %d has no $id, so nothing can refer to it", i)
+		}
+		if err := c.AddResource(id, strings.NewReader(ref)); err != nil {
+			return nil, fmt.Errorf("reference %d: %w", i, err)
+		}
+	}
+
+	main, err := idOf(s.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	if main == "" {
+		main = baseURL
+	}
+	if err := c.AddResource(main, strings.NewReader(s.Schema)); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return c.Compile(main)
+}
+
+// idOf reads a schema's $id. A schema that is not an object — true, false —
+// has none.
+func idOf(schema string) (string, error) {
+	var v any
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		return "", fmt.Errorf("not valid JSON: %w", err)
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return "", nil
+	}
+	id, _ := obj["$id"].(string)
+	return strings.TrimSuffix(id, "#"), nil
+}
+
+// leaves flattens the error tree to the errors that say what is wrong. The
+// inner nodes only say which subschema their children came from, which the
+// schema path already does.
+func leaves(out []Violation, ve *jsonschema.ValidationError) []Violation {
+	if len(ve.Causes) == 0 {
+		return append(out, Violation{
+			InstancePath:   ve.InstanceLocation,
+			SchemaPath:     ve.KeywordLocation,
+			SchemaLocation: ve.AbsoluteKeywordLocation,
+			Message:        ve.Message,
+		})
+	}
+	for _, cause := range ve.Causes {
+		out = leaves(out, cause)
+	}
+	return out
+}
+
+// normalize gives the validator the types it knows — encoding/json's, with
+// numbers kept as json.Number so an integer keyword does not see a float.
+func normalize(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out any
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+func (c *Component) Ports() []module.Port {
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: Request{},
+			Position:      module.Left,
+		},
+		{
+			Name:          ValidPort,
+			Label:         "Valid",
+			Source:        true,
+			Configuration: Valid{},
+			Position:      module.Right,
+		},
+		{
+			Name:          InvalidPort,
+			Label:         "Invalid",
+			Source:        true,
+			Configuration: Invalid{},
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
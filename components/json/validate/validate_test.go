@@ -0,0 +1,166 @@
+package validate
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+const person = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["name", "email"],
+	"properties": {
+		"name": {"type": "string"},
+		"email": {"type": "string", "format": "email"},
+		"age": {"type": "integer"},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}}
+	}
+}`
+
+func invalid(t *testing.T, doc any, settings Settings) Invalid {
+	t.Helper()
+	port, msg, err := run(t, Request{Document: doc}, settings)
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if port != InvalidPort {
+		t.Fatalf("emitted on %q, want %q", port, InvalidPort)
+	}
+	return msg.(Invalid)
+}
+
+func TestValidDocumentPassesThrough(t *testing.T) {
+	doc := map[string]any{"name": "Ann", "email": "ann@example.com", "age": 41, "tags": []any{"vip"}}
+	port, msg, err := run(t, Request{Context: "c", Document: doc}, Settings{Schema: person})
+	if err != nil || port != ValidPort {
+		t.Fatalf("port = %q, err = %v", port, err)
+	}
+	if out := msg.(Valid); out.Context != "c" || out.Document.(map[string]any)["name"] != "Ann" {
+		t.Fatalf("out = %+v", out)
+	}
+}
+
+// Every error is reported, each pointing at the value and the keyword, so the
+// sender can be told exactly what to fix.
+func TestErrorsNameInstanceAndSchemaPaths(t *testing.T) {
+	out := invalid(t, map[string]any{"name": 7, "age": 1.5, "tags": []any{"ok", "Not OK"}}, Settings{Schema: person})
+	want := map[string]string{
+		"":        "/required",
+		"/name":   "/properties/name/type",
+		"/age":    "/properties/age/type",
+		"/tags/1": "/properties/tags/items/pattern",
+	}
+	if len(out.Errors) != len(want) {
+		t.Fatalf("errors = %+v, want %d", out.Errors, len(want))
+	}
+	for _, v := range out.Errors {
+		if want[v.InstancePath] != v.SchemaPath {
+			t.Errorf("%q failed %q, want %q", v.InstancePath, v.SchemaPath, want[v.InstancePath])
+		}
+		if v.Message == "" || !strings.HasSuffix(v.SchemaLocation, v.SchemaPath) {
+			t.Errorf("violation %+v", v)
+		}
+	}
+}
+
+func TestFormatIsAssertedUnlessIgnored(t *testing.T) {
+	doc := map[string]any{"name": "Ann", "email": "not an address"}
+	out := invalid(t, doc, Settings{Schema: person})
+	if len(out.Errors) != 1 || out.Errors[0].SchemaPath != "/properties/email/format" {
+		t.Fatalf("errors = %+v", out.Errors)
+	}
+	if port, _, _ := run(t, Request{Document: doc}, Settings{Schema: person, IgnoreFormat: true}); port != ValidPort {
+		t.Fatalf("with ignoreFormat: emitted on %q", port)
+	}
+}
+
+// A partner's schemas refer to each other by $id; they resolve from settings,
+// with nothing fetched.
+func TestReferencesResolveByID(t *testing.T) {
+	settings := Settings{
+		Schema: `{"$id":"https://partner.example/order.json","type":"object","properties":{"customer":{"$ref":"customer.json"}}}`,
+		References: []string{
+			`{"$id":"https://partner.example/customer.json","type":"object","required":["id"],"properties":{"id":{"type":"string"}}}`,
+		},
+	}
+	if port, _, err := run(t, Request{Document: map[string]any{"customer": map[string]any{"id": "c1"}}}, settings); err != nil || port != ValidPort {
+		t.Fatalf("port = %q, err = %v", port, err)
+	}
+	out := invalid(t, map[string]any{"customer": map[string]any{"id": 1}}, settings)
+	if len(out.Errors) != 1 || out.Errors[0].InstancePath != "/customer/id" {
+		t.Fatalf("errors = %+v", out.Errors)
+	}
+	if !strings.HasPrefix(out.Errors[0].SchemaLocation, "https://partner.example/customer.json#") {
+		t.Errorf("schema location = %q, want the referenced schema named", out.Errors[0].SchemaLocation)
+	}
+}
+
+func TestUnknownReferenceIsNotFetched(t *testing.T) {
+	settings := Settings{
+		Schema:          `{"properties":{"a":{"$ref":"https://partner.example/missing.json"}}}`,
+		EnableErrorPort: true,
+	}
+	port, msg, err := run(t, Request{Document: map[string]any{}}, settings)
+	if err != nil || port != ErrorPort {
+		t.Fatalf("port = %q, err = %v", port, err)
+	}
+	if !strings.Contains(msg.(Error).Error, "references") {
+		t.Errorf("error = %q, want a hint to add it to references", msg.(Error).Error)
+	}
+}
+
+func TestReferenceWithoutIDIsRefused(t *testing.T) {
+	port, msg, _ := run(t, Request{Document: map[string]any{}}, Settings{Schema: person, References: []string{`{"type":"string"}`}, EnableErrorPort: true})
+	if port != ErrorPort || !strings.Contains(msg.(Error).Error, "no $id") {
+		t.Fatalf("port = %q, msg = %v", port, msg)
+	}
+}
+
+func TestMaxErrorsTruncates(t *testing.T) {
+	tags := make([]any, 10)
+	for i := range tags {
+		tags[i] = "BAD"
+	}
+	out := invalid(t, map[string]any{"name": "a", "email": "a@b.c", "tags": tags}, Settings{Schema: person, MaxErrors: 3})
+	if len(out.Errors) != 3 || !out.Truncated {
+		t.Fatalf("errors = %d, truncated = %v", len(out.Errors), out.Truncated)
+	}
+}
+
+func TestOneOf(t *testing.T) {
+	settings := Settings{Schema: `{"oneOf":[{"type":"string"},{"type":"integer"}]}`}
+	if port, _, _ := run(t, Request{Document: "x"}, settings); port != ValidPort {
+		t.Fatalf("string: emitted on %q", port)
+	}
+	if out := invalid(t, true, settings); out.Errors[0].SchemaPath == "" {
+		t.Fatalf("errors = %+v", out.Errors)
+	}
+}
+
+func TestMissingSchemaIsAnError(t *testing.T) {
+	if _, _, err := run(t, Request{Document: 1}, Settings{}); err == nil {
+		t.Fatal("validated with no schema")
+	}
+}
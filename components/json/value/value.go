@@ -0,0 +1,94 @@
+// Package value holds what the JSON components need to agree on about decoded
+// values: what shape they have, and when two of them are the same.
+//
+// Every component that compares documents — json_patch's test, json_merge's
+// merge-by-key, json_diff — has to decide whether 1 and 1.0 are equal. Keeping
+// the answer here means they all decide it the same way.
+package value
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/goccy/go-json"
+)
+
+// Normalize turns whatever the flow handed over into a fresh tree of
+// map[string]any, []any, string, bool, nil and json.Number. The round trip is
+// also a deep copy, so the result can be edited in place without touching the
+// caller's value, and keeping numbers as json.Number means an id past 2^53
+// comes out with the digits it went in with.
+func Normalize(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out any
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Equal compares two normalized values as JSON does: 1, 1.0 and 1e0 are the
+// same number, and object key order does not matter.
+func Equal(a, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, xv := range x {
+			yv, ok := y[key]
+			if !ok || !Equal(xv, yv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		return SameNumber(x, y)
+	}
+	return a == b
+}
+
+// SameNumber reports whether two number literals have the same value, exactly:
+// no float rounding makes two different large ids look alike.
+func SameNumber(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	x, xok := new(big.Rat).SetString(string(a))
+	y, yok := new(big.Rat).SetString(string(b))
+	return xok && yok && x.Cmp(y) == 0
+}
+
+// Show renders v for an error message, cut short so a large document does not
+// bury the message it is part of.
+func Show(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "?"
+	}
+	if len(data) > 80 {
+		return string(data[:77]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,39 @@
+package value
+
+import (
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestEqualComparesNumbersExactly(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"1", "1.0", true},
+		{"1e2", "100", true},
+		{"-0", "0", true},
+		{"9007199254740993", "9007199254740992", false},
+		{"0.1", "0.10000000000000001", false},
+	} {
+		if got := Equal(json.Number(tc.a), json.Number(tc.b)); got != tc.want {
+			t.Errorf("Equal(%s, %s) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeCopies(t *testing.T) {
+	in := map[string]any{"a": []any{map[string]any{"b": 1}}}
+	out, err := Normalize(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(out, map[string]any{"a": []any{map[string]any{"b": json.Number("1")}}}) {
+		t.Fatalf("out = %v", out)
+	}
+	out.(map[string]any)["a"].([]any)[0].(map[string]any)["b"] = 2
+	if in["a"].([]any)[0].(map[string]any)["b"] != 1 {
+		t.Fatal("editing the normalized copy changed the original")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/swaggest/jsonschema-go"
+	"github.com/tiny-systems/encoding-module/components/pemkey"
 	"github.com/tiny-systems/module/api/v1alpha1"
 	"github.com/tiny-systems/module/module"
 	"github.com/tiny-systems/module/registry"
@@ -116,13 +117,13 @@ func (h *Component) Handle(ctx context.Context, handler module.Handler, port str
 	switch in.SigningMethod.Value {
 	case "ES256":
 		method = jwt.SigningMethodES256
-		key, err = jwt.ParseECPrivateKeyFromPEM([]byte(in.Key))
+		key, err = pemkey.ECPrivateKey([]byte(in.Key))
 	case "ES384":
 		method = jwt.SigningMethodES384
-		key, err = jwt.ParseECPrivateKeyFromPEM([]byte(in.Key))
+		key, err = pemkey.ECPrivateKey([]byte(in.Key))
 	case "ES512":
 		method = jwt.SigningMethodES512
-		key, err = jwt.ParseECPrivateKeyFromPEM([]byte(in.Key))
+		key, err = pemkey.ECPrivateKey([]byte(in.Key))
 	case "HS256":
 		method = jwt.SigningMethodHS256
 		key = []byte(in.Key)
@@ -134,18 +135,23 @@ func (h *Component) Handle(ctx context.Context, handler module.Handler, port str
 		key = []byte(in.Key)
 	case "RS256":
 		method = jwt.SigningMethodRS256
-		key, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(in.Key))
+		key, err = pemkey.RSAPrivateKey([]byte(in.Key))
 	case "RS384":
 		method = jwt.SigningMethodRS384
-		key, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(in.Key))
+		key, err = pemkey.RSAPrivateKey([]byte(in.Key))
 	case "RS512":
 		method = jwt.SigningMethodRS512
-		key, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(in.Key))
+		key, err = pemkey.RSAPrivateKey([]byte(in.Key))
 	case "None":
 		method = jwt.SigningMethodNone
 	}
 
-	token, err := jwt.NewWithClaims(method, in.Claims).SignedString(key)
+	// A key that did not parse is reported as such, not as whatever signing
+	// with no key fails with.
+	var token string
+	if err == nil {
+		token, err = jwt.NewWithClaims(method, in.Claims).SignedString(key)
+	}
 	if err != nil {
 		if !h.settings.EnableErrorPort {
 			return module.Fail(err)
@@ -7,6 +7,7 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/swaggest/jsonschema-go"
+	"github.com/tiny-systems/encoding-module/components/pemkey"
 	"github.com/tiny-systems/module/api/v1alpha1"
 	"github.com/tiny-systems/module/module"
 	"github.com/tiny-systems/module/registry"
@@ -161,14 +162,14 @@ func parseToken(tokenString, key, method string) (jwt.MapClaims, error) {
 func keyFuncForMethod(method, key string) (jwt.Keyfunc, error) {
 	switch method {
 	case "ES256", "ES384", "ES512":
-		pubKey, err := jwt.ParseECPublicKeyFromPEM([]byte(key))
+		pubKey, err := pemkey.ECPublicKey([]byte(key))
 		if err != nil {
 			return nil, fmt.Errorf("parse EC public key: %w", err)
 		}
 		return func(*jwt.Token) (interface{}, error) { return pubKey, nil }, nil
 
 	case "RS256", "RS384", "RS512":
-		pubKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(key))
+		pubKey, err := pemkey.RSAPublicKey([]byte(key))
 		if err != nil {
 			return nil, fmt.Errorf("parse RSA public key: %w", err)
 		}
@@ -0,0 +1,158 @@
+// Package pemkey reads the keys and certificates the signing components are
+// given.
+//
+// Keys reach a flow pasted from wherever they were issued: PKCS #1 or
+// PKCS #8, SEC 1 for EC, a bare public key or a whole certificate, and
+// certificates copied out of SAML metadata without their PEM armour. Every
+// component that signs or verifies accepts the same forms, and says which
+// one it expected when it gets something else.
+package pemkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+func block(data []byte) (*pem.Block, error) {
+	b, _ := pem.Decode(data)
+	if b == nil {
+		return nil, fmt.Errorf("no PEM block found: include the -----BEGIN ...----- and -----END ...----- lines")
+	}
+	return b, nil
+}
+
+// PrivateKey reads an RSA or EC private key in PKCS #1, SEC 1 or PKCS #8.
+func PrivateKey(data []byte) (crypto.Signer, error) {
+	b, err := block(data)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(b.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(b.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s block is not a PKCS #1, SEC 1 or PKCS #8 private key", b.Type)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T: use an RSA or EC key", key)
+}
+
+// RSAPrivateKey reads a private key that must be RSA.
+func RSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	key, err := PrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA private key")
+	}
+	return rsaKey, nil
+}
+
+// ECPrivateKey reads a private key that must be EC.
+func ECPrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	key, err := PrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an EC private key")
+	}
+	return ecKey, nil
+}
+
+// Certificate reads an X.509 certificate, PEM encoded or as the bare base64
+// of its DER, as XML signatures and SAML metadata carry it.
+func Certificate(data []byte) (*x509.Certificate, error) {
+	der, err := certificateDER(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	return cert, nil
+}
+
+func certificateDER(data []byte) ([]byte, error) {
+	if b, _ := pem.Decode(data); b != nil {
+		if b.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s block is not a certificate", b.Type)
+		}
+		return b.Bytes, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("not a PEM or base64 encoded certificate")
+	}
+	return der, nil
+}
+
+// PublicKey reads an RSA or EC public key: PKIX, PKCS #1, or the key of a
+// certificate.
+func PublicKey(data []byte) (crypto.PublicKey, error) {
+	var key crypto.PublicKey
+	if b, _ := pem.Decode(data); b != nil && b.Type != "CERTIFICATE" {
+		var err error
+		if key, err = x509.ParsePKIXPublicKey(b.Bytes); err != nil {
+			if key, err = x509.ParsePKCS1PublicKey(b.Bytes); err != nil {
+				return nil, fmt.Errorf("%s block is not a PKIX or PKCS #1 public key", b.Type)
+			}
+		}
+	} else {
+		cert, err := Certificate(data)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T: use an RSA or EC key", key)
+}
+
+// RSAPublicKey reads a public key that must be RSA.
+func RSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	key, err := PublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// ECPublicKey reads a public key that must be EC.
+func ECPublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	key, err := PublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an EC public key")
+	}
+	return ecKey, nil
+}
@@ -0,0 +1,174 @@
+// Package decode turns an XML string into data the rest of the flow can read.
+//
+// The module could write XML but not read it, so every SOAP response, bank
+// statement and legacy feed went through js_eval and a hand-rolled parser.
+// This decodes into the same generic tree json_decode produces, under one
+// documented convention — @attributes, #text, repeated elements as arrays — so
+// the rest of the flow reads it with the expressions it already uses.
+package decode
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "xml_decode"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+)
+
+type Context any
+
+// Decoded is the decoded document. Like json_decode's, it carries no shape of
+// its own, so the decoded setting is where the author says what it holds.
+type Decoded any
+
+type Request struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with decoded message"`
+	Encoded string  `json:"encoded" required:"true" format:"textarea" title:"XML" description:"The XML document to decode."`
+}
+
+type Response struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context"`
+	Decoded Decoded `json:"decoded" configurable:"true" title:"Decoded" description:"The document as {rootName: value}."`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+type Settings struct {
+	Names string `json:"names" default:"prefixed" enum:"prefixed,local,expanded" enumTitles:"As written (soap:Body)|Local name (Body)|Namespace URI ({http://…}Body)" title:"Element Names" description:"How namespaced elements and attributes are keyed. As written keeps prefixes and the xmlns declarations, so the document can be written back with them. Local name drops both, for documents whose senders choose different prefixes. Namespace URI names each unambiguously, whatever the prefix."`
+
+	// Every decoder that turns repeated elements into arrays has this problem:
+	// the shape of one element depends on how many siblings it happens to
+	// have. Naming the list elements is the only way to make it stable.
+	ForceArray []string `json:"forceArray" title:"Always Arrays" description:"Elements always decoded as an array, even when there is only one, such as item or Line. Without this, one <item> decodes to an object and two to an array, and {{$.decoded.order.item[0]}} breaks on the first single-item order. Matches the element's key or its local name."`
+
+	KeepWhitespace bool `json:"keepWhitespace" title:"Keep Whitespace" description:"Off (default): text is trimmed. On: text is kept exactly as written, for content where leading spaces or line breaks mean something. Indentation between elements is dropped either way."`
+
+	// Off by default: accepting a malformed document means decoding something
+	// other than what the sender wrote, and that should be a choice.
+	Lenient bool `json:"lenient" title:"Lenient" description:"Accept HTML entities such as &nbsp;, attributes without values and mismatched end tags, as hand-written files and some feeds contain. Off (default): those are errors."`
+
+	Decoded Decoded `json:"decoded" configurable:"true" title:"Decoded shape" description:"An example of the decoded document, such as {\"order\":{\"@id\":\"\",\"item\":[{\"sku\":\"\"}]}}. An XML string has no shape, so without this every downstream edge is unverifiable: {{$.decoded.order.item}} is accepted when the flow is built and resolves to null at runtime."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "XML Decoder",
+		Info: "Parses an XML string into data the rest of the flow can read, as {rootName: value}. " +
+			"An element with only text becomes that text. Otherwise it becomes an object: attributes are keys starting " +
+			"with @, child elements are keys by name, and text alongside them is under #text. " +
+			"<order id=\"7\"><item>a</item><item>b</item></order> decodes to " +
+			"{\"order\":{\"@id\":\"7\",\"item\":[\"a\",\"b\"]}}. Every value is a string. " +
+			"A repeated element becomes an array — list it in forceArray so it is one even when the document has a " +
+			"single element, or expressions that index it break on that document. " +
+			"SET THE `decoded` SETTING to an example of the result, for the same reason json_decode needs one. " +
+			"Set names to local when senders use different namespace prefixes for the same thing. " +
+			"Nothing in a DOCTYPE is expanded or fetched.",
+		Tags: []string{"xml"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+	in, ok := msg.(Request)
+	if !ok {
+		return module.Fail(fmt.Errorf("invalid message"))
+	}
+
+	doc, err := tree.Parse([]byte(in.Encoded), tree.ParseOptions{Lenient: c.settings.Lenient})
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, err)
+	}
+	return handler(ctx, ResponsePort, Response{
+		Context: in.Context,
+		Decoded: tree.ToValue(doc.Root(), tree.ValueOptions{
+			Names:          c.settings.Names,
+			ForceArray:     c.settings.ForceArray,
+			KeepWhitespace: c.settings.KeepWhitespace,
+		}),
+	})
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+func (c *Component) Ports() []module.Port {
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: Request{},
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: Response{Decoded: c.settings.Decoded},
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
@@ -0,0 +1,95 @@
+package decode
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func decode(t *testing.T, encoded string, settings Settings) any {
+	t.Helper()
+	port, msg, err := run(t, Request{Context: "ctx", Encoded: encoded}, settings)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	out := msg.(Response)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out.Decoded
+}
+
+// The example from the component's info, which users will copy.
+func TestDecode(t *testing.T) {
+	got := decode(t, `<order id="7"><item>a</item><item>b</item></order>`, Settings{})
+	want := map[string]any{"order": map[string]any{"@id": "7", "item": []any{"a", "b"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v", got)
+	}
+}
+
+func TestSOAPResponseWithLocalNames(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ns1="urn:rates">
+  <soapenv:Body>
+    <ns1:GetRateResponse>
+      <ns1:Rate currency="EUR">1.0842</ns1:Rate>
+    </ns1:GetRateResponse>
+  </soapenv:Body>
+</soapenv:Envelope>`
+	got := decode(t, doc, Settings{Names: "local", ForceArray: []string{"Rate"}})
+	want := map[string]any{"Envelope": map[string]any{"Body": map[string]any{"GetRateResponse": map[string]any{
+		"Rate": []any{map[string]any{"@currency": "EUR", "#text": "1.0842"}},
+	}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v", got)
+	}
+}
+
+func TestMalformedGoesToErrorPort(t *testing.T) {
+	port, msg, err := run(t, Request{Context: "ctx", Encoded: `<a><b></a>`}, Settings{EnableErrorPort: true})
+	if err != nil || port != ErrorPort || msg.(Error).Context != "ctx" {
+		t.Fatalf("port = %q, msg = %v, err = %v", port, msg, err)
+	}
+	if _, _, err := run(t, Request{Encoded: `<a><b></a>`}, Settings{}); err == nil {
+		t.Fatal("malformed document decoded without the error port")
+	}
+}
+
+func TestLenientAcceptsHTMLEntities(t *testing.T) {
+	if got := decode(t, `<title>Caf&eacute;&nbsp;menu</title>`, Settings{Lenient: true}); !reflect.DeepEqual(got, map[string]any{"title": "Caf\u00e9\u00a0menu"}) {
+		t.Fatalf("got %#v", got)
+	}
+}
+
+func TestDecodedShapeIsTheResponseShape(t *testing.T) {
+	shape := map[string]any{"order": map[string]any{"@id": ""}}
+	c := &Component{}
+	_ = c.OnSettings(context.Background(), Settings{Decoded: shape})
+	for _, p := range c.Ports() {
+		if p.Name == ResponsePort && !reflect.DeepEqual(p.Configuration.(Response).Decoded, Decoded(shape)) {
+			t.Fatalf("response configuration = %#v", p.Configuration)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package dsig signs XML documents and verifies their signatures.
+//
+// SAML assertions, e-invoices and some bank APIs carry enveloped XML
+// signatures: a ds:Signature inside the element it signs, over a canonical
+// form of that element. None of it can be done with templates — the digest
+// depends on every namespace declaration and attribute order — so flows had
+// no way to take part. Sign and verify both work on the XML tree, through
+// Exclusive XML Canonicalization, and verification reports exactly which
+// elements the signatures cover.
+package dsig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "xml_dsig"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+
+	ModeSign   = "sign"
+	ModeVerify = "verify"
+)
+
+type Context any
+
+// Request is what sign mode takes.
+type Request struct {
+	Context     Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the signed document"`
+	Document    string  `json:"document" required:"true" format:"textarea" title:"Document" description:"The XML document to sign."`
+	ID          string  `json:"id,omitempty" configurable:"true" title:"Reference ID" description:"The ID of the element to sign, such as a SAML assertion's ID attribute; ID, Id, id, wsu:Id and xml:id attributes are looked at. The signature goes inside that element. Empty signs the whole document, with the signature in its root."`
+	Key         string  `json:"key" required:"true" format:"textarea" title:"Private Key" description:"PEM formatted RSA or EC private key. RSA keys sign with rsa-sha256, EC keys with ecdsa-sha256."`
+	Certificate string  `json:"certificate,omitempty" format:"textarea" title:"Certificate" description:"PEM formatted certificate for the key, included in the signature's KeyInfo so the receiver can tell who signed."`
+}
+
+// Response is what sign mode emits.
+type Response struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Signed  string  `json:"signed" title:"Signed Document" description:"The document with the signature in it. Send it as it is: changing even its whitespace breaks the signature."`
+}
+
+// VerifyRequest is what verify mode takes.
+type VerifyRequest struct {
+	Context     Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the result"`
+	Document    string  `json:"document" required:"true" format:"textarea" title:"Document" description:"The signed XML document, exactly as received."`
+	Certificate string  `json:"certificate" required:"true" format:"textarea" title:"Certificate" description:"The signer's certificate or public key: PEM, or the base64 of a certificate as SAML metadata carries it. The key in the document itself is never used."`
+}
+
+// VerifyResponse is what verify mode emits.
+type VerifyResponse struct {
+	Context    Context     `json:"context,omitempty" title:"Context"`
+	Valid      bool        `json:"valid" title:"Valid" description:"Every signature in the document is valid, and so is every reference in them."`
+	Signatures []Signature `json:"signatures" title:"Signatures" description:"Each ds:Signature in the document, in document order."`
+}
+
+// Signature is the result of checking one ds:Signature.
+type Signature struct {
+	Valid      bool        `json:"valid" title:"Valid"`
+	Error      string      `json:"error,omitempty" title:"Error" description:"Why the signature is not valid."`
+	References []Reference `json:"references" title:"References"`
+}
+
+// Reference is the result of checking one reference of a signature.
+type Reference struct {
+	URI     string `json:"uri" title:"URI" description:"#ID, or empty for the whole document."`
+	Element string `json:"element,omitempty" title:"Element" description:"The name of the element referred to."`
+	Valid   bool   `json:"valid" title:"Valid" description:"The element is unchanged since it was signed. It is only trustworthy when the signature is valid too."`
+	Error   string `json:"error,omitempty" title:"Error"`
+	Signed  string `json:"signed,omitempty" title:"Signed XML" description:"What the reference covers, in the canonical form that was digested. Read signed data from here: anything in the document outside it is not protected by the signature."`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+type Settings struct {
+	Mode         string `json:"mode" default:"sign" enum:"sign,verify" enumTitles:"Sign|Verify" title:"Mode" description:"Sign adds an enveloped signature to a document. Verify checks the signatures in one against a certificate."`
+	After        string `json:"after,omitempty" title:"Insert After" description:"Sign: the signed element's child the signature goes after, by local name, such as Issuer for a SAML assertion. Empty puts the signature last."`
+	AllowInvalid bool   `json:"allowInvalid" title:"Allow Invalid" description:"Verify: emit documents whose signatures do not validate on the response port, with valid false, instead of treating them as errors."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "XML Signature",
+		Info: "Sign mode adds an enveloped XML signature to a document: RSA or ECDSA with SHA-256 over the Exclusive XML " +
+			"Canonicalization of the element whose ID is given, or of the whole document. The signature goes inside the " +
+			"signed element, after the child named in Insert After if set. " +
+			"Verify mode checks every ds:Signature in a document against the certificate given — never against a key " +
+			"the document carries — and reports each signature's references: which element each covers, whether it is " +
+			"unchanged, and its signed XML. Only signed XML is protected; read data from a reference's signed output, " +
+			"not from the rest of the document. A document whose signatures do not all validate is an error unless " +
+			"Allow Invalid is set.",
+		Tags: []string{"xml", "signature", "saml"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+
+	switch in := msg.(type) {
+	case Request:
+		signed, err := sign(in, strings.TrimSpace(c.settings.After))
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		return handler(ctx, ResponsePort, Response{Context: in.Context, Signed: signed})
+
+	case VerifyRequest:
+		out, err := verify(in)
+		if err == nil && !out.Valid && !c.settings.AllowInvalid {
+			for i, s := range out.Signatures {
+				if !s.Valid {
+					err = fmt.Errorf("signature %d: %s", i+1, s.Error)
+					break
+				}
+			}
+		}
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		out.Context = in.Context
+		return handler(ctx, ResponsePort, out)
+	}
+	return module.Fail(fmt.Errorf("invalid message"))
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+func (c *Component) Ports() []module.Port {
+	var request, response any = Request{}, Response{}
+	if c.settings.Mode == ModeVerify {
+		request, response = VerifyRequest{}, VerifyResponse{}
+	}
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: request,
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: response,
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
@@ -0,0 +1,295 @@
+package dsig
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in any, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+type signer struct {
+	key, cert string
+}
+
+// newSigner makes a key and a self-signed certificate for it, RSA in
+// PKCS #1 or EC in PKCS #8, as keys are pasted in both.
+func newSigner(t *testing.T, ec bool) signer {
+	t.Helper()
+	var key crypto.Signer
+	var block *pem.Block
+	if ec {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, block = k, &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	} else {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, block = k, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer{
+		key:  string(pem.EncodeToMemory(block)),
+		cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func (s signer) sign(t *testing.T, doc, id string, settings Settings) string {
+	t.Helper()
+	port, msg, err := run(t, Request{Context: "ctx", Document: doc, ID: id, Key: s.key, Certificate: s.cert}, settings)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	out := msg.(Response)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out.Signed
+}
+
+func (s signer) verify(t *testing.T, doc string) VerifyResponse {
+	t.Helper()
+	port, msg, err := run(t, VerifyRequest{Context: "ctx", Document: doc, Certificate: s.cert},
+		Settings{Mode: ModeVerify, AllowInvalid: true})
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	out := msg.(VerifyResponse)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out
+}
+
+const response = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="r1">
+  <saml:Issuer>https://idp.example.com</saml:Issuer>
+  <saml:Assertion ID="a1" IssueInstant="2024-03-01T12:00:00Z">
+    <saml:Issuer>https://idp.example.com</saml:Issuer>
+    <saml:Subject><saml:NameID>alice@example.com</saml:NameID></saml:Subject>
+  </saml:Assertion>
+</samlp:Response>`
+
+func TestSignAndVerify(t *testing.T) {
+	for _, ec := range []bool{false, true} {
+		s := newSigner(t, ec)
+		signed := s.sign(t, response, "a1", Settings{After: "Issuer"})
+
+		doc, err := tree.Parse([]byte(signed), tree.ParseOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion := doc.Root().Elements()[1]
+		if names := []string{assertion.Elements()[0].Local, assertion.Elements()[1].Local}; names[1] != "Signature" {
+			t.Fatalf("signature placed at %v", names)
+		}
+		method, _ := child(child(assertion.Elements()[1], "SignedInfo"), "SignatureMethod").Attr("", "Algorithm")
+		if ec != (method == ecdsaSHA256) {
+			t.Fatalf("ec = %v, signature method %s", ec, method)
+		}
+
+		out := s.verify(t, signed)
+		if !out.Valid || len(out.Signatures) != 1 {
+			t.Fatalf("got %+v", out)
+		}
+		ref := out.Signatures[0].References[0]
+		if ref.URI != "#a1" || ref.Element != "saml:Assertion" || !ref.Valid {
+			t.Fatalf("reference = %+v", ref)
+		}
+		// What was signed declares the namespace it uses, which the
+		// response declared, and leaves the signature out.
+		if !strings.HasPrefix(ref.Signed, `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="a1"`) ||
+			strings.Contains(ref.Signed, "Signature") || !strings.Contains(ref.Signed, "alice@example.com") {
+			t.Fatalf("signed = %s", ref.Signed)
+		}
+	}
+}
+
+// Exclusive canonicalization is what lets a signed element be moved out of
+// its document, and a document be reserialized, without breaking the
+// signature.
+func TestSignatureSurvivesReserialization(t *testing.T) {
+	s := newSigner(t, false)
+	signed := s.sign(t, response, "a1", Settings{})
+
+	doc, err := tree.Parse([]byte(signed), tree.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tree.Write(&b, tree.Detach(doc.Root().Elements()[1]), tree.WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if out := s.verify(t, b.String()); !out.Valid {
+		t.Fatalf("detached assertion: %+v", out)
+	}
+
+	// Attributes reordered, quotes changed and a namespace declared that
+	// nothing uses.
+	reformatted := strings.Replace(signed, `<saml:Assertion ID="a1" IssueInstant="2024-03-01T12:00:00Z">`,
+		`<saml:Assertion IssueInstant='2024-03-01T12:00:00Z' xmlns:x="urn:unused" ID='a1'>`, 1)
+	if reformatted == signed {
+		t.Fatalf("assertion not found in %s", signed)
+	}
+	if out := s.verify(t, reformatted); !out.Valid {
+		t.Fatalf("reformatted: %+v", out)
+	}
+}
+
+func TestSignWholeDocument(t *testing.T) {
+	s := newSigner(t, true)
+	signed := s.sign(t, `<?xml version="1.0"?><invoice><total>10.00</total></invoice>`, "", Settings{})
+	if !strings.HasPrefix(signed, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<invoice><total>10.00</total><ds:Signature`) {
+		t.Fatalf("got %s", signed)
+	}
+	out := s.verify(t, signed)
+	if ref := out.Signatures[0].References[0]; !out.Valid || ref.URI != "" || ref.Element != "invoice" {
+		t.Fatalf("got %+v", out)
+	}
+}
+
+// URI="" is the whole document, so what sits outside the root is signed
+// too, and verifies.
+func TestSignWholeDocumentWithProcessingInstruction(t *testing.T) {
+	s := newSigner(t, false)
+	signed := s.sign(t, `<?xml-stylesheet href="s.xsl"?><a><b>x</b></a>`, "", Settings{})
+	out := s.verify(t, signed)
+	if ref := out.Signatures[0].References[0]; !out.Valid || !strings.HasPrefix(ref.Signed, `<?xml-stylesheet href="s.xsl"?>`+"\n<a>") {
+		t.Fatalf("got %+v", out)
+	}
+	if out := s.verify(t, strings.Replace(signed, "s.xsl", "evil.xsl", 1)); out.Valid {
+		t.Fatalf("changed stylesheet: %+v", out)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	s := newSigner(t, false)
+	signed := s.sign(t, response, "a1", Settings{})
+
+	for name, tc := range map[string]struct {
+		doc  string
+		want string
+	}{
+		"content":     {strings.Replace(signed, "alice@", "mallory@", 1), "the digest does not match: <saml:Assertion> was changed"},
+		"signed info": {strings.Replace(signed, `URI="#a1"`, `URI="#r1"`, 1), "the signature value does not match"},
+		"duplicate id": {strings.Replace(signed, `<saml:Issuer>https://idp.example.com</saml:Issuer>`,
+			`<saml:Issuer ID="a1">https://idp.example.com</saml:Issuer>`, 1), `2 elements have the ID "a1"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out := s.verify(t, tc.doc)
+			if out.Valid || !strings.Contains(out.Signatures[0].Error, tc.want) {
+				t.Fatalf("got %+v, want %q", out, tc.want)
+			}
+		})
+	}
+
+	// Outside what is signed, the response can change freely: which is why
+	// data is read from the signed XML.
+	if out := s.verify(t, strings.Replace(signed, `ID="r1"`, `ID="r2"`, 1)); !out.Valid {
+		t.Fatalf("unsigned change: %+v", out)
+	}
+}
+
+// The key a document carries is the forger's choice; only the certificate
+// the flow gives counts.
+func TestVerifyUsesGivenCertificate(t *testing.T) {
+	forger, idp := newSigner(t, false), newSigner(t, false)
+	forged := forger.sign(t, response, "a1", Settings{})
+
+	out := idp.verify(t, forged)
+	if out.Valid || out.Signatures[0].Error != errMismatch.Error() || !out.Signatures[0].References[0].Valid {
+		t.Fatalf("got %+v", out)
+	}
+
+	_, _, err := run(t, VerifyRequest{Document: forged, Certificate: idp.cert}, Settings{Mode: ModeVerify})
+	if err == nil || !strings.Contains(err.Error(), "signature 1: the signature value does not match") {
+		t.Fatalf("err = %v", err)
+	}
+
+	// The base64 of a certificate, as SAML metadata has it, is read too.
+	block, _ := pem.Decode([]byte(forger.cert))
+	bare := signer{cert: base64.StdEncoding.EncodeToString(block.Bytes)}
+	if out := bare.verify(t, forged); !out.Valid {
+		t.Fatalf("bare certificate: %+v", out)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, other := newSigner(t, false), newSigner(t, true)
+	for name, tc := range map[string]struct {
+		in       any
+		settings Settings
+		want     string
+	}{
+		"no such id": {Request{Document: response, ID: "x", Key: s.key}, Settings{}, `no element has the ID "x"`},
+		"no after":   {Request{Document: response, ID: "a1", Key: s.key}, Settings{After: "Status"}, "has no <Status>"},
+		"bad key":    {Request{Document: response, Key: "secret"}, Settings{}, "private key: no PEM block"},
+		"other cert": {Request{Document: response, Key: s.key, Certificate: other.cert}, Settings{}, "the certificate is for another key"},
+		"not xml":    {Request{Document: "{}", Key: s.key}, Settings{}, ""},
+		"unsigned":   {VerifyRequest{Document: response, Certificate: s.cert}, Settings{Mode: ModeVerify}, "the document is not signed"},
+		"no cert":    {VerifyRequest{Document: response, Certificate: "nope"}, Settings{Mode: ModeVerify}, "certificate: "},
+		"unknown c14n": {VerifyRequest{Document: strings.Replace(s.sign(t, response, "", Settings{}), excC14N+`"/><ds:SignatureMethod`,
+			`http://www.w3.org/TR/2001/REC-xml-c14n-20010315"/><ds:SignatureMethod`, 1), Certificate: s.cert},
+			Settings{Mode: ModeVerify}, "only Exclusive XML Canonicalization"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := run(t, tc.in, tc.settings)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+
+			tc.settings.EnableErrorPort = true
+			port, msg, err := run(t, tc.in, tc.settings)
+			if err != nil || port != ErrorPort || msg.(Error).Error == "" {
+				t.Fatalf("port = %q, msg = %v, err = %v", port, msg, err)
+			}
+		})
+	}
+}
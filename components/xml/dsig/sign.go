@@ -0,0 +1,213 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/tiny-systems/encoding-module/components/pemkey"
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+const (
+	dsigNamespace      = "http://www.w3.org/2000/09/xmldsig#"
+	envelopedSignature = dsigNamespace + "enveloped-signature"
+	excC14N            = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	excC14NComments    = excC14N + "WithComments"
+	rsaSHA256          = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	ecdsaSHA256        = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	sha256Digest       = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// element adds a ds: element to parent.
+func element(parent *tree.Node, local, text string) *tree.Node {
+	el := &tree.Node{Kind: tree.ElementNode, Space: dsigNamespace, Prefix: "ds", Local: local}
+	if text != "" {
+		el.Append(&tree.Node{Kind: tree.TextNode, Data: text})
+	}
+	if parent != nil {
+		parent.Append(el)
+	}
+	return el
+}
+
+func algorithm(parent *tree.Node, local, uri string) *tree.Node {
+	el := element(parent, local, "")
+	el.Attrs = append(el.Attrs, tree.Attr{Local: "Algorithm", Value: uri})
+	return el
+}
+
+func canonical(n *tree.Node, opts tree.CanonicalOptions) []byte {
+	var b bytes.Buffer
+	// Writing to a buffer cannot fail.
+	_ = tree.Canonicalize(&b, n, opts)
+	return b.Bytes()
+}
+
+func sign(in Request, after string) (string, error) {
+	doc, err := tree.Parse([]byte(in.Document), tree.ParseOptions{})
+	if err != nil {
+		return "", err
+	}
+	// Without an ID the reference is URI="", which is the whole document,
+	// processing instructions outside the root included; comments are left
+	// out, as a reference always leaves them. The signature still goes in
+	// the root.
+	target, parent, uri := doc, doc.Root(), ""
+	if in.ID != "" {
+		if target, err = byID(doc, in.ID); err != nil {
+			return "", err
+		}
+		parent, uri = target, "#"+in.ID
+	}
+
+	key, err := pemkey.PrivateKey([]byte(in.Key))
+	if err != nil {
+		return "", fmt.Errorf("private key: %w", err)
+	}
+	method := rsaSHA256
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = ecdsaSHA256
+	}
+	var cert *x509.Certificate
+	if in.Certificate != "" {
+		if cert, err = pemkey.Certificate([]byte(in.Certificate)); err != nil {
+			return "", fmt.Errorf("certificate: %w", err)
+		}
+		if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+			return "", fmt.Errorf("the certificate is for another key")
+		}
+	}
+
+	signature := element(nil, "Signature", "")
+	signature.Attrs = []tree.Attr{{Space: tree.XMLNSNamespace, Prefix: "xmlns", Local: "ds", Value: dsigNamespace}}
+	signedInfo := element(signature, "SignedInfo", "")
+	algorithm(signedInfo, "CanonicalizationMethod", excC14N)
+	algorithm(signedInfo, "SignatureMethod", method)
+	reference := element(signedInfo, "Reference", "")
+	reference.Attrs = []tree.Attr{{Local: "URI", Value: uri}}
+	transforms := element(reference, "Transforms", "")
+	algorithm(transforms, "Transform", envelopedSignature)
+	algorithm(transforms, "Transform", excC14N)
+	algorithm(reference, "DigestMethod", sha256Digest)
+	digestValue := element(reference, "DigestValue", "")
+	signatureValue := element(signature, "SignatureValue", "")
+	if cert != nil {
+		x509Data := element(element(signature, "KeyInfo", ""), "X509Data", "")
+		element(x509Data, "X509Certificate", base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	if err := insert(parent, signature, after); err != nil {
+		return "", err
+	}
+
+	// The signature is in place before anything is digested, so that the
+	// namespaces SignedInfo is canonicalized with are those it will have.
+	digest := crypto.SHA256.New()
+	digest.Write(canonical(target, tree.CanonicalOptions{Exclude: signature}))
+	digestValue.Append(&tree.Node{Kind: tree.TextNode, Data: base64.StdEncoding.EncodeToString(digest.Sum(nil))})
+
+	h := crypto.SHA256.New()
+	h.Write(canonical(signedInfo, tree.CanonicalOptions{}))
+	value, err := signDigest(key, h.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+	signatureValue.Append(&tree.Node{Kind: tree.TextNode, Data: base64.StdEncoding.EncodeToString(value)})
+
+	// Written without layout: whitespace added now would change what was
+	// signed.
+	declaration := strings.HasPrefix(strings.TrimSpace(in.Document), "<?xml")
+	var b bytes.Buffer
+	if err := tree.Write(&b, doc, tree.WriteOptions{Declaration: declaration}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// insert puts the signature inside the signed element: last, or after its
+// first child named after, where a schema such as SAML's wants it.
+func insert(target, signature *tree.Node, after string) error {
+	if after == "" {
+		target.Append(signature)
+		return nil
+	}
+	for i, c := range target.Children {
+		if c.Kind == tree.ElementNode && c.Local == after {
+			signature.Parent = target
+			target.Children = append(target.Children[:i+1], append([]*tree.Node{signature}, target.Children[i+1:]...)...)
+			return nil
+		}
+	}
+	return fmt.Errorf("<%s> has no <%s> to put the signature after", target.Name(), after)
+}
+
+// signDigest signs with PKCS #1 v1.5 for RSA; for ECDSA, XML signatures
+// carry r and s side by side, each the size of the curve, not the ASN.1
+// JWT and TLS use.
+func signDigest(key crypto.Signer, digest []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		out := make([]byte, 2*size)
+		r.FillBytes(out[:size])
+		s.FillBytes(out[size:])
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// byID finds the element a same-document reference names. An ID on more
+// than one element is refused: which of them a signature covers is then a
+// matter of which one the reader looks at, which is how signatures on SAML
+// responses have been forged.
+func byID(doc *tree.Node, id string) (*tree.Node, error) {
+	var found []*tree.Node
+	var walk func(*tree.Node)
+	walk = func(n *tree.Node) {
+		for _, c := range n.Elements() {
+			for _, a := range c.Attrs {
+				if a.Value == id && isID(a) {
+					found = append(found, c)
+					break
+				}
+			}
+			walk(c)
+		}
+	}
+	walk(doc)
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no element has the ID %q", id)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%d elements have the ID %q", len(found), id)
+}
+
+const wsuNamespace = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+
+// isID reports whether the attribute is one of the ID attributes signatures
+// refer by: ID as SAML has it, Id or id, wsu:Id or xml:id.
+func isID(a tree.Attr) bool {
+	switch a.Space {
+	case "":
+		return a.Local == "ID" || a.Local == "Id" || a.Local == "id"
+	case wsuNamespace:
+		return a.Local == "Id"
+	case tree.XMLNamespace:
+		return a.Local == "id"
+	}
+	return false
+}
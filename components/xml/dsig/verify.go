@@ -0,0 +1,283 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/tiny-systems/encoding-module/components/pemkey"
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+// digests are the digest methods references are checked with. SHA-1 is
+// left out: a SHA-1 digest no longer shows a document is unchanged.
+var digests = map[string]crypto.Hash{
+	sha256Digest: crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#sha384": crypto.SHA384,
+	"http://www.w3.org/2001/04/xmlenc#sha512":       crypto.SHA512,
+}
+
+type signatureMethod struct {
+	hash crypto.Hash
+	ec   bool
+}
+
+var signatureMethods = map[string]signatureMethod{
+	rsaSHA256: {crypto.SHA256, false},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha384": {crypto.SHA384, false},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512": {crypto.SHA512, false},
+	ecdsaSHA256: {crypto.SHA256, true},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384": {crypto.SHA384, true},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512": {crypto.SHA512, true},
+}
+
+var errMismatch = errors.New("the signature value does not match: the document was signed with another key, or its SignedInfo was changed")
+
+// child is el's first ds: child element with the name, or nil.
+func child(el *tree.Node, local string) *tree.Node {
+	if el == nil {
+		return nil
+	}
+	for _, c := range el.Elements() {
+		if c.Space == dsigNamespace && c.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+func children(el *tree.Node, local string) []*tree.Node {
+	if el == nil {
+		return nil
+	}
+	var out []*tree.Node
+	for _, c := range el.Elements() {
+		if c.Space == dsigNamespace && c.Local == local {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func attr(el *tree.Node, local string) string {
+	if el == nil {
+		return ""
+	}
+	v, _ := el.Attr("", local)
+	return strings.TrimSpace(v)
+}
+
+// base64Text decodes an element holding base64, which signatures wrap over
+// as many lines as they like.
+func base64Text(el *tree.Node, what string) ([]byte, error) {
+	if el == nil {
+		return nil, fmt.Errorf("no %s", what)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(el.Text()), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not base64", what)
+	}
+	return data, nil
+}
+
+func verify(in VerifyRequest) (VerifyResponse, error) {
+	doc, err := tree.Parse([]byte(in.Document), tree.ParseOptions{})
+	if err != nil {
+		return VerifyResponse{}, err
+	}
+	// The key comes from the flow, never from the document's KeyInfo: a
+	// document can carry any key, including its forger's.
+	key, err := pemkey.PublicKey([]byte(in.Certificate))
+	if err != nil {
+		return VerifyResponse{}, fmt.Errorf("certificate: %w", err)
+	}
+
+	var signatures []*tree.Node
+	var walk func(*tree.Node)
+	walk = func(n *tree.Node) {
+		for _, c := range n.Elements() {
+			if c.Space == dsigNamespace && c.Local == "Signature" {
+				signatures = append(signatures, c)
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(doc)
+	if len(signatures) == 0 {
+		return VerifyResponse{}, fmt.Errorf("the document is not signed: it has no ds:Signature")
+	}
+
+	out := VerifyResponse{Valid: true, Signatures: []Signature{}}
+	for _, el := range signatures {
+		s := verifySignature(doc, el, key)
+		out.Valid = out.Valid && s.Valid
+		out.Signatures = append(out.Signatures, s)
+	}
+	return out, nil
+}
+
+// verifySignature checks the signature value and then each reference. A
+// reference's digest is reported either way, since it tells which part of a
+// document changed, but it shows nothing unless the signature value is
+// valid too.
+func verifySignature(doc, sig *tree.Node, key crypto.PublicKey) Signature {
+	out := Signature{References: []Reference{}}
+	err := checkSignatureValue(sig, key)
+	for _, ref := range children(child(sig, "SignedInfo"), "Reference") {
+		r := Reference{URI: attr(ref, "URI")}
+		target, data, refErr := checkReference(doc, sig, ref)
+		if target != nil {
+			r.Element = target.Root().Name()
+		}
+		if refErr != nil {
+			r.Error = refErr.Error()
+			if err == nil {
+				err = fmt.Errorf("reference %q: %w", r.URI, refErr)
+			}
+		} else {
+			r.Valid, r.Signed = true, string(data)
+		}
+		out.References = append(out.References, r)
+	}
+	if err == nil && len(out.References) == 0 {
+		err = fmt.Errorf("the signature has no references")
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	out.Valid = err == nil
+	return out
+}
+
+func checkSignatureValue(sig *tree.Node, key crypto.PublicKey) error {
+	signedInfo := child(sig, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("the signature has no SignedInfo")
+	}
+	opts, err := canonicalization(child(signedInfo, "CanonicalizationMethod"))
+	if err != nil {
+		return err
+	}
+	uri := attr(child(signedInfo, "SignatureMethod"), "Algorithm")
+	method, ok := signatureMethods[uri]
+	if !ok {
+		return fmt.Errorf("signature method %q is not supported: use RSA or ECDSA with SHA-256, SHA-384 or SHA-512", uri)
+	}
+	value, err := base64Text(child(sig, "SignatureValue"), "SignatureValue")
+	if err != nil {
+		return err
+	}
+
+	h := method.hash.New()
+	h.Write(canonical(signedInfo, opts))
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if method.ec {
+			return fmt.Errorf("the certificate has an RSA key, but the document is signed with %s", uri)
+		}
+		if rsa.VerifyPKCS1v15(key, method.hash, digest, value) != nil {
+			return errMismatch
+		}
+	case *ecdsa.PublicKey:
+		if !method.ec {
+			return fmt.Errorf("the certificate has an EC key, but the document is signed with %s", uri)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(value) != 2*size {
+			return errMismatch
+		}
+		r, s := new(big.Int).SetBytes(value[:size]), new(big.Int).SetBytes(value[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errMismatch
+		}
+	}
+	return nil
+}
+
+// canonicalization reads a CanonicalizationMethod or Transform naming
+// Exclusive XML Canonicalization, the only one supported.
+func canonicalization(el *tree.Node) (tree.CanonicalOptions, error) {
+	var opts tree.CanonicalOptions
+	switch uri := attr(el, "Algorithm"); uri {
+	case excC14N:
+	case excC14NComments:
+		opts.Comments = true
+	default:
+		return opts, fmt.Errorf("canonicalization %q is not supported: only Exclusive XML Canonicalization is", uri)
+	}
+	for _, c := range el.Elements() {
+		if c.Space == excC14N && c.Local == "InclusiveNamespaces" {
+			opts.InclusivePrefixes = strings.Fields(attr(c, "PrefixList"))
+		}
+	}
+	return opts, nil
+}
+
+// checkReference digests what a reference points at, as its transforms
+// have it, and returns the element and the canonical form digested.
+func checkReference(doc, sig, ref *tree.Node) (*tree.Node, []byte, error) {
+	uri := attr(ref, "URI")
+	var target *tree.Node
+	switch {
+	case uri == "":
+		target = doc
+	case strings.HasPrefix(uri, "#"):
+		var err error
+		if target, err = byID(doc, uri[1:]); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("only references within the document, empty or #ID, are supported")
+	}
+
+	var opts tree.CanonicalOptions
+	canonicalized := false
+	for _, t := range children(child(ref, "Transforms"), "Transform") {
+		switch uri := attr(t, "Algorithm"); uri {
+		case envelopedSignature:
+			opts.Exclude = sig
+		case excC14N, excC14NComments:
+			c, _ := canonicalization(t)
+			// A reference to the document or an ID leaves comments out,
+			// whichever variant it names.
+			opts.InclusivePrefixes, canonicalized = c.InclusivePrefixes, true
+		default:
+			return target, nil, fmt.Errorf("transform %q is not supported", uri)
+		}
+	}
+	if !canonicalized {
+		return target, nil, fmt.Errorf("the reference is not canonicalized with Exclusive XML Canonicalization, the only one supported")
+	}
+	uri = attr(child(ref, "DigestMethod"), "Algorithm")
+	hash, ok := digests[uri]
+	if !ok {
+		return target, nil, fmt.Errorf("digest method %q is not supported: use SHA-256, SHA-384 or SHA-512", uri)
+	}
+	want, err := base64Text(child(ref, "DigestValue"), "DigestValue")
+	if err != nil {
+		return target, nil, err
+	}
+
+	data := canonical(target, opts)
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil), want) {
+		return target, nil, fmt.Errorf("the digest does not match: %s was changed after it was signed", what(target))
+	}
+	return target, data, nil
+}
+
+func what(n *tree.Node) string {
+	if n.Kind == tree.DocumentNode {
+		return "the document"
+	}
+	return "<" + n.Name() + ">"
+}
@@ -3,8 +3,9 @@ package encode
 import (
 	"bytes"
 	"context"
-	"encoding/xml"
 	"fmt"
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
 	"github.com/tiny-systems/module/api/v1alpha1"
 	"github.com/tiny-systems/module/module"
 	"github.com/tiny-systems/module/registry"
@@ -15,12 +16,40 @@ const (
 	RequestPort   = "request"
 	ResponsePort  = "response"
 	ErrorPort     = "error"
+
+	IndentNone = "none"
+	Indent2    = "2"
+	Indent4    = "4"
+	IndentTab  = "tab"
 )
 
 type Context any
 
 type Settings struct {
 	EnableErrorPort bool `json:"enableErrorPort" required:"true" title:"Enable Error Port" description:"If error happen, error port will emit an error message"`
+
+	RootName string `json:"rootName" title:"Root Element" description:"Name of the root element. Leave empty to take it from a document with a single key, so {\"order\":{…}} — what xml_decode produces — is written as <order>…</order>. Any other document is wrapped in <root>."`
+	ItemName string `json:"itemName" default:"item" title:"Array Item Element" description:"Name of the elements an array is written as when no key names them: the elements of a top-level array, or of an array inside an array. An array under a key repeats that key instead: {\"line\":[…]} is several <line> elements."`
+
+	// An object from the flow has lost whatever order it was written in, so
+	// the encoder has to choose one. Sorted is stable; this is how the author
+	// says what a schema with an xs:sequence expects.
+	ElementOrder []string `json:"elementOrder" title:"Element Order" description:"Element names written first, in this order, wherever they appear, such as id, name, lines. Other elements follow sorted by name."`
+
+	Namespace  string      `json:"namespace" title:"Default Namespace" description:"Declared on the root element as xmlns, putting every element without a prefix in it, such as urn:iso:std:iso:20022:tech:xsd:pain.001.001.09."`
+	Namespaces []Namespace `json:"namespaces" title:"Namespace Prefixes" description:"Prefixes declared on the root element, for documents whose keys are written with them, such as cbc:ID. A prefix the document uses without a declaration here or in an @xmlns key is an error, not a document other parsers refuse."`
+
+	Declaration bool   `json:"declaration" title:"XML Declaration" description:"Write <?xml version=\"1.0\" encoding=\"…\"?> first. Most partner specifications require it."`
+	Encoding    string `json:"encoding" default:"UTF-8" enum:"UTF-8,ISO-8859-1,windows-1252,US-ASCII" title:"Encoding" description:"The encoding the declaration names. For the single-byte ones every character outside ASCII is written as a character reference, so the text is correct whichever of them the receiver decodes it with."`
+	Indent      string `json:"indent" default:"none" enum:"none,2,4,tab" enumTitles:"None (compact)|2 spaces|4 spaces|Tab" title:"Indent" description:"Put each element on its own line, for output a person reads. Elements holding text are left as they are, since added whitespace would become part of the text."`
+
+	CDATA []string `json:"cdata" title:"CDATA Elements" description:"Elements whose text is written as a CDATA section instead of escaped — for receivers that expect embedded HTML or XML in one. A path from the root such as /Envelope/Body/payload (* matches any one element), or a bare name such as payload to match it anywhere."`
+}
+
+// Namespace binds a prefix to a namespace URI.
+type Namespace struct {
+	Prefix string `json:"prefix" required:"true" title:"Prefix" description:"Such as cbc or soap."`
+	URI    string `json:"uri" required:"true" title:"URI"`
 }
 
 type Error struct {
@@ -30,7 +59,7 @@ type Error struct {
 
 type Request struct {
 	Context  Context `json:"context" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with encoded message"`
-	Document any     `json:"document" required:"true" configurable:"true" title:"Input object" description:""`
+	Document any     `json:"document" required:"true" configurable:"true" title:"Input object" description:"Keys starting with @ are attributes, #text is the element's text, an array repeats its key's element, and any other key is a child element: {\"order\":{\"@id\":7,\"line\":[{\"sku\":\"a\"},{\"sku\":\"b\"}]}}."`
 }
 
 type Response struct {
@@ -46,8 +75,13 @@ func (h *Component) GetInfo() module.ComponentInfo {
 	return module.ComponentInfo{
 		Name:        ComponentName,
 		Description: "XML Encoder",
-		Info:        "Encodes input document with XML",
-		Tags:        []string{"xml"},
+		Info: "Encodes a document as XML, under the convention xml_decode reads: keys starting with @ are attributes, " +
+			"#text is an element's text, an array repeats its key's element and any other key is a child element. " +
+			"{\"order\":{\"@id\":7,\"line\":[\"a\",\"b\"]}} is written as <order id=\"7\"><line>a</line><line>b</line></order>. " +
+			"Elements are written sorted by name unless elementOrder says otherwise — set it when a schema expects a sequence. " +
+			"Declare namespaces in settings rather than in the document, turn the declaration on when a partner " +
+			"specification requires it, and list in cdata the elements that carry embedded markup.",
+		Tags: []string{"xml"},
 	}
 }
 
@@ -76,9 +110,8 @@ func (h *Component) Handle(ctx context.Context, handler module.Handler, port str
 	}
 
 	b := bytes.NewBuffer(nil)
-	enc := xml.NewEncoder(b)
 
-	err := enc.Encode(in.Document)
+	err := h.encode(b, in.Document)
 	if err != nil {
 		if !h.settings.EnableErrorPort {
 			return module.Fail(err)
@@ -95,6 +128,47 @@ func (h *Component) Handle(ctx context.Context, handler module.Handler, port str
 	})
 }
 
+// encode writes the document through the generic tree, so a map from the flow
+// encodes the way a struct written for encoding/xml would.
+func (h *Component) encode(b *bytes.Buffer, document any) error {
+	doc, err := value.Normalize(document)
+	if err != nil {
+		return err
+	}
+	namespaces := make(map[string]string, len(h.settings.Namespaces))
+	for _, ns := range h.settings.Namespaces {
+		namespaces[ns.Prefix] = ns.URI
+	}
+	root, err := tree.FromValue(doc, tree.BuildOptions{
+		RootName:   h.settings.RootName,
+		ItemName:   h.settings.ItemName,
+		Order:      h.settings.ElementOrder,
+		Namespace:  h.settings.Namespace,
+		Namespaces: namespaces,
+	})
+	if err != nil {
+		return err
+	}
+	return tree.Write(b, root, tree.WriteOptions{
+		Declaration: h.settings.Declaration,
+		Encoding:    h.settings.Encoding,
+		Indent:      h.indent(),
+		CDATA:       h.settings.CDATA,
+	})
+}
+
+func (h *Component) indent() string {
+	switch h.settings.Indent {
+	case Indent2:
+		return "  "
+	case Indent4:
+		return "    "
+	case IndentTab:
+		return "\t"
+	}
+	return ""
+}
+
 func (h *Component) Ports() []module.Port {
 	ports := []module.Port{
 		{
@@ -0,0 +1,108 @@
+package encode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func encoded(t *testing.T, doc any, settings Settings) string {
+	t.Helper()
+	port, msg, err := run(t, Request{Context: "ctx", Document: doc}, settings)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	out := msg.(Response)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out.Encoded
+}
+
+// A map is what every flow hands over; encoding/xml refuses it outright.
+func TestEncodeMap(t *testing.T) {
+	doc := map[string]any{"order": map[string]any{"@id": 7, "line": []any{"a", "b"}}}
+	if got := encoded(t, doc, Settings{}); got != `<order id="7"><line>a</line><line>b</line></order>` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestRootAndItemNames(t *testing.T) {
+	if got := encoded(t, []any{map[string]any{"sku": "a"}}, Settings{RootName: "lines", ItemName: "line"}); got != `<lines><line><sku>a</sku></line></lines>` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+// Structs still encode, through their JSON form.
+func TestEncodeStruct(t *testing.T) {
+	type line struct {
+		SKU string `json:"sku"`
+		Qty int    `json:"@qty"`
+	}
+	if got := encoded(t, map[string]any{"line": line{SKU: "a", Qty: 2}}, Settings{}); got != `<line qty="2"><sku>a</sku></line>` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestElementOrder(t *testing.T) {
+	doc := map[string]any{"p": map[string]any{"b": "1", "a": "2"}}
+	if got := encoded(t, doc, Settings{ElementOrder: []string{"b"}}); got != `<p><b>1</b><a>2</a></p>` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestInvalidNameGoesToErrorPort(t *testing.T) {
+	port, msg, err := run(t, Request{Context: "ctx", Document: map[string]any{"bad name": 1}}, Settings{EnableErrorPort: true})
+	if err != nil || port != ErrorPort || msg.(Error).Context != "ctx" {
+		t.Fatalf("port = %q, msg = %v, err = %v", port, msg, err)
+	}
+}
+
+// The shape an ISO 20022 or UBL partner asks for, without post-processing
+// the string.
+func TestPartnerSettings(t *testing.T) {
+	doc := map[string]any{"Invoice": map[string]any{"cbc:ID": "INV-1", "cbc:Note": "<b>bold</b>"}}
+	got := encoded(t, doc, Settings{
+		Namespace:   "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2",
+		Namespaces:  []Namespace{{Prefix: "cbc", URI: "urn:cbc"}},
+		Declaration: true,
+		Encoding:    "UTF-8",
+		Indent:      Indent2,
+		CDATA:       []string{"cbc:Note"},
+	})
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2" xmlns:cbc="urn:cbc">
+  <cbc:ID>INV-1</cbc:ID>
+  <cbc:Note><![CDATA[<b>bold</b>]]></cbc:Note>
+</Invoice>
+`
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUndeclaredPrefixIsAnError(t *testing.T) {
+	if _, _, err := run(t, Request{Document: map[string]any{"soap:Envelope": ""}}, Settings{}); err == nil {
+		t.Fatal("wrote an undeclared prefix")
+	}
+}
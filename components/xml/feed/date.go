@@ -0,0 +1,75 @@
+package feed
+
+import (
+	"strings"
+	"time"
+)
+
+// layouts are the date formats feeds are found with. RSS specifies RFC 822
+// and Atom RFC 3339, but publishers drop the weekday, the seconds or the
+// zone, pad or don't, and write the one in the other's format.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"Mon, 2 January 2006 15:04:05 -0700",
+	"Monday, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05",
+	"Mon, 2 Jan 2006",
+	"2 Jan 2006",
+	"Mon Jan 2 15:04:05 -0700 2006",
+}
+
+// zones are the named zones RFC 822 allows, and the few more feeds use. Go
+// parses a zone name as an unknown zone with offset 0, which for EST is five
+// hours wrong.
+var zones = map[string]string{
+	"UT": "+0000", "UTC": "+0000", "GMT": "+0000", "Z": "+0000",
+	"EST": "-0500", "EDT": "-0400",
+	"CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600",
+	"PST": "-0800", "PDT": "-0700",
+	"CET": "+0100", "CEST": "+0200",
+	"BST": "+0100", "IST": "+0530",
+}
+
+// parseDate reads a date in any of the forms feeds use. A date without a
+// zone is taken as UTC.
+func parseDate(s string) (time.Time, bool) {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return time.Time{}, false
+	}
+	if i := strings.LastIndexByte(s, ' '); i >= 0 {
+		if offset, ok := zones[strings.ToUpper(s[i+1:])]; ok {
+			s = s[:i+1] + offset
+		}
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// rfc3339 normalizes a date to RFC 3339, the format items carry dates in,
+// keeping its offset. A date that cannot be read is empty.
+func rfc3339(s string) string {
+	t, ok := parseDate(s)
+	if !ok {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,272 @@
+package feed
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+const (
+	// rss1Namespace is RSS 1.0's, whose items sit beside the channel rather
+	// than in it.
+	rss1Namespace = "http://purl.org/rss/1.0/"
+	// atom03Namespace is the draft Atom that some feeds never moved on from.
+	atom03Namespace = "http://purl.org/atom/ns#"
+	rdfNamespace    = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+func decode(data []byte) (DecodeResponse, error) {
+	// Feeds are hand-edited and templated, and a reader that refuses a feed
+	// for an &nbsp; in a title is no use; strict first, so that a well-formed
+	// feed is read exactly as written.
+	doc, err := tree.Parse(data, tree.ParseOptions{})
+	if err != nil {
+		var lenientErr error
+		if doc, lenientErr = tree.Parse(data, tree.ParseOptions{Lenient: true}); lenientErr != nil {
+			return DecodeResponse{}, err
+		}
+	}
+	root := doc.Root()
+	switch {
+	case root.Local == "rss" || (root.Local == "RDF" && root.Space == rdfNamespace):
+		return decodeRSS(root), nil
+	case root.Local == "feed" && (root.Space == atomNamespace || root.Space == atom03Namespace):
+		return decodeAtom(root), nil
+	}
+	return DecodeResponse{}, fmt.Errorf("not an RSS or Atom feed: the root element is <%s>", root.Name())
+}
+
+// child is el's first child element with the name, or nil.
+func child(el *tree.Node, space, local string) *tree.Node {
+	if el == nil {
+		return nil
+	}
+	for _, c := range el.Children {
+		if c.Kind == tree.ElementNode && c.Space == space && c.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+func children(el *tree.Node, space, local string) []*tree.Node {
+	var out []*tree.Node
+	for _, c := range el.Elements() {
+		if c.Space == space && c.Local == local {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func text(el *tree.Node) string {
+	if el == nil {
+		return ""
+	}
+	return strings.TrimSpace(el.Text())
+}
+
+// first is the first of values that is not empty.
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+var tag = regexp.MustCompile(`<[a-zA-Z/!][^>]*>`)
+
+// plainText turns a title into the plain text it should have been. Titles
+// turn up with markup, escaped markup and HTML entities in them, all of
+// which a reader would show as text; a < not starting a tag is left alone.
+func plainText(s string) string {
+	if strings.ContainsAny(s, "<&") {
+		s = html.UnescapeString(tag.ReplaceAllString(s, ""))
+		// Escaped twice, as templates do, the markup only shows now.
+		s = html.UnescapeString(tag.ReplaceAllString(s, ""))
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func decodeRSS(root *tree.Node) DecodeResponse {
+	// RSS 2.0 is in no namespace, RSS 1.0 in its own.
+	space := ""
+	if root.Local == "RDF" {
+		space = rss1Namespace
+	}
+	ch := child(root, space, "channel")
+	out := DecodeResponse{Format: FormatRSS, Items: []Item{}}
+	if ch != nil {
+		out.Channel = Channel{
+			Title:       plainText(text(child(ch, space, "title"))),
+			Link:        text(child(ch, space, "link")),
+			Description: text(child(ch, space, "description")),
+			Language:    first(text(child(ch, space, "language")), text(child(ch, dcNamespace, "language"))),
+			Author:      first(text(child(ch, space, "managingEditor")), text(child(ch, dcNamespace, "creator"))),
+			Updated: rfc3339(first(text(child(ch, space, "lastBuildDate")), text(child(ch, space, "pubDate")),
+				text(child(ch, dcNamespace, "date")))),
+		}
+		for _, l := range children(ch, atomNamespace, "link") {
+			if attrValue(l, "rel") == "self" {
+				out.Channel.Self = attrValue(l, "href")
+			}
+		}
+	}
+	items := children(root, space, "item")
+	if ch != nil && space == "" {
+		items = children(ch, space, "item")
+	}
+	for _, el := range items {
+		out.Items = append(out.Items, rssItem(el, space))
+	}
+	return out
+}
+
+func rssItem(el *tree.Node, space string) Item {
+	item := Item{
+		Title:     plainText(text(child(el, space, "title"))),
+		Link:      text(child(el, space, "link")),
+		Published: rfc3339(first(text(child(el, space, "pubDate")), text(child(el, dcNamespace, "date")))),
+		Updated:   rfc3339(text(child(el, atomNamespace, "updated"))),
+		Summary:   text(child(el, space, "description")),
+		Content:   text(child(el, contentNamespace, "encoded")),
+		Author:    first(text(child(el, space, "author")), text(child(el, dcNamespace, "creator"))),
+	}
+	if guid := child(el, space, "guid"); guid != nil {
+		item.ID = text(guid)
+		// A guid is a permalink unless it says otherwise, and feeds that
+		// leave out the link rely on it.
+		if item.Link == "" && attrValue(guid, "isPermaLink") != "false" && strings.Contains(item.ID, "://") {
+			item.Link = item.ID
+		}
+	}
+	if item.ID == "" && space == rss1Namespace {
+		item.ID = attrValueNS(el, rdfNamespace, "about")
+	}
+	for _, c := range children(el, space, "category") {
+		if t := text(c); t != "" {
+			item.Categories = append(item.Categories, t)
+		}
+	}
+	for _, e := range children(el, space, "enclosure") {
+		if url := attrValue(e, "url"); url != "" {
+			length, _ := strconv.ParseInt(strings.TrimSpace(attrValue(e, "length")), 10, 64)
+			item.Enclosures = append(item.Enclosures, Enclosure{URL: url, Type: attrValue(e, "type"), Length: length})
+		}
+	}
+	return withID(item)
+}
+
+// withID gives an item without an id the one encode would, so that items
+// can be told apart whatever the feed left out.
+func withID(item Item) Item {
+	if item.ID == "" {
+		d := dated{Item: item}
+		d.published, _ = parseDate(item.Published)
+		item.ID, _ = d.id()
+	}
+	return item
+}
+
+func decodeAtom(root *tree.Node) DecodeResponse {
+	space := root.Space
+	out := DecodeResponse{Format: FormatAtom, Items: []Item{}}
+	lang, _ := root.Attr(tree.XMLNamespace, "lang")
+	out.Channel = Channel{
+		Title:       plainText(text(child(root, space, "title"))),
+		Description: text(either(child(root, space, "subtitle"), child(root, space, "tagline"))),
+		ID:          text(child(root, space, "id")),
+		Updated:     rfc3339(first(text(child(root, space, "updated")), text(child(root, space, "modified")))),
+		Language:    lang,
+		Author:      text(child(child(root, space, "author"), space, "name")),
+	}
+	for _, l := range children(root, space, "link") {
+		switch attrValue(l, "rel") {
+		case "", "alternate":
+			if out.Channel.Link == "" {
+				out.Channel.Link = attrValue(l, "href")
+			}
+		case "self":
+			out.Channel.Self = attrValue(l, "href")
+		}
+	}
+
+	for _, el := range children(root, space, "entry") {
+		item := Item{
+			ID:        text(child(el, space, "id")),
+			Title:     plainText(text(child(el, space, "title"))),
+			Published: rfc3339(first(text(child(el, space, "published")), text(child(el, space, "issued")))),
+			Updated:   rfc3339(first(text(child(el, space, "updated")), text(child(el, space, "modified")))),
+			Summary:   atomHTML(child(el, space, "summary")),
+			Content:   atomHTML(child(el, space, "content")),
+			Author: first(text(child(child(el, space, "author"), space, "name")),
+				text(child(child(root, space, "author"), space, "name"))),
+		}
+		for _, c := range children(el, space, "category") {
+			if term := attrValue(c, "term"); term != "" {
+				item.Categories = append(item.Categories, term)
+			}
+		}
+		for _, l := range children(el, space, "link") {
+			switch attrValue(l, "rel") {
+			case "", "alternate":
+				if item.Link == "" {
+					item.Link = attrValue(l, "href")
+				}
+			case "enclosure":
+				length, _ := strconv.ParseInt(attrValue(l, "length"), 10, 64)
+				item.Enclosures = append(item.Enclosures, Enclosure{URL: attrValue(l, "href"), Type: attrValue(l, "type"), Length: length})
+			}
+		}
+		out.Items = append(out.Items, withID(item))
+	}
+	return out
+}
+
+func either(a, b *tree.Node) *tree.Node {
+	if a != nil {
+		return a
+	}
+	return b
+}
+
+// atomHTML is an Atom text construct as HTML, whichever type it declares:
+// text is escaped, html is as it is, and xhtml is the markup inside its div.
+func atomHTML(el *tree.Node) string {
+	if el == nil {
+		return ""
+	}
+	switch attrValue(el, "type") {
+	case "html", "text/html":
+		return strings.TrimSpace(el.Text())
+	case "xhtml", "application/xhtml+xml":
+		div := el
+		if elements := el.Elements(); len(elements) == 1 && elements[0].Local == "div" {
+			div = elements[0]
+		}
+		var b bytes.Buffer
+		for _, c := range div.Children {
+			if err := tree.Write(&b, c, tree.WriteOptions{}); err != nil {
+				return strings.TrimSpace(div.Text())
+			}
+		}
+		return strings.TrimSpace(b.String())
+	}
+	return html.EscapeString(strings.TrimSpace(el.Text()))
+}
+
+func attrValue(el *tree.Node, local string) string {
+	return attrValueNS(el, "", local)
+}
+
+func attrValueNS(el *tree.Node, space, local string) string {
+	v, _ := el.Attr(space, local)
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,308 @@
+package feed
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+const (
+	atomNamespace    = "http://www.w3.org/2005/Atom"
+	contentNamespace = "http://purl.org/rss/1.0/modules/content/"
+	dcNamespace      = "http://purl.org/dc/elements/1.1/"
+)
+
+// now is the clock a feed without any dates is stamped with.
+var now = time.Now
+
+func element(parent *tree.Node, name, text string) *tree.Node {
+	el := &tree.Node{Kind: tree.ElementNode}
+	el.Prefix, el.Local = split(name)
+	if text != "" {
+		el.Append(&tree.Node{Kind: tree.TextNode, Data: text})
+	}
+	parent.Append(el)
+	return el
+}
+
+func attr(el *tree.Node, name, value string) {
+	a := tree.Attr{Value: value}
+	a.Prefix, a.Local = split(name)
+	el.Attrs = append(el.Attrs, a)
+}
+
+func split(name string) (prefix, local string) {
+	if prefix, local, ok := strings.Cut(name, ":"); ok {
+		return prefix, local
+	}
+	return "", name
+}
+
+// optional adds the element only when it has text.
+func optional(parent *tree.Node, name, text string) {
+	if text != "" {
+		element(parent, name, text)
+	}
+}
+
+func write(root *tree.Node) (string, error) {
+	var b bytes.Buffer
+	if err := tree.Write(&b, root, tree.WriteOptions{Declaration: true, Indent: "  "}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// date reads a date given to encode, which may be in any format decode
+// accepts. Empty is the zero time.
+func date(s, what string) (time.Time, error) {
+	if strings.TrimSpace(s) == "" {
+		return time.Time{}, nil
+	}
+	t, ok := parseDate(s)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s %q is not a date: use RFC 3339, such as 2024-03-01T12:00:00Z", what, s)
+	}
+	return t, nil
+}
+
+// dated is an item with its dates read.
+type dated struct {
+	Item
+	published, updated time.Time
+}
+
+// prepare reads the items' dates and works out the feed's: the channel's
+// own, else the latest item's, else now.
+func prepare(ch Channel, items []Item) ([]dated, time.Time, error) {
+	out := make([]dated, len(items))
+	var latest time.Time
+	for i, item := range items {
+		if strings.TrimSpace(item.Title) == "" && item.Summary == "" && item.Content == "" {
+			return nil, time.Time{}, fmt.Errorf("item %d needs a title, summary or content", i)
+		}
+		d := dated{Item: item}
+		var err error
+		if d.published, err = date(item.Published, fmt.Sprintf("item %d: published", i)); err != nil {
+			return nil, time.Time{}, err
+		}
+		if d.updated, err = date(item.Updated, fmt.Sprintf("item %d: updated", i)); err != nil {
+			return nil, time.Time{}, err
+		}
+		for _, t := range []time.Time{d.published, d.updated} {
+			if t.After(latest) {
+				latest = t
+			}
+		}
+		out[i] = d
+	}
+	updated, err := date(ch.Updated, "channel updated")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if updated.IsZero() {
+		updated = latest
+	}
+	if updated.IsZero() {
+		updated = now()
+	}
+	return out, updated, nil
+}
+
+// id is what identifies an item. Without an id or a link, a hash of the
+// title and date gives the same id every time the item is written, which is
+// what keeps readers from showing it as new again.
+func (d dated) id() (id string, permalink bool) {
+	switch {
+	case d.ID != "":
+		return d.ID, d.ID == d.Link && strings.Contains(d.ID, "://")
+	case d.Link != "":
+		return d.Link, true
+	}
+	var published string
+	if !d.published.IsZero() {
+		published = d.published.UTC().Format(time.RFC3339)
+	}
+	sum := sha1.Sum([]byte(d.Title + "\n" + published))
+	return "urn:sha1:" + hex.EncodeToString(sum[:]), false
+}
+
+func encodeRSS(ch Channel, items []Item) (string, error) {
+	if ch.Title == "" || ch.Link == "" {
+		return "", fmt.Errorf("an RSS channel needs a title and a link")
+	}
+	entries, updated, err := prepare(ch, items)
+	if err != nil {
+		return "", err
+	}
+
+	rss := &tree.Node{Kind: tree.ElementNode, Local: "rss"}
+	attr(rss, "version", "2.0")
+	namespaces := map[string]string{"atom": atomNamespace, "content": contentNamespace, "dc": dcNamespace}
+	used := map[string]bool{"atom": ch.Self != ""}
+
+	channel := element(rss, "channel", "")
+	element(channel, "title", ch.Title)
+	element(channel, "link", ch.Link)
+	description := ch.Description
+	if description == "" {
+		// Required, and readers show nothing better than the title.
+		description = ch.Title
+	}
+	element(channel, "description", description)
+	optional(channel, "language", ch.Language)
+	if strings.Contains(ch.Author, "@") {
+		element(channel, "managingEditor", ch.Author)
+	}
+	element(channel, "lastBuildDate", updated.Format(time.RFC1123Z))
+	if ch.Self != "" {
+		self := element(channel, "atom:link", "")
+		attr(self, "href", ch.Self)
+		attr(self, "rel", "self")
+		attr(self, "type", "application/rss+xml")
+	}
+
+	for i, d := range entries {
+		item := element(channel, "item", "")
+		optional(item, "title", d.Title)
+		optional(item, "link", d.Link)
+		optional(item, "description", d.Summary)
+		if d.Content != "" {
+			element(item, "content:encoded", d.Content)
+			used["content"] = true
+		}
+		// RSS's author is an email address; a name goes in dc:creator.
+		switch {
+		case strings.Contains(d.Author, "@"):
+			element(item, "author", d.Author)
+		case d.Author != "":
+			element(item, "dc:creator", d.Author)
+			used["dc"] = true
+		}
+		for _, category := range d.Categories {
+			element(item, "category", category)
+		}
+		switch len(d.Enclosures) {
+		case 0:
+		case 1:
+			e := d.Enclosures[0]
+			enclosure := element(item, "enclosure", "")
+			attr(enclosure, "url", e.URL)
+			attr(enclosure, "length", strconv.FormatInt(e.Length, 10))
+			attr(enclosure, "type", e.Type)
+		default:
+			return "", fmt.Errorf("item %d: RSS allows one enclosure per item; use Atom for more", i)
+		}
+		id, permalink := d.id()
+		guid := element(item, "guid", id)
+		attr(guid, "isPermaLink", strconv.FormatBool(permalink))
+		switch {
+		case !d.published.IsZero():
+			element(item, "pubDate", d.published.Format(time.RFC1123Z))
+		case !d.updated.IsZero():
+			element(item, "pubDate", d.updated.Format(time.RFC1123Z))
+		}
+	}
+
+	// Only the namespaces the feed uses are declared.
+	for _, prefix := range []string{"atom", "content", "dc"} {
+		if used[prefix] {
+			rss.Attrs = append(rss.Attrs, tree.Attr{Prefix: "xmlns", Local: prefix, Value: namespaces[prefix]})
+		}
+	}
+	return write(rss)
+}
+
+func encodeAtom(ch Channel, items []Item) (string, error) {
+	if ch.Title == "" {
+		return "", fmt.Errorf("an Atom feed needs a title")
+	}
+	feedID := ch.ID
+	for _, fallback := range []string{ch.Self, ch.Link} {
+		if feedID == "" {
+			feedID = fallback
+		}
+	}
+	if feedID == "" {
+		return "", fmt.Errorf("an Atom feed needs an id, a self link or a link")
+	}
+	entries, updated, err := prepare(ch, items)
+	if err != nil {
+		return "", err
+	}
+
+	feed := &tree.Node{Kind: tree.ElementNode, Local: "feed"}
+	attr(feed, "xmlns", atomNamespace)
+	if ch.Language != "" {
+		attr(feed, "xml:lang", ch.Language)
+	}
+	element(feed, "id", feedID)
+	element(feed, "title", ch.Title)
+	optional(feed, "subtitle", ch.Description)
+	link(feed, "alternate", ch.Link)
+	link(feed, "self", ch.Self)
+	element(feed, "updated", updated.Format(time.RFC3339))
+	if ch.Author != "" {
+		element(element(feed, "author", ""), "name", ch.Author)
+	}
+
+	for _, d := range entries {
+		entry := element(feed, "entry", "")
+		id, _ := d.id()
+		element(entry, "id", id)
+		element(entry, "title", d.Title)
+		link(entry, "alternate", d.Link)
+		if !d.published.IsZero() {
+			element(entry, "published", d.published.Format(time.RFC3339))
+		}
+		// Atom requires updated; an item that never changed was last
+		// updated when it was published.
+		entryUpdated := d.updated
+		if entryUpdated.IsZero() {
+			entryUpdated = d.published
+		}
+		if entryUpdated.IsZero() {
+			entryUpdated = updated
+		}
+		element(entry, "updated", entryUpdated.Format(time.RFC3339))
+		if d.Author != "" {
+			element(element(entry, "author", ""), "name", d.Author)
+		}
+		for _, category := range d.Categories {
+			attr(element(entry, "category", ""), "term", category)
+		}
+		for _, e := range d.Enclosures {
+			enclosure := element(entry, "link", "")
+			attr(enclosure, "rel", "enclosure")
+			attr(enclosure, "href", e.URL)
+			if e.Type != "" {
+				attr(enclosure, "type", e.Type)
+			}
+			if e.Length > 0 {
+				attr(enclosure, "length", strconv.FormatInt(e.Length, 10))
+			}
+		}
+		if d.Summary != "" {
+			attr(element(entry, "summary", d.Summary), "type", "html")
+		}
+		if d.Content != "" {
+			attr(element(entry, "content", d.Content), "type", "html")
+		}
+	}
+	return write(feed)
+}
+
+func link(parent *tree.Node, rel, href string) {
+	if href == "" {
+		return
+	}
+	l := element(parent, "link", "")
+	attr(l, "rel", rel)
+	attr(l, "href", href)
+}
@@ -0,0 +1,229 @@
+// Package feed writes and reads RSS 2.0 and Atom 1.0 feeds.
+//
+// Changelogs and status pages are published as feeds, and vendor alerts
+// arrive as them. Writing one by template gets the dates and GUIDs subtly
+// wrong — readers then show every item as new — and reading one with
+// xml_decode leaves the flow to handle two formats and everything publishers
+// do to them. Both directions go through one item shape, so a flow neither
+// knows nor cares which format is on the wire.
+package feed
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "feed"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+
+	ModeEncode = "encode"
+	ModeDecode = "decode"
+
+	FormatRSS  = "rss"
+	FormatAtom = "atom"
+)
+
+type Context any
+
+// Channel describes the feed as a whole.
+type Channel struct {
+	Title       string `json:"title" title:"Title"`
+	Link        string `json:"link" title:"Link" description:"The site the feed is for."`
+	Self        string `json:"self,omitempty" title:"Self Link" description:"Where the feed itself is published. Readers use it to find the feed again; validators warn without it."`
+	Description string `json:"description,omitempty" title:"Description" description:"RSS's description, Atom's subtitle."`
+	ID          string `json:"id,omitempty" title:"ID" description:"Atom's feed id. When encoding, defaults to the self link, then the link."`
+	Updated     string `json:"updated,omitempty" title:"Updated" description:"RFC 3339. When encoding, defaults to the latest item date."`
+	Language    string `json:"language,omitempty" title:"Language" description:"Such as en-us."`
+	Author      string `json:"author,omitempty" title:"Author"`
+}
+
+// Item is one item or entry, in the same shape whichever format it is in.
+type Item struct {
+	Title      string      `json:"title" title:"Title" description:"Plain text. When decoding, HTML some publishers put in titles is removed."`
+	Link       string      `json:"link,omitempty" title:"Link"`
+	ID         string      `json:"id,omitempty" title:"ID" description:"RSS's guid, Atom's id: what readers tell items apart by, so it must never change. When encoding, defaults to the link, then to a hash of the title and date."`
+	Published  string      `json:"published,omitempty" title:"Published" description:"RFC 3339, such as 2024-03-01T12:00:00Z. When decoding, empty if the feed has no date or one that cannot be read."`
+	Updated    string      `json:"updated,omitempty" title:"Updated" description:"RFC 3339."`
+	Summary    string      `json:"summary,omitempty" title:"Summary" description:"HTML. RSS's description, Atom's summary."`
+	Content    string      `json:"content,omitempty" title:"Content" description:"HTML. RSS's content:encoded, Atom's content."`
+	Author     string      `json:"author,omitempty" title:"Author"`
+	Categories []string    `json:"categories,omitempty" title:"Categories"`
+	Enclosures []Enclosure `json:"enclosures,omitempty" title:"Enclosures" description:"Attached media, such as a podcast's audio."`
+}
+
+// Enclosure is a media file attached to an item.
+type Enclosure struct {
+	URL    string `json:"url" title:"URL"`
+	Type   string `json:"type,omitempty" title:"Type" description:"The media type, such as audio/mpeg."`
+	Length int64  `json:"length,omitempty" title:"Length" description:"In bytes."`
+}
+
+// Request is what encode mode takes.
+type Request struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the feed"`
+	Channel Channel `json:"channel" required:"true" title:"Channel"`
+	Items   []Item  `json:"items" title:"Items" description:"Newest first, as readers expect."`
+}
+
+// Response is what encode mode emits.
+type Response struct {
+	Context     Context `json:"context,omitempty" title:"Context"`
+	Encoded     string  `json:"encoded" title:"Feed" description:"The feed XML."`
+	ContentType string  `json:"contentType" title:"Content Type" description:"The media type to serve it with."`
+}
+
+// DecodeRequest is what decode mode takes.
+type DecodeRequest struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the items"`
+	Encoded string  `json:"encoded" required:"true" format:"textarea" title:"Feed" description:"An RSS 2.0 or Atom 1.0 feed."`
+}
+
+// DecodeResponse is what decode mode emits.
+type DecodeResponse struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Format  string  `json:"format" title:"Format" description:"rss or atom."`
+	Channel Channel `json:"channel" title:"Channel"`
+	Items   []Item  `json:"items" title:"Items"`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+type Settings struct {
+	Mode   string `json:"mode" default:"encode" enum:"encode,decode" enumTitles:"Encode|Decode" title:"Mode" description:"Encode writes a channel and items as a feed. Decode reads an RSS or Atom feed into the same channel and items."`
+	Format string `json:"format" default:"rss" enum:"rss,atom" enumTitles:"RSS 2.0|Atom 1.0" title:"Format" description:"Encode: the format to write. Decode reads either."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "Feed",
+		Info: "Encode mode writes a channel and its items as an RSS 2.0 or Atom 1.0 feed, with dates in the format each " +
+			"requires and a stable id for every item: its id, else its link, else a hash of its title and date. " +
+			"Give dates as RFC 3339, such as 2024-03-01T12:00:00Z. " +
+			"Decode mode reads either format into the same channel and items — title, link, id, published, updated, " +
+			"summary, content, author, categories and enclosures — with dates as RFC 3339. It accepts what real feeds " +
+			"contain: dates in the wrong format or none at all, HTML and entities in titles, and markup errors. " +
+			"Use an item's id to tell which items a flow has already seen.",
+		Tags: []string{"xml", "rss", "atom"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+
+	switch in := msg.(type) {
+	case Request:
+		var out Response
+		var err error
+		switch c.settings.Format {
+		case "", FormatRSS:
+			out.Encoded, err = encodeRSS(in.Channel, in.Items)
+			out.ContentType = "application/rss+xml; charset=utf-8"
+		case FormatAtom:
+			out.Encoded, err = encodeAtom(in.Channel, in.Items)
+			out.ContentType = "application/atom+xml; charset=utf-8"
+		default:
+			err = fmt.Errorf("unknown format %q: use rss or atom", c.settings.Format)
+		}
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		out.Context = in.Context
+		return handler(ctx, ResponsePort, out)
+
+	case DecodeRequest:
+		out, err := decode([]byte(in.Encoded))
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		out.Context = in.Context
+		return handler(ctx, ResponsePort, out)
+	}
+	return module.Fail(fmt.Errorf("invalid message"))
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+func (c *Component) Ports() []module.Port {
+	var request, response any = Request{}, Response{}
+	if c.settings.Mode == ModeDecode {
+		request, response = DecodeRequest{}, DecodeResponse{}
+	}
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: request,
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: response,
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
@@ -0,0 +1,328 @@
+package feed
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in any, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func encode(t *testing.T, in Request, format string) Response {
+	t.Helper()
+	in.Context = "ctx"
+	port, msg, err := run(t, in, Settings{Format: format})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	out := msg.(Response)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out
+}
+
+func decoded(t *testing.T, feed string) DecodeResponse {
+	t.Helper()
+	port, msg, err := run(t, DecodeRequest{Context: "ctx", Encoded: feed}, Settings{Mode: ModeDecode})
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	out := msg.(DecodeResponse)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out
+}
+
+var channel = Channel{Title: "Status", Link: "https://status.example.com/", Self: "https://status.example.com/feed"}
+
+var items = []Item{
+	{
+		Title:      "Degraded API",
+		Link:       "https://status.example.com/incidents/2",
+		Published:  "2024-03-02T09:30:00+01:00",
+		Summary:    "<p>Elevated error rates.</p>",
+		Author:     "Ops Team",
+		Categories: []string{"api"},
+	},
+	{
+		Title:     "Maintenance",
+		Published: "2024-03-01T12:00:00Z",
+		Content:   "<p>Database upgrade.</p>",
+	},
+}
+
+func TestEncodeRSS(t *testing.T) {
+	out := encode(t, Request{Channel: channel, Items: items}, FormatRSS)
+	if out.ContentType != "application/rss+xml; charset=utf-8" {
+		t.Fatalf("content type = %q", out.ContentType)
+	}
+	for _, want := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		`<description>Status</description>`,
+		`<lastBuildDate>Sat, 02 Mar 2024 09:30:00 +0100</lastBuildDate>`,
+		`<atom:link href="https://status.example.com/feed" rel="self" type="application/rss+xml"/>`,
+		`<description>&lt;p&gt;Elevated error rates.&lt;/p&gt;</description>`,
+		`<dc:creator>Ops Team</dc:creator>`,
+		`<guid isPermaLink="true">https://status.example.com/incidents/2</guid>`,
+		`<pubDate>Fri, 01 Mar 2024 12:00:00 +0000</pubDate>`,
+		`<content:encoded>&lt;p&gt;Database upgrade.&lt;/p&gt;</content:encoded>`,
+		`<guid isPermaLink="false">urn:sha1:`,
+	} {
+		if !strings.Contains(out.Encoded, want) {
+			t.Fatalf("missing %s in\n%s", want, out.Encoded)
+		}
+	}
+}
+
+// Readers show an item as new whenever its id changes, so an item without a
+// link must get the same id every time it is written.
+func TestEncodeIDIsStable(t *testing.T) {
+	id := func(published string) string {
+		d := dated{Item: Item{Title: "Maintenance"}}
+		d.published, _ = parseDate(published)
+		id, _ := d.id()
+		return id
+	}
+	if id("2024-03-01T12:00:00Z") != id("Fri, 01 Mar 2024 13:00:00 +0100") {
+		t.Fatal("the same instant in another format gave another id")
+	}
+	if id("2024-03-01T12:00:00Z") == id("2024-03-02T12:00:00Z") {
+		t.Fatal("another date gave the same id")
+	}
+}
+
+func TestEncodeAtom(t *testing.T) {
+	out := encode(t, Request{Channel: channel, Items: items}, FormatAtom)
+	if out.ContentType != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("content type = %q", out.ContentType)
+	}
+	for _, want := range []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom">`,
+		`<id>https://status.example.com/feed</id>`,
+		`<link rel="alternate" href="https://status.example.com/"/>`,
+		`<updated>2024-03-02T09:30:00+01:00</updated>`,
+		`<published>2024-03-01T12:00:00Z</published>`,
+		`<updated>2024-03-01T12:00:00Z</updated>`,
+		`<category term="api"/>`,
+		`<summary type="html">&lt;p&gt;Elevated error rates.&lt;/p&gt;</summary>`,
+		`<author>`,
+	} {
+		if !strings.Contains(out.Encoded, want) {
+			t.Fatalf("missing %s in\n%s", want, out.Encoded)
+		}
+	}
+}
+
+// A feed with no dates at all is stamped with the time it was written.
+func TestEncodeWithoutDates(t *testing.T) {
+	defer func(saved func() time.Time) { now = saved }(now)
+	now = func() time.Time { return time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC) }
+
+	out := encode(t, Request{Channel: channel, Items: []Item{{Title: "Hello"}}}, FormatAtom)
+	if strings.Count(out.Encoded, "<updated>2024-05-01T08:00:00Z</updated>") != 2 {
+		t.Fatalf("got\n%s", out.Encoded)
+	}
+	if strings.Contains(out.Encoded, "<published>") {
+		t.Fatalf("an item without a date was given one:\n%s", out.Encoded)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := []Item{{
+		Title:      "Release 1.2 & more",
+		Link:       "https://example.com/1.2",
+		ID:         "tag:example.com,2024:1.2",
+		Published:  "2024-03-01T12:00:00Z",
+		Updated:    "2024-03-02T12:00:00Z",
+		Summary:    "<b>New</b>",
+		Content:    "<p>Details</p>",
+		Author:     "dev@example.com",
+		Categories: []string{"release", "go"},
+		Enclosures: []Enclosure{{URL: "https://example.com/1.2.mp3", Type: "audio/mpeg", Length: 1024}},
+	}}
+	for _, format := range []string{FormatRSS, FormatAtom} {
+		t.Run(format, func(t *testing.T) {
+			out := decoded(t, encode(t, Request{Channel: channel, Items: in}, format).Encoded)
+			if out.Format != format {
+				t.Fatalf("format = %q", out.Format)
+			}
+			want := in[0]
+			if format == FormatRSS {
+				// RSS has no item updated date.
+				want.Updated = ""
+			}
+			if !reflect.DeepEqual(out.Items, []Item{want}) {
+				t.Fatalf("got  %+v\nwant %+v", out.Items, []Item{want})
+			}
+			if out.Channel.Title != channel.Title || out.Channel.Link != channel.Link || out.Channel.Self != channel.Self {
+				t.Fatalf("channel = %+v", out.Channel)
+			}
+		})
+	}
+}
+
+// What real feeds contain: an undeclared entity, markup in titles, a named
+// zone, a date nothing can read and items with only a guid.
+func TestDecodeRealWorldRSS(t *testing.T) {
+	out := decoded(t, `<rss version="2.0"><channel>
+		<title>Vendor&nbsp;Alerts</title><link>https://vendor.example/</link>
+		<item>
+			<title>&lt;b&gt;Critical&lt;/b&gt; patch &amp;amp; notes</title>
+			<guid>https://vendor.example/a/1</guid>
+			<pubDate>Tue, 5 Mar 2024 10:00:00 EST</pubDate>
+		</item>
+		<item>
+			<title>Notice</title>
+			<guid isPermaLink="false">notice-7</guid>
+			<pubDate>sometime last week</pubDate>
+		</item>
+		<item><title>Undated</title></item>
+	</channel></rss>`)
+
+	if out.Channel.Title != "Vendor Alerts" {
+		t.Fatalf("channel title = %q", out.Channel.Title)
+	}
+	first := out.Items[0]
+	if first.Title != "Critical patch & notes" || first.Link != "https://vendor.example/a/1" ||
+		first.Published != "2024-03-05T10:00:00-05:00" {
+		t.Fatalf("first = %+v", first)
+	}
+	if second := out.Items[1]; second.ID != "notice-7" || second.Link != "" || second.Published != "" {
+		t.Fatalf("second = %+v", second)
+	}
+	if third := out.Items[2]; !strings.HasPrefix(third.ID, "urn:sha1:") || third.Published != "" {
+		t.Fatalf("third = %+v", third)
+	}
+}
+
+// Feeds often use media: or itunes: without declaring it. The elements are
+// of no use then, but the feed around them still reads.
+func TestDecodeUndeclaredPrefix(t *testing.T) {
+	out := decoded(t, `<rss version="2.0"><channel><title>Pods</title>
+		<item><title>Episode 1</title><media:content url="https://pods.example/1.mp3"/></item>
+	</channel></rss>`)
+	if out.Channel.Title != "Pods" || len(out.Items) != 1 || out.Items[0].Title != "Episode 1" {
+		t.Fatalf("got %+v", out)
+	}
+}
+
+func TestDecodeAtom(t *testing.T) {
+	out := decoded(t, `<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en">
+		<title type="html">News &amp;amp; Notes</title>
+		<id>urn:uuid:60a76c80</id>
+		<updated>2024-03-01T12:00:00Z</updated>
+		<author><name>Jane</name></author>
+		<link href="https://example.com/"/>
+		<entry>
+			<id>urn:uuid:1225c695</id>
+			<title>First</title>
+			<link rel="alternate" href="https://example.com/first"/>
+			<updated>2024-03-01T12:00:00Z</updated>
+			<summary>1 &lt; 2</summary>
+			<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Hello <em>there</em></p></div></content>
+		</entry>
+	</feed>`)
+
+	want := Channel{Title: "News & Notes", Link: "https://example.com/", ID: "urn:uuid:60a76c80",
+		Updated: "2024-03-01T12:00:00Z", Language: "en", Author: "Jane"}
+	if out.Format != FormatAtom || out.Channel != want {
+		t.Fatalf("channel = %+v", out.Channel)
+	}
+	item := out.Items[0]
+	if item.Author != "Jane" || item.Summary != "1 &lt; 2" || item.Link != "https://example.com/first" {
+		t.Fatalf("item = %+v", item)
+	}
+	if !strings.Contains(item.Content, "<em>there</em>") || strings.Contains(item.Content, "<div") {
+		t.Fatalf("content = %q", item.Content)
+	}
+}
+
+func TestDecodeRSS1(t *testing.T) {
+	out := decoded(t, `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+		xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<channel rdf:about="https://example.com/"><title>Old</title><link>https://example.com/</link></channel>
+		<item rdf:about="https://example.com/1">
+			<title>One</title><link>https://example.com/1</link><dc:date>2024-03-01</dc:date>
+		</item>
+	</rdf:RDF>`)
+
+	if out.Format != FormatRSS || out.Channel.Title != "Old" || len(out.Items) != 1 {
+		t.Fatalf("got %+v", out)
+	}
+	if item := out.Items[0]; item.ID != "https://example.com/1" || item.Published != "2024-03-01T00:00:00Z" {
+		t.Fatalf("item = %+v", item)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for in, want := range map[string]string{
+		"Mon, 02 Jan 2006 15:04:05 GMT":      "2006-01-02T15:04:05Z",
+		"Mon, 2 Jan 2006 15:04:05 -0700":     "2006-01-02T15:04:05-07:00",
+		"2 Jan 2006 15:04 PST":               "2006-01-02T15:04:00-08:00",
+		"Monday, 02 Jan 2006 15:04:05 +0000": "2006-01-02T15:04:05Z",
+		"2006-01-02T15:04:05.5+02:00":        "2006-01-02T15:04:05+02:00",
+		"2006-01-02T15:04:05":                "2006-01-02T15:04:05Z",
+		"2006-01-02 15:04:05":                "2006-01-02T15:04:05Z",
+		"  2006-01-02 ":                      "2006-01-02T00:00:00Z",
+		"yesterday":                          "",
+	} {
+		if got := rfc3339(in); got != want {
+			t.Errorf("rfc3339(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in       any
+		settings Settings
+		want     string
+	}{
+		"not a feed": {DecodeRequest{Encoded: `<html><body/></html>`}, Settings{Mode: ModeDecode}, "not an RSS or Atom feed"},
+		"not xml":    {DecodeRequest{Encoded: `{"items": []}`}, Settings{Mode: ModeDecode}, ""},
+		"no link":    {Request{Channel: Channel{Title: "T"}}, Settings{}, "needs a title and a link"},
+		"no atom id": {Request{Channel: Channel{Title: "T"}}, Settings{Format: FormatAtom}, "needs an id"},
+		"empty item": {Request{Channel: channel, Items: []Item{{Link: "https://x"}}}, Settings{}, "item 0 needs a title"},
+		"bad date": {Request{Channel: channel, Items: []Item{{Title: "T", Published: "soon"}}}, Settings{},
+			`item 0: published "soon" is not a date`},
+		"two enclosures": {Request{Channel: channel, Items: []Item{{Title: "T",
+			Enclosures: []Enclosure{{URL: "https://a"}, {URL: "https://b"}}}}}, Settings{}, "one enclosure per item"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := run(t, tc.in, tc.settings)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+
+			tc.settings.EnableErrorPort = true
+			port, msg, err := run(t, tc.in, tc.settings)
+			if err != nil || port != ErrorPort || msg.(Error).Error == "" {
+				t.Fatalf("port = %q, msg = %v, err = %v", port, msg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+// Package query pulls values out of an XML document with XPath.
+//
+// Most XML a flow receives is a response it needs three values from. Decoding
+// the whole document and navigating the maps breaks on exactly what such
+// responses vary in: the prefixes a partner picks, and whether an element
+// happens to repeat. An XPath expression names what it wants by namespace
+// and position, and says whether it found it — which the maps cannot, since
+// a missing element and an empty one both read as "".
+package query
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+	"github.com/tiny-systems/encoding-module/components/xml/xpath"
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "xml_query"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+)
+
+// What a query's result is turned into.
+const (
+	AsAuto    = "auto"
+	AsString  = "string"
+	AsNumber  = "number"
+	AsBoolean = "boolean"
+	AsStrings = "strings"
+	AsNodes   = "nodes"
+)
+
+type Context any
+
+// Results holds each query's result under its name.
+type Results map[string]any
+
+type Request struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the results"`
+	Encoded string  `json:"encoded" required:"true" format:"textarea" title:"XML" description:"The XML document to query."`
+}
+
+type Response struct {
+	Context Context  `json:"context,omitempty" configurable:"true" title:"Context"`
+	Results Results  `json:"results" title:"Results" description:"Each query's result under its name."`
+	Missing []string `json:"missing" title:"Missing" description:"The queries that selected no node, so a missing element can be told from an empty one."`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" configurable:"true" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+}
+
+// Node is a selected node, for queries returning nodes.
+type Node struct {
+	Kind       string            `json:"kind" title:"Kind" description:"element, attribute, text, comment or processing-instruction."`
+	Name       string            `json:"name" title:"Name" description:"The name as the document wrote it, prefix included."`
+	Namespace  string            `json:"namespace" title:"Namespace" description:"The namespace URI of an element or attribute."`
+	Text       string            `json:"text" title:"Text" description:"The text of the node and everything in it."`
+	Attributes map[string]string `json:"attributes,omitempty" title:"Attributes" description:"An element's attributes by name as written, namespace declarations left out."`
+	XML        string            `json:"xml,omitempty" title:"XML" description:"An element as a document of its own, with the namespace declarations it needs, for xml_decode or another query."`
+}
+
+type Query struct {
+	Name  string `json:"name" required:"true" title:"Name" description:"The key the result is under."`
+	XPath string `json:"xpath" required:"true" title:"XPath" description:"An XPath 1.0 expression, such as /s:Envelope/s:Body/r:GetRateResponse/r:Rate or count(//item)."`
+	As    string `json:"as" default:"auto" enum:"auto,string,number,boolean,strings,nodes" enumTitles:"Auto|String|Number|Boolean|List of strings|List of nodes" title:"Result" description:"Auto: the first selected node's text, or null when nothing is selected; an expression such as count() keeps its own type. String, Number and Boolean convert the result as XPath's string(), number() and boolean() do, except that selecting nothing is null, not \"\" or NaN. List of strings and List of nodes return every selected node."`
+}
+
+// Namespace binds a prefix to a namespace URI.
+type Namespace struct {
+	Prefix string `json:"prefix" required:"true" title:"Prefix" description:"The prefix the expressions use, such as s. It need not be the one the document uses."`
+	URI    string `json:"uri" required:"true" title:"URI"`
+}
+
+type Settings struct {
+	Queries []Query `json:"queries" required:"true" title:"Queries" description:"The values to pull out, each named and selected with an XPath expression."`
+
+	// XPath 1.0 has no default namespace for expressions: an unprefixed name
+	// is in no namespace, so every namespaced element needs a prefix here.
+	Namespaces []Namespace `json:"namespaces" title:"Namespace Prefixes" description:"Prefixes the expressions use. An unprefixed name in an expression only matches elements in no namespace — in a document with xmlns=\"…\" on its root, bind a prefix to that URI and use it, or match on local-name()."`
+
+	Lenient bool `json:"lenient" title:"Lenient" description:"Accept HTML entities such as &nbsp;, attributes without values and mismatched end tags. Off (default): those are errors."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors to the error port instead of failing the run."`
+}
+
+type compiled struct {
+	Query
+	expr *xpath.Expr
+}
+
+type Component struct {
+	module.Base
+	settings   Settings
+	queries    []compiled
+	compileErr error
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "XML Query",
+		Info: "Selects values from an XML document with named XPath 1.0 expressions and outputs {results: {name: value}}. " +
+			"With the prefix s bound to http://schemas.xmlsoap.org/soap/envelope/ and r to the service's namespace, " +
+			"/s:Envelope/s:Body/r:GetRateResponse/r:Rate outputs the rate's text, //r:Rate/@currency its currency and " +
+			"count(//r:Rate) how many there are. " +
+			"A query that selects nothing outputs null and is listed in missing; an element that is there but empty " +
+			"outputs \"\". Set a query's result to List of nodes for every match with its name, text, attributes and XML. " +
+			"An unprefixed name matches only elements in no namespace, so a document with a default namespace needs a " +
+			"prefix bound to it. Prefixes are matched by URI, so the document may use different ones.",
+		Tags: []string{"xml"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	c.queries, c.compileErr = compile(in)
+	return nil
+}
+
+func compile(s Settings) ([]compiled, error) {
+	namespaces := make(map[string]string, len(s.Namespaces))
+	for _, ns := range s.Namespaces {
+		namespaces[ns.Prefix] = ns.URI
+	}
+	seen := map[string]bool{}
+	queries := make([]compiled, 0, len(s.Queries))
+	for _, q := range s.Queries {
+		if q.Name == "" {
+			return nil, fmt.Errorf("query %q has no name", q.XPath)
+		}
+		if seen[q.Name] {
+			return nil, fmt.Errorf("two queries are named %q", q.Name)
+		}
+		seen[q.Name] = true
+		switch q.As {
+		case "":
+			q.As = AsAuto
+		case AsAuto, AsString, AsNumber, AsBoolean, AsStrings, AsNodes:
+		default:
+			return nil, fmt.Errorf("query %s: unknown result %q", q.Name, q.As)
+		}
+		e, err := xpath.Compile(q.XPath, namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("query %s: %w", q.Name, err)
+		}
+		if (q.As == AsStrings || q.As == AsNodes) && e.Type() != xpath.NodeSetType {
+			return nil, fmt.Errorf("query %s: %s returns a %s, not nodes", q.Name, q.XPath, e.Type())
+		}
+		queries = append(queries, compiled{Query: q, expr: e})
+	}
+	return queries, nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+	in, ok := msg.(Request)
+	if !ok {
+		return module.Fail(fmt.Errorf("invalid message"))
+	}
+	if c.compileErr != nil {
+		return c.handleError(ctx, handler, in.Context, c.compileErr)
+	}
+
+	doc, err := tree.Parse([]byte(in.Encoded), tree.ParseOptions{Lenient: c.settings.Lenient})
+	if err != nil {
+		return c.handleError(ctx, handler, in.Context, err)
+	}
+	out := Response{Context: in.Context, Results: Results{}, Missing: []string{}}
+	for _, q := range c.queries {
+		v, err := q.expr.Evaluate(doc)
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, fmt.Errorf("query %s: %w", q.Name, err))
+		}
+		nodes, isSet := v.([]xpath.Node)
+		if isSet && len(nodes) == 0 {
+			out.Missing = append(out.Missing, q.Name)
+		}
+		if out.Results[q.Name], err = result(q.As, v); err != nil {
+			return c.handleError(ctx, handler, in.Context, fmt.Errorf("query %s: %w", q.Name, err))
+		}
+	}
+	return handler(ctx, ResponsePort, out)
+}
+
+// result converts an expression's value as the query asks. Selecting nothing
+// is null for the scalar results, so it cannot pass for a value.
+func result(as string, v any) (any, error) {
+	nodes, isSet := v.([]xpath.Node)
+	switch as {
+	case AsStrings:
+		texts := make([]string, len(nodes))
+		for i, n := range nodes {
+			texts[i] = n.Text()
+		}
+		return texts, nil
+	case AsNodes:
+		out := make([]Node, len(nodes))
+		for i, n := range nodes {
+			var err error
+			if out[i], err = node(n); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case AsBoolean:
+		return xpath.Boolean(v), nil
+	}
+	if isSet && len(nodes) == 0 {
+		return nil, nil
+	}
+	switch as {
+	case AsString:
+		return xpath.String(v), nil
+	case AsNumber:
+		return number(xpath.Number(v)), nil
+	}
+	switch val := v.(type) {
+	case []xpath.Node:
+		return xpath.String(val), nil
+	case float64:
+		return number(val), nil
+	}
+	return v, nil
+}
+
+// number is f, or null for the NaN and infinities JSON cannot hold.
+func number(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+func node(n xpath.Node) (Node, error) {
+	out := Node{Kind: n.Kind(), Name: n.Name(), Namespace: n.Namespace(), Text: n.Text()}
+	el := n.Node()
+	if out.Kind != "element" {
+		return out, nil
+	}
+	for _, a := range el.Attrs {
+		if a.IsNamespaceDecl() {
+			continue
+		}
+		if out.Attributes == nil {
+			out.Attributes = map[string]string{}
+		}
+		out.Attributes[a.Name()] = a.Value
+	}
+	var b bytes.Buffer
+	if err := tree.Write(&b, tree.Detach(el), tree.WriteOptions{}); err != nil {
+		return Node{}, err
+	}
+	out.XML = b.String()
+	return out, nil
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	return handler(ctx, ErrorPort, Error{Context: reqCtx, Error: err.Error()})
+}
+
+// results is an example of the results, one key per query, so the response
+// port shows what the queries produce.
+func (c *Component) results() Results {
+	out := Results{}
+	for _, q := range c.queries {
+		switch q.As {
+		case AsString:
+			out[q.Name] = ""
+		case AsNumber:
+			out[q.Name] = 0.0
+		case AsBoolean:
+			out[q.Name] = false
+		case AsStrings:
+			out[q.Name] = []string{}
+		case AsNodes:
+			out[q.Name] = []Node{{}}
+		default:
+			switch q.expr.Type() {
+			case xpath.NumberType:
+				out[q.Name] = 0.0
+			case xpath.BooleanType:
+				out[q.Name] = false
+			default:
+				out[q.Name] = ""
+			}
+		}
+	}
+	return out
+}
+
+func (c *Component) Ports() []module.Port {
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: Request{},
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: Response{Results: c.results()},
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
@@ -0,0 +1,178 @@
+package query
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tiny-systems/module/module"
+)
+
+const rates = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ns1="urn:rates">
+  <soapenv:Body>
+    <ns1:GetRateResponse>
+      <ns1:Rate currency="EUR">1.0842</ns1:Rate>
+      <ns1:Rate currency="GBP">0.8571</ns1:Rate>
+      <ns1:Note/>
+    </ns1:GetRateResponse>
+  </soapenv:Body>
+</soapenv:Envelope>`
+
+// The expressions bind their own prefixes, deliberately unlike the
+// document's.
+var namespaces = []Namespace{
+	{Prefix: "s", URI: "http://schemas.xmlsoap.org/soap/envelope/"},
+	{Prefix: "r", URI: "urn:rates"},
+}
+
+func run(t *testing.T, in Request, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func query(t *testing.T, doc string, queries ...Query) Response {
+	t.Helper()
+	port, msg, err := run(t, Request{Context: "ctx", Encoded: doc}, Settings{Queries: queries, Namespaces: namespaces})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	out := msg.(Response)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out
+}
+
+func TestQuery(t *testing.T) {
+	out := query(t, rates,
+		Query{Name: "rate", XPath: "/s:Envelope/s:Body/r:GetRateResponse/r:Rate"},
+		Query{Name: "currency", XPath: "//r:Rate[2]/@currency"},
+		Query{Name: "count", XPath: "count(//r:Rate)"},
+		Query{Name: "hasGBP", XPath: "//r:Rate[@currency = 'GBP'] > 0.5"},
+		Query{Name: "rates", XPath: "//r:Rate", As: AsNumber},
+		Query{Name: "all", XPath: "//r:Rate", As: AsStrings},
+	)
+	want := Results{
+		"rate":     "1.0842",
+		"currency": "GBP",
+		"count":    2.0,
+		"hasGBP":   true,
+		"rates":    1.0842,
+		"all":      []string{"1.0842", "0.8571"},
+	}
+	if !reflect.DeepEqual(out.Results, want) {
+		t.Fatalf("got %#v", out.Results)
+	}
+	if len(out.Missing) != 0 {
+		t.Fatalf("missing = %v", out.Missing)
+	}
+}
+
+// The point of the component over decoding: an element that is not there is
+// null and listed, one that is there and empty is "".
+func TestMissingIsNotEmpty(t *testing.T) {
+	out := query(t, rates,
+		Query{Name: "note", XPath: "//r:Note"},
+		Query{Name: "error", XPath: "//r:Error"},
+		Query{Name: "errorNumber", XPath: "//r:Error", As: AsNumber},
+		Query{Name: "hasError", XPath: "//r:Error", As: AsBoolean},
+		Query{Name: "errors", XPath: "//r:Error", As: AsNodes},
+	)
+	want := Results{"note": "", "error": nil, "errorNumber": nil, "hasError": false, "errors": []Node{}}
+	if !reflect.DeepEqual(out.Results, want) {
+		t.Fatalf("got %#v", out.Results)
+	}
+	if !reflect.DeepEqual(out.Missing, []string{"error", "errorNumber", "hasError", "errors"}) {
+		t.Fatalf("missing = %v", out.Missing)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	out := query(t, rates,
+		Query{Name: "rates", XPath: "//r:Rate[1] | //r:Rate[1]/@currency", As: AsNodes},
+	)
+	want := []Node{
+		{
+			Kind: "element", Name: "ns1:Rate", Namespace: "urn:rates", Text: "1.0842",
+			Attributes: map[string]string{"currency": "EUR"},
+			XML:        `<ns1:Rate xmlns:ns1="urn:rates" currency="EUR">1.0842</ns1:Rate>`,
+		},
+		{Kind: "attribute", Name: "currency", Text: "EUR"},
+	}
+	if !reflect.DeepEqual(out.Results["rates"], want) {
+		t.Fatalf("got %#v", out.Results["rates"])
+	}
+}
+
+func TestNumbersJSONCannotHold(t *testing.T) {
+	out := query(t, rates,
+		Query{Name: "nan", XPath: "number(//r:Note)"},
+		Query{Name: "inf", XPath: "1 div 0"},
+	)
+	if out.Results["nan"] != nil || out.Results["inf"] != nil {
+		t.Fatalf("got %#v", out.Results)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		doc     string
+		queries []Query
+		want    string
+	}{
+		"unbound prefix": {rates, []Query{{Name: "a", XPath: "//x:Rate"}}, `query a: position 2: prefix "x" is not bound`},
+		"duplicate name": {rates, []Query{{Name: "a", XPath: "1"}, {Name: "a", XPath: "2"}}, `two queries are named "a"`},
+		"nodes of count": {rates, []Query{{Name: "a", XPath: "count(//r:Rate)", As: AsNodes}}, "returns a number, not nodes"},
+		"runtime":        {rates, []Query{{Name: "a", XPath: "sum('1')"}}, "query a: sum(): the argument must be a node-set"},
+		"malformed":      {`<a><b></a>`, []Query{{Name: "a", XPath: "/a"}}, "closed by"},
+	} {
+		settings := Settings{Queries: tc.queries, Namespaces: namespaces, EnableErrorPort: true}
+		port, msg, err := run(t, Request{Context: "ctx", Encoded: tc.doc}, settings)
+		if err != nil || port != ErrorPort {
+			t.Fatalf("%s: port = %q, err = %v", name, port, err)
+		}
+		if e := msg.(Error); e.Context != "ctx" || !strings.Contains(e.Error, tc.want) {
+			t.Errorf("%s: error = %q, want %q", name, e.Error, tc.want)
+		}
+		settings.EnableErrorPort = false
+		if _, _, err := run(t, Request{Encoded: tc.doc}, settings); err == nil {
+			t.Errorf("%s: no error without the error port", name)
+		}
+	}
+}
+
+// The response port shows one key per query, typed as it will be.
+func TestResultsShapeFollowsQueries(t *testing.T) {
+	c := &Component{}
+	_ = c.OnSettings(context.Background(), Settings{Queries: []Query{
+		{Name: "rate", XPath: "//Rate"},
+		{Name: "count", XPath: "count(//Rate)"},
+		{Name: "ok", XPath: "//Rate = 1"},
+		{Name: "rates", XPath: "//Rate", As: AsNodes},
+	}})
+	for _, p := range c.Ports() {
+		if p.Name != ResponsePort {
+			continue
+		}
+		want := Results{"rate": "", "count": 0.0, "ok": false, "rates": []Node{{}}}
+		if got := p.Configuration.(Response).Results; !reflect.DeepEqual(got, want) {
+			t.Fatalf("results = %#v", got)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package soap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tiny-systems/encoding-module/components/json/value"
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+const (
+	Version11 = "1.1"
+	Version12 = "1.2"
+
+	// Envelope11 and Envelope12 are the envelope namespaces, which are how a
+	// reader tells the versions apart.
+	Envelope11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	Envelope12 = "http://www.w3.org/2003/05/soap-envelope"
+
+	envelopePrefix = "soap"
+)
+
+// envelopeNamespace is the namespace of version.
+func envelopeNamespace(version string) (string, error) {
+	switch version {
+	case "", Version11:
+		return Envelope11, nil
+	case Version12:
+		return Envelope12, nil
+	}
+	return "", fmt.Errorf("unknown SOAP version %q: use 1.1 or 1.2", version)
+}
+
+// envelope is an Envelope being built: the element and the namespace its
+// Header and Body are in.
+type envelope struct {
+	root  *tree.Node
+	space string
+}
+
+func newEnvelope(space string, namespaces []Namespace) (*envelope, error) {
+	root := &tree.Node{Kind: tree.ElementNode, Space: space, Prefix: envelopePrefix, Local: "Envelope"}
+	root.Attrs = append(root.Attrs, tree.Attr{Space: tree.XMLNSNamespace, Prefix: "xmlns", Local: envelopePrefix, Value: space})
+	for _, ns := range namespaces {
+		if ns.Prefix == envelopePrefix || ns.Prefix == "" {
+			return nil, fmt.Errorf("namespace prefix %q is reserved", ns.Prefix)
+		}
+		root.Attrs = append(root.Attrs, tree.Attr{Space: tree.XMLNSNamespace, Prefix: "xmlns", Local: ns.Prefix, Value: ns.URI})
+	}
+	return &envelope{root: root, space: space}, nil
+}
+
+// element adds an element in the envelope's namespace to parent.
+func (e *envelope) element(parent *tree.Node, local string) *tree.Node {
+	el := &tree.Node{Kind: tree.ElementNode, Space: e.space, Prefix: envelopePrefix, Local: local}
+	parent.Append(el)
+	return el
+}
+
+// content appends what a Body or Header holds: an XML string, appended as it
+// parses, or a document as xml_encode takes it, each key an element.
+func content(parent *tree.Node, v any, namespace, what string) error {
+	if s, ok := v.(string); ok {
+		return appendXML(parent, s, what)
+	}
+	doc, err := value.Normalize(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s must be an object of elements or an XML string, got %s", what, value.Show(doc))
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		items, repeated := obj[key].([]any)
+		if !repeated {
+			items = []any{obj[key]}
+		}
+		for _, item := range items {
+			_, err := tree.FromValue(item, tree.BuildOptions{RootName: key, Namespace: namespace, Parent: parent})
+			if err != nil {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+		}
+	}
+	return nil
+}
+
+// appendXML appends the elements of an XML fragment. A fragment may hold
+// several elements, so it is parsed inside a wrapper, which declares what is
+// in scope where the elements go: they may use the envelope's prefixes.
+func appendXML(parent *tree.Node, s, what string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	if strings.HasPrefix(s, "<?xml") {
+		// A declaration is only allowed at the very start of a document.
+		if end := strings.Index(s, "?>"); end >= 0 {
+			s = s[end+2:]
+		}
+	}
+	var wrapper strings.Builder
+	wrapper.WriteString("<fragment")
+	seen := map[string]bool{}
+	for m := parent; m != nil; m = m.Parent {
+		for _, a := range m.Attrs {
+			if a.IsNamespaceDecl() && !seen[a.Name()] {
+				seen[a.Name()] = true
+				wrapper.WriteString(" " + a.Name() + `="` + attrEscaper.Replace(a.Value) + `"`)
+			}
+		}
+	}
+	wrapper.WriteString(">" + s + "</fragment>")
+
+	doc, err := tree.Parse([]byte(wrapper.String()), tree.ParseOptions{})
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	for _, el := range doc.Root().Elements() {
+		parent.Append(el)
+	}
+	return nil
+}
+
+var attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;")
+
+// Fault is a SOAP Fault, in SOAP 1.1's terms whichever version sent it.
+type Fault struct {
+	Code     string   `json:"faultcode" title:"Fault Code" description:"Such as soap:Server or soap:Client; a 1.2 fault's Code/Value, such as env:Receiver."`
+	Subcodes []string `json:"subcodes,omitempty" title:"Subcodes" description:"A 1.2 fault's Subcode values, outermost first."`
+	String   string   `json:"faultstring" title:"Fault String" description:"The human-readable explanation; a 1.2 fault's first Reason/Text."`
+	Actor    string   `json:"faultactor,omitempty" title:"Fault Actor" description:"Who caused the fault; a 1.2 fault's Role."`
+	Detail   any      `json:"detail,omitempty" title:"Detail" description:"The application's own detail, decoded like the body."`
+}
+
+func (f *Fault) Error() string {
+	if f.String == "" {
+		return "SOAP fault " + f.Code
+	}
+	return fmt.Sprintf("SOAP fault %s: %s", f.Code, f.String)
+}
+
+// parsed is an envelope taken apart.
+type parsed struct {
+	version string
+	header  *tree.Node
+	body    *tree.Node
+}
+
+func parseEnvelope(doc *tree.Node) (*parsed, error) {
+	root := doc.Root()
+	p := &parsed{}
+	switch root.Space {
+	case Envelope11:
+		p.version = Version11
+	case Envelope12:
+		p.version = Version12
+	default:
+		return nil, fmt.Errorf("not a SOAP envelope: <%s> is in namespace %q", root.Name(), root.Space)
+	}
+	if root.Local != "Envelope" {
+		return nil, fmt.Errorf("not a SOAP envelope: the root element is <%s>", root.Name())
+	}
+	for _, el := range root.Elements() {
+		if el.Space != root.Space {
+			continue
+		}
+		switch el.Local {
+		case "Header":
+			p.header = el
+		case "Body":
+			p.body = el
+		}
+	}
+	if p.body == nil {
+		return nil, fmt.Errorf("the envelope has no Body")
+	}
+	return p, nil
+}
+
+// fault is the Fault the body holds, if it holds one. detail decodes the
+// fault's detail element.
+func (p *parsed) fault(detail func(*tree.Node) any) *Fault {
+	var el *tree.Node
+	for _, c := range p.body.Elements() {
+		if c.Space == p.body.Space && c.Local == "Fault" {
+			el = c
+			break
+		}
+	}
+	if el == nil {
+		return nil
+	}
+	f := &Fault{}
+	if p.version == Version11 {
+		// 1.1 fault children are unqualified.
+		for _, c := range el.Elements() {
+			switch c.Local {
+			case "faultcode":
+				f.Code = strings.TrimSpace(c.Text())
+			case "faultstring":
+				f.String = strings.TrimSpace(c.Text())
+			case "faultactor":
+				f.Actor = strings.TrimSpace(c.Text())
+			case "detail":
+				f.Detail = detail(c)
+			}
+		}
+		return f
+	}
+	for _, c := range el.Elements() {
+		if c.Space != Envelope12 {
+			continue
+		}
+		switch c.Local {
+		case "Code":
+			f.Code, f.Subcodes = code12(c)
+		case "Reason":
+			if texts := c.Elements(); len(texts) > 0 {
+				f.String = strings.TrimSpace(texts[0].Text())
+			}
+		case "Role":
+			f.Actor = strings.TrimSpace(c.Text())
+		case "Detail":
+			f.Detail = detail(c)
+		}
+	}
+	return f
+}
+
+// code12 reads a 1.2 Code: its Value, and the Values of the Subcodes nested
+// in it.
+func code12(code *tree.Node) (string, []string) {
+	var v string
+	var subcodes []string
+	for el := code; el != nil; {
+		var next *tree.Node
+		for _, c := range el.Elements() {
+			switch {
+			case c.Space != Envelope12:
+			case c.Local == "Value" && el == code:
+				v = strings.TrimSpace(c.Text())
+			case c.Local == "Value":
+				subcodes = append(subcodes, strings.TrimSpace(c.Text()))
+			case c.Local == "Subcode":
+				next = c
+			}
+		}
+		el = next
+	}
+	return v, subcodes
+}
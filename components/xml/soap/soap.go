@@ -0,0 +1,336 @@
+// Package soap builds and takes apart SOAP envelopes.
+//
+// SOAP services were reached by writing envelopes in go_template, which gets
+// the namespaces wrong in ways only the server notices, and by decoding the
+// response and hoping it was not a Fault — which arrives as an ordinary 500
+// or even 200 with a body the flow then reads as data. Building the envelope
+// on the XML tree gets the namespaces right by construction, and parsing it
+// turns a Fault into an error.
+package soap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+	"github.com/tiny-systems/module/api/v1alpha1"
+	"github.com/tiny-systems/module/module"
+	"github.com/tiny-systems/module/registry"
+)
+
+const (
+	ComponentName = "soap"
+
+	RequestPort  = "request"
+	ResponsePort = "response"
+	ErrorPort    = "error"
+
+	ModeBuild = "build"
+	ModeParse = "parse"
+)
+
+type Context any
+
+// Body is the decoded body. Like xml_decode's result it carries no shape of
+// its own, so the body setting is where the author says what it holds.
+type Body any
+
+// Request is what build mode takes.
+type Request struct {
+	Context  Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the envelope"`
+	Body     any     `json:"body" required:"true" configurable:"true" title:"Body" description:"What goes in the Body: elements as xml_encode takes them, such as {\"r:GetRate\":{\"r:currency\":\"EUR\"}}, or an XML string, such as xml_encode's output."`
+	Headers  any     `json:"headers,omitempty" configurable:"true" title:"Header Blocks" description:"What goes in the Header besides WS-Security, in the same forms as the body."`
+	Username string  `json:"username,omitempty" configurable:"true" title:"Username" description:"The WS-Security UsernameToken's username."`
+	Password string  `json:"password,omitempty" configurable:"true" format:"password" title:"Password" description:"The WS-Security UsernameToken's password."`
+}
+
+// Response is what build mode emits.
+type Response struct {
+	Context     Context           `json:"context,omitempty" title:"Context"`
+	Envelope    string            `json:"envelope" title:"Envelope" description:"The SOAP envelope, ready to post."`
+	HTTPHeaders map[string]string `json:"httpHeaders" title:"HTTP Headers" description:"The Content-Type the version needs, and the SOAPAction header for 1.1."`
+}
+
+// ParseRequest is what parse mode takes.
+type ParseRequest struct {
+	Context  Context `json:"context,omitempty" configurable:"true" title:"Context" description:"Arbitrary message to be send alongside with the body"`
+	Envelope string  `json:"envelope" required:"true" format:"textarea" title:"Envelope" description:"The SOAP response, 1.1 or 1.2."`
+}
+
+// ParseResponse is what parse mode emits.
+type ParseResponse struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Version string  `json:"version" title:"Version" description:"1.1 or 1.2, by the envelope's namespace."`
+	Body    Body    `json:"body" configurable:"true" title:"Body" description:"The Body's elements decoded as xml_decode does, such as {\"GetRateResponse\":{\"Rate\":\"1.08\"}}."`
+	Headers any     `json:"headers,omitempty" title:"Header Blocks" description:"The Header's elements, decoded the same way."`
+	XML     string  `json:"xml" title:"Body XML" description:"The Body's first element as a document of its own, with the namespace declarations it needs, for xml_query."`
+}
+
+type Error struct {
+	Context Context `json:"context,omitempty" title:"Context"`
+	Error   string  `json:"error" title:"Error"`
+	Fault   *Fault  `json:"fault,omitempty" title:"Fault" description:"Set when the envelope held a SOAP Fault."`
+}
+
+// Namespace binds a prefix to a namespace URI.
+type Namespace struct {
+	Prefix string `json:"prefix" required:"true" title:"Prefix" description:"Such as r or tns."`
+	URI    string `json:"uri" required:"true" title:"URI"`
+}
+
+type Settings struct {
+	Mode string `json:"mode" default:"build" enum:"build,parse" enumTitles:"Build|Parse" title:"Mode" description:"Build wraps a body in an envelope to send. Parse unwraps a response's body, and sends a Fault to the error port."`
+
+	Version    string      `json:"version" default:"1.1" enum:"1.1,1.2" enumTitles:"SOAP 1.1|SOAP 1.2" title:"Version" description:"Build: the envelope version the service's WSDL binds to — soap: for 1.1, soap12: for 1.2. Parse reads either."`
+	Action     string      `json:"action" title:"SOAP Action" description:"Build: the operation's soapAction from the WSDL. Sent as the SOAPAction header for 1.1 and in the Content-Type for 1.2."`
+	Namespace  string      `json:"namespace" title:"Body Namespace" description:"Build: the default namespace of the body and header elements given as data, usually the service's target namespace. Not applied to an XML string."`
+	Namespaces []Namespace `json:"namespaces" title:"Namespace Prefixes" description:"Build: prefixes declared on the envelope, for the body and headers to use, whether given as data or as an XML string."`
+
+	Security     string `json:"security" default:"none" enum:"none,text,digest" enumTitles:"None|UsernameToken, password text|UsernameToken, password digest" title:"WS-Security" description:"Build: adds a wsse:Security header with a UsernameToken from the request's username and password. Digest sends a hash with a nonce instead of the password; use it unless the service only accepts text."`
+	TimestampTTL int    `json:"timestampTTL" title:"Timestamp TTL" description:"Build: with WS-Security, adds a wsu:Timestamp expiring this many seconds after it is created. 0 leaves it out."`
+	Declaration  bool   `json:"declaration" title:"XML Declaration" description:"Build: write <?xml version=\"1.0\" encoding=\"UTF-8\"?> first."`
+
+	// Local names by default, unlike xml_decode: a response's prefixes are
+	// the server's choice, and the same service answers with ns1: one day
+	// and ns2: the next.
+	Names      string   `json:"names" default:"local" enum:"local,prefixed,expanded" enumTitles:"Local name (GetRateResponse)|As written (ns1:GetRateResponse)|Namespace URI ({urn:rates}GetRateResponse)" title:"Element Names" description:"Parse: how the body's elements are keyed, as in xml_decode."`
+	ForceArray []string `json:"forceArray" title:"Always Arrays" description:"Parse: elements always decoded as an array, even when there is only one, as in xml_decode."`
+	Lenient    bool     `json:"lenient" title:"Lenient" description:"Parse: accept HTML entities, attributes without values and mismatched end tags. Off (default): those are errors."`
+	Body       Body     `json:"body" configurable:"true" title:"Body shape" description:"Parse: an example of the decoded body, such as {\"GetRateResponse\":{\"Rate\":\"\"}}, so downstream edges can be checked when the flow is built."`
+
+	EnableErrorPort bool `json:"enableErrorPort" title:"Enable Error Port" description:"Output errors and SOAP Faults to the error port instead of failing the run."`
+}
+
+type Component struct {
+	module.Base
+	settings Settings
+}
+
+func (c *Component) GetInfo() module.ComponentInfo {
+	return module.ComponentInfo{
+		Name:        ComponentName,
+		Description: "SOAP Envelope",
+		Info: "Build mode wraps a body in a SOAP 1.1 or 1.2 envelope, with any header blocks and an optional WS-Security " +
+			"UsernameToken, and outputs it with the HTTP headers to post it with. Give the body as data — " +
+			"{\"r:GetRate\":{\"r:currency\":\"EUR\"}} with the prefix r bound in namespaces — or as an XML string. " +
+			"Parse mode takes a response envelope of either version, outputs the body decoded as xml_decode does " +
+			"and the body's element as XML for xml_query, and sends a SOAP Fault to the error port with its " +
+			"faultcode, faultstring and detail. " +
+			"Post the envelope with http_request and parse the response body, whatever its status: servers send " +
+			"Faults with 500.",
+		Tags: []string{"xml", "soap"},
+	}
+}
+
+func (c *Component) OnSettings(_ context.Context, msg any) error {
+	in, ok := msg.(Settings)
+	if !ok {
+		return fmt.Errorf("invalid settings")
+	}
+	c.settings = in
+	return nil
+}
+
+func (c *Component) Handle(ctx context.Context, handler module.Handler, port string, msg any) module.Result {
+	if port != RequestPort {
+		return module.Fail(fmt.Errorf("unknown port: %s", port))
+	}
+
+	switch in := msg.(type) {
+	case Request:
+		out, err := c.build(in)
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		return handler(ctx, ResponsePort, out)
+	case ParseRequest:
+		out, err := c.parse(in)
+		if err != nil {
+			return c.handleError(ctx, handler, in.Context, err)
+		}
+		return handler(ctx, ResponsePort, out)
+	}
+	return module.Fail(fmt.Errorf("invalid message"))
+}
+
+func (c *Component) build(in Request) (Response, error) {
+	space, err := envelopeNamespace(c.settings.Version)
+	if err != nil {
+		return Response{}, err
+	}
+	env, err := newEnvelope(space, c.settings.Namespaces)
+	if err != nil {
+		return Response{}, err
+	}
+
+	secure := c.settings.Security != "" && c.settings.Security != SecurityNone
+	if secure || in.Headers != nil {
+		header := env.element(env.root, "Header")
+		if secure {
+			s := security{
+				mode:     c.settings.Security,
+				username: in.Username,
+				password: in.Password,
+				ttl:      time.Duration(c.settings.TimestampTTL) * time.Second,
+			}
+			if err := s.header(env, header); err != nil {
+				return Response{}, err
+			}
+		}
+		if in.Headers != nil {
+			if err := content(header, in.Headers, c.settings.Namespace, "headers"); err != nil {
+				return Response{}, err
+			}
+		}
+	}
+	body := env.element(env.root, "Body")
+	if err := content(body, in.Body, c.settings.Namespace, "body"); err != nil {
+		return Response{}, err
+	}
+
+	var b bytes.Buffer
+	if err := tree.Write(&b, env.root, tree.WriteOptions{Declaration: c.settings.Declaration}); err != nil {
+		return Response{}, err
+	}
+	return Response{Context: in.Context, Envelope: b.String(), HTTPHeaders: c.httpHeaders(space)}, nil
+}
+
+// httpHeaders are the headers a version's HTTP binding requires. 1.1 needs
+// SOAPAction even when it is empty, and many servers route on it.
+func (c *Component) httpHeaders(space string) map[string]string {
+	if space == Envelope12 {
+		contentType := "application/soap+xml; charset=utf-8"
+		if c.settings.Action != "" {
+			contentType += `; action="` + c.settings.Action + `"`
+		}
+		return map[string]string{"Content-Type": contentType}
+	}
+	return map[string]string{
+		"Content-Type": "text/xml; charset=utf-8",
+		"SOAPAction":   `"` + c.settings.Action + `"`,
+	}
+}
+
+func (c *Component) parse(in ParseRequest) (ParseResponse, error) {
+	doc, err := tree.Parse([]byte(in.Envelope), tree.ParseOptions{Lenient: c.settings.Lenient})
+	if err != nil {
+		return ParseResponse{}, err
+	}
+	p, err := parseEnvelope(doc)
+	if err != nil {
+		return ParseResponse{}, err
+	}
+	detail := func(el *tree.Node) any {
+		return c.decode(el, false)
+	}
+	if f := p.fault(detail); f != nil {
+		return ParseResponse{}, f
+	}
+
+	out := ParseResponse{Context: in.Context, Version: p.version, Body: c.decode(p.body, true)}
+	if p.header != nil {
+		out.Headers = c.decode(p.header, true)
+	}
+	if elements := p.body.Elements(); len(elements) > 0 {
+		var b bytes.Buffer
+		if err := tree.Write(&b, tree.Detach(elements[0]), tree.WriteOptions{}); err != nil {
+			return ParseResponse{}, err
+		}
+		out.XML = b.String()
+	}
+	return out, nil
+}
+
+// decode is what an element holds, decoded as xml_decode does: an object of
+// its children, or nil when it holds nothing. The Body's and Header's own
+// attributes are envelope plumbing, so plumbing leaves them out.
+func (c *Component) decode(el *tree.Node, plumbing bool) any {
+	names := c.settings.Names
+	if names == "" {
+		names = tree.NamesLocal
+	}
+	for _, v := range tree.ToValue(el, tree.ValueOptions{Names: names, ForceArray: c.settings.ForceArray}) {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			if v == "" {
+				return nil
+			}
+			return v
+		}
+		if plumbing {
+			for key := range obj {
+				if strings.HasPrefix(key, tree.AttrPrefix) {
+					delete(obj, key)
+				}
+			}
+		}
+		return obj
+	}
+	return nil
+}
+
+func (c *Component) handleError(ctx context.Context, handler module.Handler, reqCtx Context, err error) module.Result {
+	if !c.settings.EnableErrorPort {
+		return module.Fail(err)
+	}
+	out := Error{Context: reqCtx, Error: err.Error()}
+	var f *Fault
+	if errors.As(err, &f) {
+		out.Fault = f
+	}
+	return handler(ctx, ErrorPort, out)
+}
+
+func (c *Component) Ports() []module.Port {
+	var request, response any = Request{}, Response{}
+	if c.settings.Mode == ModeParse {
+		request, response = ParseRequest{}, ParseResponse{Body: c.settings.Body}
+	}
+	ports := []module.Port{
+		{
+			Name:          RequestPort,
+			Label:         "Request",
+			Configuration: request,
+			Position:      module.Left,
+		},
+		{
+			Name:          ResponsePort,
+			Label:         "Response",
+			Source:        true,
+			Configuration: response,
+			Position:      module.Right,
+		},
+		{
+			Name:          v1alpha1.SettingsPort,
+			Label:         "Settings",
+			Configuration: c.settings,
+		},
+	}
+	if c.settings.EnableErrorPort {
+		ports = append(ports, module.Port{
+			Name:          ErrorPort,
+			Label:         "Error",
+			Source:        true,
+			Configuration: Error{},
+			Position:      module.Bottom,
+		})
+	}
+	return ports
+}
+
+func (c *Component) Instance() module.Component {
+	return &Component{}
+}
+
+var (
+	_ module.Component       = (*Component)(nil)
+	_ module.SettingsHandler = (*Component)(nil)
+)
+
+func init() {
+	registry.Register(&Component{})
+}
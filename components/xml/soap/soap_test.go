@@ -0,0 +1,240 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiny-systems/module/module"
+)
+
+func run(t *testing.T, in any, settings Settings) (string, interface{}, error) {
+	t.Helper()
+	c, ok := (&Component{}).Instance().(*Component)
+	if !ok {
+		t.Fatal("Instance() did not return *Component")
+	}
+	if err := c.OnSettings(context.Background(), settings); err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+
+	var gotPort string
+	var gotMsg interface{}
+	res := c.Handle(context.Background(), func(_ context.Context, port string, msg interface{}) module.Result {
+		gotPort, gotMsg = port, msg
+		return module.Result{}
+	}, RequestPort, in)
+	return gotPort, gotMsg, res.Err()
+}
+
+func build(t *testing.T, in Request, settings Settings) Response {
+	t.Helper()
+	in.Context = "ctx"
+	port, msg, err := run(t, in, settings)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	out := msg.(Response)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out
+}
+
+func parse(t *testing.T, envelope string, settings Settings) ParseResponse {
+	t.Helper()
+	settings.Mode = ModeParse
+	port, msg, err := run(t, ParseRequest{Context: "ctx", Envelope: envelope}, settings)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out := msg.(ParseResponse)
+	if port != ResponsePort || out.Context != "ctx" {
+		t.Fatalf("port = %q, context = %v", port, out.Context)
+	}
+	return out
+}
+
+var rates = []Namespace{{Prefix: "r", URI: "urn:rates"}}
+
+func TestBuild(t *testing.T) {
+	out := build(t, Request{Body: map[string]any{"r:GetRate": map[string]any{"r:currency": "EUR"}}},
+		Settings{Namespaces: rates, Action: "urn:rates/GetRate"})
+	want := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:r="urn:rates">` +
+		`<soap:Body><r:GetRate><r:currency>EUR</r:currency></r:GetRate></soap:Body></soap:Envelope>`
+	if out.Envelope != want {
+		t.Fatalf("got  %s\nwant %s", out.Envelope, want)
+	}
+	if !reflect.DeepEqual(out.HTTPHeaders, map[string]string{"Content-Type": "text/xml; charset=utf-8", "SOAPAction": `"urn:rates/GetRate"`}) {
+		t.Fatalf("headers = %v", out.HTTPHeaders)
+	}
+}
+
+// An XML string body may use the envelope's prefixes without declaring them.
+func TestBuild12WithXMLBodyAndHeaders(t *testing.T) {
+	out := build(t, Request{
+		Body:    `<?xml version="1.0"?><r:GetRate><r:currency>EUR</r:currency></r:GetRate>`,
+		Headers: map[string]any{"Trace": map[string]any{"@id": "42"}},
+	}, Settings{Version: Version12, Namespaces: rates, Namespace: "urn:trace", Action: "GetRate"})
+	want := `<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:r="urn:rates">` +
+		`<soap:Header><Trace xmlns="urn:trace" id="42"/></soap:Header>` +
+		`<soap:Body><r:GetRate><r:currency>EUR</r:currency></r:GetRate></soap:Body></soap:Envelope>`
+	if out.Envelope != want {
+		t.Fatalf("got  %s\nwant %s", out.Envelope, want)
+	}
+	if got := out.HTTPHeaders["Content-Type"]; got != `application/soap+xml; charset=utf-8; action="GetRate"` {
+		t.Fatalf("content type = %s", got)
+	}
+}
+
+func fixClock(t *testing.T) {
+	t.Helper()
+	savedNow, savedNonces := now, nonces
+	now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+	nonces = bytes.NewReader(bytes.Repeat([]byte{7}, 16))
+	t.Cleanup(func() { now, nonces = savedNow, savedNonces })
+}
+
+func TestUsernameTokenText(t *testing.T) {
+	fixClock(t)
+	out := build(t, Request{Body: map[string]any{"Ping": ""}, Username: "svc", Password: "p<w"},
+		Settings{Security: SecurityText, TimestampTTL: 300})
+	for _, want := range []string{
+		`<wsse:Security xmlns:wsse="` + wsse + `" xmlns:wsu="` + wsu + `" soap:mustUnderstand="1">`,
+		`<wsu:Timestamp><wsu:Created>2024-03-01T12:00:00.000Z</wsu:Created><wsu:Expires>2024-03-01T12:05:00.000Z</wsu:Expires></wsu:Timestamp>`,
+		`<wsse:UsernameToken><wsse:Username>svc</wsse:Username><wsse:Password Type="` + passwordText + `">p&lt;w</wsse:Password></wsse:UsernameToken>`,
+	} {
+		if !strings.Contains(out.Envelope, want) {
+			t.Fatalf("%s\ndoes not contain %s", out.Envelope, want)
+		}
+	}
+}
+
+// The digest is Base64(SHA-1(nonce + created + password)), which is what the
+// server recomputes; a mismatch is indistinguishable from a wrong password.
+func TestUsernameTokenDigest(t *testing.T) {
+	fixClock(t)
+	out := build(t, Request{Body: map[string]any{"Ping": ""}, Username: "svc", Password: "secret"},
+		Settings{Version: Version12, Security: SecurityDigest})
+
+	nonce := bytes.Repeat([]byte{7}, 16)
+	sum := sha1.Sum([]byte(string(nonce) + "2024-03-01T12:00:00.000Z" + "secret"))
+	for _, want := range []string{
+		`soap:mustUnderstand="true"`,
+		`<wsse:Password Type="` + passwordDigest + `">` + base64.StdEncoding.EncodeToString(sum[:]) + `</wsse:Password>`,
+		`<wsse:Nonce EncodingType="` + base64Binary + `">` + base64.StdEncoding.EncodeToString(nonce) + `</wsse:Nonce>`,
+		`<wsu:Created>2024-03-01T12:00:00.000Z</wsu:Created></wsse:UsernameToken>`,
+	} {
+		if !strings.Contains(out.Envelope, want) {
+			t.Fatalf("%s\ndoes not contain %s", out.Envelope, want)
+		}
+	}
+	if strings.Contains(out.Envelope, "secret") || strings.Contains(out.Envelope, "Timestamp") {
+		t.Fatalf("digest envelope = %s", out.Envelope)
+	}
+}
+
+const response = `<?xml version="1.0" encoding="UTF-8"?>
+<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/">
+  <S:Header><ns2:Trace xmlns:ns2="urn:trace">42</ns2:Trace></S:Header>
+  <S:Body>
+    <ns1:GetRateResponse xmlns:ns1="urn:rates"><ns1:Rate currency="EUR">1.0842</ns1:Rate></ns1:GetRateResponse>
+  </S:Body>
+</S:Envelope>`
+
+func TestParse(t *testing.T) {
+	out := parse(t, response, Settings{})
+	if out.Version != Version11 {
+		t.Fatalf("version = %q", out.Version)
+	}
+	wantBody := map[string]any{"GetRateResponse": map[string]any{"Rate": map[string]any{"@currency": "EUR", "#text": "1.0842"}}}
+	if !reflect.DeepEqual(out.Body, wantBody) {
+		t.Fatalf("body = %#v", out.Body)
+	}
+	if !reflect.DeepEqual(out.Headers, map[string]any{"Trace": "42"}) {
+		t.Fatalf("headers = %#v", out.Headers)
+	}
+	if want := `<ns1:GetRateResponse xmlns:ns1="urn:rates"><ns1:Rate currency="EUR">1.0842</ns1:Rate></ns1:GetRateResponse>`; out.XML != want {
+		t.Fatalf("xml = %s", out.XML)
+	}
+}
+
+// What build makes, parse takes apart, for either version.
+func TestRoundTrip(t *testing.T) {
+	for _, version := range []string{Version11, Version12} {
+		env := build(t, Request{Body: map[string]any{"r:GetRate": map[string]any{"r:currency": "EUR"}}},
+			Settings{Version: version, Namespaces: rates})
+		out := parse(t, env.Envelope, Settings{})
+		if out.Version != version || !reflect.DeepEqual(out.Body, map[string]any{"GetRate": map[string]any{"currency": "EUR"}}) {
+			t.Fatalf("%s: version %q, body %#v", version, out.Version, out.Body)
+		}
+	}
+}
+
+func TestFault11(t *testing.T) {
+	envelope := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault>
+  <faultcode>soap:Client</faultcode>
+  <faultstring>Unknown currency</faultstring>
+  <detail><e:RateError xmlns:e="urn:rates"><e:code>42</e:code></e:RateError></detail>
+</soap:Fault></soap:Body></soap:Envelope>`
+	settings := Settings{Mode: ModeParse, EnableErrorPort: true}
+	port, msg, err := run(t, ParseRequest{Context: "ctx", Envelope: envelope}, settings)
+	if err != nil || port != ErrorPort {
+		t.Fatalf("port = %q, err = %v", port, err)
+	}
+	e := msg.(Error)
+	want := &Fault{Code: "soap:Client", String: "Unknown currency", Detail: map[string]any{"RateError": map[string]any{"code": "42"}}}
+	if e.Context != "ctx" || e.Error != "SOAP fault soap:Client: Unknown currency" || !reflect.DeepEqual(e.Fault, want) {
+		t.Fatalf("error = %#v, fault = %#v", e, e.Fault)
+	}
+
+	settings.EnableErrorPort = false
+	if _, _, err := run(t, ParseRequest{Envelope: envelope}, settings); err == nil || !strings.Contains(err.Error(), "Unknown currency") {
+		t.Fatalf("without the error port: err = %v", err)
+	}
+}
+
+func TestFault12(t *testing.T) {
+	envelope := `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault>
+  <env:Code><env:Value>env:Sender</env:Value><env:Subcode><env:Value>m:BadRate</env:Value></env:Subcode></env:Code>
+  <env:Reason><env:Text xml:lang="en">Rate unavailable</env:Text></env:Reason>
+  <env:Role>urn:gateway</env:Role>
+</env:Fault></env:Body></env:Envelope>`
+	port, msg, _ := run(t, ParseRequest{Envelope: envelope}, Settings{Mode: ModeParse, EnableErrorPort: true})
+	want := &Fault{Code: "env:Sender", Subcodes: []string{"m:BadRate"}, String: "Rate unavailable", Actor: "urn:gateway"}
+	if port != ErrorPort || !reflect.DeepEqual(msg.(Error).Fault, want) {
+		t.Fatalf("port = %q, fault = %#v", port, msg.(Error).Fault)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in       any
+		settings Settings
+		want     string
+	}{
+		"not an envelope":  {ParseRequest{Envelope: `<Envelope/>`}, Settings{Mode: ModeParse}, `<Envelope> is in namespace ""`},
+		"no body":          {ParseRequest{Envelope: `<s:Envelope xmlns:s="` + Envelope11 + `"/>`}, Settings{Mode: ModeParse}, "no Body"},
+		"undeclared":       {Request{Body: map[string]any{"x:Ping": ""}}, Settings{}, `prefix "x"`},
+		"body not object":  {Request{Body: []any{1}}, Settings{}, "must be an object of elements"},
+		"bad body xml":     {Request{Body: "<a>"}, Settings{}, "body:"},
+		"no username":      {Request{Body: "<Ping/>"}, Settings{Security: SecurityText}, "needs a username"},
+		"unknown version":  {Request{Body: "<Ping/>"}, Settings{Version: "2.0"}, "unknown SOAP version"},
+		"reserved prefix":  {Request{Body: "<Ping/>"}, Settings{Namespaces: []Namespace{{Prefix: "soap", URI: "urn:x"}}}, "reserved"},
+		"unknown security": {Request{Body: "<Ping/>", Username: "u"}, Settings{Security: "kerberos"}, "unknown WS-Security mode"},
+	} {
+		tc.settings.EnableErrorPort = true
+		port, msg, err := run(t, tc.in, tc.settings)
+		if err != nil || port != ErrorPort {
+			t.Fatalf("%s: port = %q, err = %v", name, port, err)
+		}
+		if e := msg.(Error); !strings.Contains(e.Error, tc.want) || e.Fault != nil {
+			t.Errorf("%s: error = %q, want %q", name, e.Error, tc.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package tree
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	defaultRootName = "root"
+	defaultItemName = "item"
+)
+
+// BuildOptions adjusts how FromValue turns a generic value into elements.
+type BuildOptions struct {
+	// RootName names the root element. Empty takes it from a value with one
+	// key, {"order":{…}}, as ToValue produces; anything else is wrapped in
+	// <root>.
+	RootName string
+	// ItemName names the elements an array holds when nothing else names
+	// them: the elements of a root array, or of an array inside an array.
+	ItemName string
+	// Order lists element names written first, in this order, wherever they
+	// appear. An object has no order of its own, so the rest follow sorted by
+	// name — stable, but not what a schema with a sequence expects.
+	Order []string
+	// Namespace is declared as the root's default namespace, and Namespaces
+	// as its prefixes, prefix to URI — unless the value declares them itself.
+	Namespace  string
+	Namespaces map[string]string
+	// Parent, when set, is the element the result is appended to, so the
+	// prefixes declared on it and above can be used without declaring them
+	// again.
+	Parent *Node
+}
+
+// FromValue builds an element from the generic tree, the reverse of ToValue:
+// keys starting with @ are attributes, #text is text, an array is the element
+// repeated and any other key is a child element.
+func FromValue(v any, opts BuildOptions) (*Node, error) {
+	b := builder{opts: opts, rank: map[string]int{}}
+	if b.opts.ItemName == "" {
+		b.opts.ItemName = defaultItemName
+	}
+	for i, name := range opts.Order {
+		b.rank[name] = i + 1
+	}
+
+	name, content := opts.RootName, v
+	if name == "" {
+		name = defaultRootName
+		if obj, ok := v.(map[string]any); ok && len(obj) == 1 {
+			for key, val := range obj {
+				if _, isArray := val.([]any); !isArray && !strings.HasPrefix(key, AttrPrefix) && key != TextKey {
+					name, content = key, val
+				}
+			}
+		}
+	}
+	root, err := b.element(name, "", content)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := make([]string, 0, len(opts.Namespaces))
+	for prefix := range opts.Namespaces {
+		if !ncName(prefix) {
+			return nil, fmt.Errorf("%q is not a valid namespace prefix", prefix)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	// Declarations go first on the root, where a reader looks for them.
+	var decls []Attr
+	if d, ok := declaration(root, "", opts.Namespace); ok {
+		decls = append(decls, d)
+	}
+	for _, prefix := range prefixes {
+		if d, ok := declaration(root, prefix, opts.Namespaces[prefix]); ok {
+			decls = append(decls, d)
+		}
+	}
+	root.Attrs = append(decls, root.Attrs...)
+
+	// A document using a prefix it never declares is not namespace
+	// well-formed, and every parser that checks refuses it.
+	root.Parent = opts.Parent
+	if err := resolveAll(root); err != nil {
+		return nil, err
+	}
+	if opts.Parent != nil {
+		opts.Parent.Append(root)
+	}
+	return root, nil
+}
+
+// declaration is the attribute declaring prefix, unless el already has one.
+func declaration(el *Node, prefix, uri string) (Attr, bool) {
+	if uri == "" {
+		return Attr{}, false
+	}
+	decl := Attr{Space: XMLNSNamespace, Prefix: "xmlns", Local: prefix, Value: uri}
+	if prefix == "" {
+		decl.Prefix, decl.Local = "", "xmlns"
+	}
+	for _, a := range el.Attrs {
+		if a.Prefix == decl.Prefix && a.Local == decl.Local {
+			return Attr{}, false
+		}
+	}
+	return decl, true
+}
+
+func resolveAll(el *Node) error {
+	if err := resolve(el, false); err != nil {
+		return err
+	}
+	for _, c := range el.Children {
+		if c.Kind == ElementNode {
+			if err := resolveAll(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type builder struct {
+	opts BuildOptions
+	rank map[string]int
+}
+
+// element builds <name> holding v. path is where v sits in the value, for
+// errors.
+func (b *builder) element(name, path string, v any) (*Node, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("%s: %q is not a valid XML element name", where(path), name)
+	}
+	el := &Node{Kind: ElementNode}
+	el.Prefix, el.Local = split(name)
+	path += "/" + name
+
+	switch val := v.(type) {
+	case map[string]any:
+		return el, b.object(el, path, val)
+	case []any:
+		for i, item := range val {
+			child, err := b.element(b.opts.ItemName, path+"/"+strconv.Itoa(i), item)
+			if err != nil {
+				return nil, err
+			}
+			el.Append(child)
+		}
+		return el, nil
+	case nil:
+		return el, nil
+	}
+	text, err := scalar(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", where(path), err)
+	}
+	el.Append(&Node{Kind: TextNode, Data: text})
+	return el, nil
+}
+
+// object fills el from an object: attributes, then child elements in order,
+// then text.
+func (b *builder) object(el *Node, path string, obj map[string]any) error {
+	var attrs, elements []string
+	for key := range obj {
+		switch {
+		case strings.HasPrefix(key, AttrPrefix):
+			attrs = append(attrs, key)
+		case key != TextKey:
+			elements = append(elements, key)
+		}
+	}
+	sort.Strings(attrs)
+	sort.Slice(elements, func(i, j int) bool {
+		ri, rj := b.rank[elements[i]], b.rank[elements[j]]
+		if ri != rj {
+			// Listed names come before unlisted ones, which rank 0.
+			return rj == 0 || (ri != 0 && ri < rj)
+		}
+		return elements[i] < elements[j]
+	})
+
+	for _, key := range attrs {
+		name := strings.TrimPrefix(key, AttrPrefix)
+		if !validName(name) {
+			return fmt.Errorf("%s: %q is not a valid XML attribute name", where(path), name)
+		}
+		text, err := scalar(obj[key])
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", where(path), key, err)
+		}
+		prefix, local := split(name)
+		el.Attrs = append(el.Attrs, Attr{Prefix: prefix, Local: local, Value: text})
+	}
+	for _, key := range elements {
+		items, repeated := obj[key].([]any)
+		if !repeated {
+			items = []any{obj[key]}
+		}
+		for _, item := range items {
+			child, err := b.element(key, path, item)
+			if err != nil {
+				return err
+			}
+			el.Append(child)
+		}
+	}
+	if t, ok := obj[TextKey]; ok && t != nil {
+		text, err := scalar(t)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", where(path), TextKey, err)
+		}
+		el.Append(&Node{Kind: TextNode, Data: text})
+	}
+	return nil
+}
+
+// Append adds child as n's last child.
+func (n *Node) Append(child *Node) {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+}
+
+// scalar writes a value as text. A whole float is written without a
+// decimal point — JSON has one number type, and 7 must not become 7.000000.
+func scalar(v any) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return val, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case json.Number:
+		return val.String(), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	}
+	return "", fmt.Errorf("an object or array cannot be written as text or an attribute")
+}
+
+func split(name string) (prefix, local string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// validName checks name is an XML name with at most one colon, between a
+// prefix and a local name.
+func validName(name string) bool {
+	prefix, local := split(name)
+	if strings.Contains(name, ":") && !ncName(prefix) {
+		return false
+	}
+	return ncName(local)
+}
+
+func ncName(s string) bool {
+	if s == "" || strings.ContainsRune(s, ':') {
+		return false
+	}
+	for i, r := range s {
+		if r == utf8.RuneError {
+			return false
+		}
+		if i == 0 && !(unicode.IsLetter(r) || r == '_') {
+			return false
+		}
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' || unicode.Is(unicode.Mn, r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func where(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return path
+}
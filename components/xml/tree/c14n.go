@@ -0,0 +1,165 @@
+package tree
+
+import (
+	"bufio"
+	"io"
+	"sort"
+)
+
+// CanonicalOptions adjusts Canonicalize.
+type CanonicalOptions struct {
+	// Comments keeps comments, as the #WithComments variants do.
+	Comments bool
+	// InclusivePrefixes are the prefixes declared wherever they are in
+	// scope, used or not, as an InclusiveNamespaces PrefixList names them;
+	// #default is the default namespace.
+	InclusivePrefixes []string
+	// Exclude is left out with everything under it, as the
+	// enveloped-signature transform leaves out the signature.
+	Exclude *Node
+}
+
+// Canonicalize writes n in Exclusive XML Canonicalization 1.0, the form XML
+// signatures digest: attributes sorted, a namespace declared only on the
+// elements that use it, nothing self-closed and a fixed set of escapes, so
+// that any two serializations of the same content come out byte for byte
+// alike. Namespaces declared above n but used in it are declared on it.
+func Canonicalize(w io.Writer, n *Node, opts CanonicalOptions) error {
+	c := &canonicalizer{w: bufio.NewWriter(w), opts: opts, inclusive: map[string]bool{}}
+	for _, p := range opts.InclusivePrefixes {
+		if p == "#default" {
+			p = ""
+		}
+		c.inclusive[p] = true
+	}
+	if n.Kind == DocumentNode {
+		seenRoot := false
+		for _, child := range n.Children {
+			if child == opts.Exclude || (child.Kind == CommentNode && !opts.Comments) {
+				continue
+			}
+			// Outside the root, each comment or processing instruction is
+			// on its own line.
+			if child.Kind == ElementNode {
+				seenRoot = true
+				c.node(child, map[string]string{})
+				continue
+			}
+			if seenRoot {
+				c.w.WriteString("\n")
+			}
+			c.node(child, nil)
+			if !seenRoot {
+				c.w.WriteString("\n")
+			}
+		}
+	} else {
+		c.node(n, map[string]string{})
+	}
+	return c.w.Flush()
+}
+
+type canonicalizer struct {
+	w         *bufio.Writer
+	opts      CanonicalOptions
+	inclusive map[string]bool
+}
+
+// node writes n. rendered is the namespace each prefix was last declared
+// with by an element already written around it.
+func (c *canonicalizer) node(n *Node, rendered map[string]string) {
+	if n == c.opts.Exclude {
+		return
+	}
+	switch n.Kind {
+	case TextNode:
+		c.w.WriteString(escape(n.Data, false))
+	case CommentNode:
+		if c.opts.Comments {
+			c.w.WriteString("<!--" + n.Data + "-->")
+		}
+	case ProcInstNode:
+		c.w.WriteString("<?" + n.Target)
+		if n.Data != "" {
+			c.w.WriteString(" " + n.Data)
+		}
+		c.w.WriteString("?>")
+	case ElementNode:
+		c.element(n, rendered)
+	}
+}
+
+func (c *canonicalizer) element(el *Node, rendered map[string]string) {
+	// A prefix is declared where it is visibly used: by the element's name
+	// or one of its attributes' names.
+	prefixes := map[string]bool{el.Prefix: true}
+	var attrs []Attr
+	for _, a := range el.Attrs {
+		if a.IsNamespaceDecl() {
+			continue
+		}
+		attrs = append(attrs, a)
+		if a.Prefix != "" {
+			prefixes[a.Prefix] = true
+		}
+	}
+	for p := range c.inclusive {
+		if _, bound := el.LookupPrefix(p); bound {
+			prefixes[p] = true
+		}
+	}
+
+	var decls []Attr
+	own, copied := rendered, false
+	for p := range prefixes {
+		if p == "xml" {
+			continue
+		}
+		space, _ := el.LookupPrefix(p)
+		last, ok := rendered[p]
+		// The default namespace is undeclared only to undo a declaration
+		// already written around the element.
+		if (ok && last == space) || (!ok && p == "" && space == "") {
+			continue
+		}
+		if !copied {
+			own, copied = make(map[string]string, len(rendered)+1), true
+			for k, v := range rendered {
+				own[k] = v
+			}
+		}
+		own[p] = space
+		if p == "" {
+			decls = append(decls, Attr{Local: "xmlns", Value: space})
+		} else {
+			decls = append(decls, Attr{Prefix: "xmlns", Local: p, Value: space})
+		}
+	}
+	sort.Slice(decls, func(i, j int) bool { return declaredPrefix(decls[i]) < declaredPrefix(decls[j]) })
+	sort.SliceStable(attrs, func(i, j int) bool {
+		if attrs[i].Space != attrs[j].Space {
+			return attrs[i].Space < attrs[j].Space
+		}
+		return attrs[i].Local < attrs[j].Local
+	})
+
+	name := el.Name()
+	c.w.WriteString("<" + name)
+	for _, a := range append(decls, attrs...) {
+		c.w.WriteString(" " + a.Name() + `="` + escape(a.Value, true) + `"`)
+	}
+	c.w.WriteString(">")
+	for _, child := range el.Children {
+		c.node(child, own)
+	}
+	c.w.WriteString("</" + name + ">")
+}
+
+// declaredPrefix is the prefix a namespace declaration declares, which
+// declarations sort by: the default namespace's empty one first.
+func declaredPrefix(a Attr) string {
+	if a.Prefix == "" {
+		return ""
+	}
+	return a.Local
+}
@@ -0,0 +1,72 @@
+package tree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// cp1252 is what windows-1252 puts at 0x80–0x9F, where ISO-8859-1 has
+// control characters nobody sends. Zero marks the five bytes it leaves
+// undefined.
+var cp1252 = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+// charsetReader decodes the single-byte encodings older systems still
+// declare. encoding/xml reads UTF-8 itself and asks for anything else; these
+// are the ones that turn up in practice, and everything else is refused by
+// name rather than decoded as garbage.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "iso-8859-1", "iso8859-1", "latin1", "l1", "us-ascii", "ascii":
+		// ASCII is a subset, and a mislabelled Latin-1 byte reads as
+		// what the sender meant.
+		return &singleByte{r: bufio.NewReader(input)}, nil
+	case "windows-1252", "cp1252":
+		return &singleByte{r: bufio.NewReader(input), cp1252: true}, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q: re-encode the document as UTF-8", label)
+}
+
+// singleByte turns each input byte into its code point, encoded as UTF-8.
+type singleByte struct {
+	r       *bufio.Reader
+	cp1252  bool
+	pending []byte
+}
+
+func (s *singleByte) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.pending) > 0 {
+			c := copy(p[n:], s.pending)
+			s.pending = s.pending[c:]
+			n += c
+			continue
+		}
+		b, err := s.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		r := rune(b)
+		if s.cp1252 && b >= 0x80 && b <= 0x9F && cp1252[b-0x80] != 0 {
+			r = cp1252[b-0x80]
+		}
+		if r < utf8.RuneSelf {
+			p[n] = byte(r)
+			n++
+			continue
+		}
+		s.pending = utf8.AppendRune(s.pending[:0], r)
+	}
+	return n, nil
+}
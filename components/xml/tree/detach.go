@@ -0,0 +1,79 @@
+package tree
+
+import "sort"
+
+// Detach copies el out of its document. The prefixes it uses that were
+// declared further up are declared on the copy, so written out on its own it
+// is still namespace well-formed and means what it meant in place.
+func Detach(el *Node) *Node {
+	cp := clone(el, nil)
+	needed := map[string]bool{}
+	usedPrefixes(cp, map[string]bool{}, needed)
+
+	prefixes := make([]string, 0, len(needed))
+	for prefix := range needed {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var decls []Attr
+	for _, prefix := range prefixes {
+		uri, _ := el.LookupPrefix(prefix)
+		if d, ok := declaration(cp, prefix, uri); ok {
+			decls = append(decls, d)
+		}
+	}
+	cp.Attrs = append(decls, cp.Attrs...)
+	return cp
+}
+
+func clone(n *Node, parent *Node) *Node {
+	cp := *n
+	cp.Parent = parent
+	cp.Attrs = append([]Attr(nil), n.Attrs...)
+	cp.Children = make([]*Node, len(n.Children))
+	for i, c := range n.Children {
+		cp.Children[i] = clone(c, &cp)
+	}
+	return &cp
+}
+
+// usedPrefixes collects into needed the prefixes used under n without a
+// declaration in between; declared holds those declared above n.
+func usedPrefixes(n *Node, declared, needed map[string]bool) {
+	if n.Kind != ElementNode {
+		return
+	}
+	local, copied := declared, false
+	for _, a := range n.Attrs {
+		if a.IsNamespaceDecl() {
+			if !copied {
+				local, copied = make(map[string]bool, len(declared)+1), true
+				for p := range declared {
+					local[p] = true
+				}
+			}
+			if a.Prefix == "" {
+				local[""] = true
+			} else {
+				local[a.Local] = true
+			}
+		}
+	}
+	use := func(prefix, space string) {
+		// The default namespace needs declaring only when the element is in
+		// one; xml is bound everywhere.
+		if prefix == "xml" || local[prefix] || (prefix == "" && space == "") {
+			return
+		}
+		needed[prefix] = true
+	}
+	use(n.Prefix, n.Space)
+	for _, a := range n.Attrs {
+		if !a.IsNamespaceDecl() && a.Prefix != "" {
+			use(a.Prefix, a.Space)
+		}
+	}
+	for _, c := range n.Children {
+		usedPrefixes(c, local, needed)
+	}
+}
@@ -0,0 +1,389 @@
+// Package tree is the XML document model the xml components share.
+//
+// encoding/xml either maps a document onto Go structs written for it in
+// advance, which a flow never has, or hands out a token stream with namespace
+// prefixes already thrown away. Flows need neither: they need the document as
+// written — prefixes and declarations included, since partner integrations
+// and signatures depend on them — and a way to move between that and the
+// generic map[string]any tree every other node works in.
+package tree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kind is what a Node is.
+type Kind int
+
+const (
+	DocumentNode Kind = iota
+	ElementNode
+	TextNode
+	CommentNode
+	ProcInstNode
+)
+
+const (
+	// XMLNamespace is the namespace the xml prefix is always bound to.
+	XMLNamespace = "http://www.w3.org/XML/1998/namespace"
+	// XMLNSNamespace is the namespace of xmlns and xmlns:p declarations.
+	XMLNSNamespace = "http://www.w3.org/2000/xmlns/"
+)
+
+// Node is one node of a document. An element's Space is its namespace URI,
+// resolved; Prefix is how the document wrote it.
+type Node struct {
+	Kind Kind
+
+	Space  string
+	Prefix string
+	Local  string
+	Attrs  []Attr
+
+	// Data is a text node's text, a comment's content, or a processing
+	// instruction's content after its Target.
+	Data   string
+	Target string
+
+	Parent   *Node
+	Children []*Node
+}
+
+// Attr is an attribute, namespace declarations included: xmlns="…" has Local
+// xmlns and no prefix, xmlns:p="…" has Prefix xmlns and Local p.
+type Attr struct {
+	Space  string
+	Prefix string
+	Local  string
+	Value  string
+}
+
+// Name is the element's name as written, prefix:local or local.
+func (n *Node) Name() string {
+	return qualified(n.Prefix, n.Local)
+}
+
+// Name is the attribute's name as written.
+func (a Attr) Name() string {
+	return qualified(a.Prefix, a.Local)
+}
+
+// IsNamespaceDecl reports whether the attribute declares a namespace rather
+// than carrying data.
+func (a Attr) IsNamespaceDecl() bool {
+	return a.Prefix == "xmlns" || (a.Prefix == "" && a.Local == "xmlns")
+}
+
+func qualified(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+// Root is a document's element, or the node itself when it is an element.
+func (n *Node) Root() *Node {
+	if n.Kind != DocumentNode {
+		return n
+	}
+	for _, c := range n.Children {
+		if c.Kind == ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+// Elements are the node's child elements.
+func (n *Node) Elements() []*Node {
+	var out []*Node
+	for _, c := range n.Children {
+		if c.Kind == ElementNode {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Attr is the value of the attribute with the given namespace and local name.
+func (n *Node) Attr(space, local string) (string, bool) {
+	for _, a := range n.Attrs {
+		if a.Space == space && a.Local == local && !a.IsNamespaceDecl() {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Text is the text of the node and everything under it, as XPath's string()
+// has it.
+func (n *Node) Text() string {
+	if n.Kind == TextNode || n.Kind == CommentNode || n.Kind == ProcInstNode {
+		return n.Data
+	}
+	var b strings.Builder
+	var walk func(*Node)
+	walk = func(m *Node) {
+		for _, c := range m.Children {
+			switch c.Kind {
+			case TextNode:
+				b.WriteString(c.Data)
+			case ElementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// LookupPrefix finds the namespace prefix is bound to at n, and whether it is
+// bound at all. The empty prefix is the default namespace, which is always
+// bound — to nothing, until a declaration says otherwise.
+func (n *Node) LookupPrefix(prefix string) (string, bool) {
+	switch prefix {
+	case "xml":
+		return XMLNamespace, true
+	case "xmlns":
+		return XMLNSNamespace, true
+	}
+	for m := n; m != nil; m = m.Parent {
+		for _, a := range m.Attrs {
+			if prefix == "" && a.Prefix == "" && a.Local == "xmlns" {
+				return a.Value, true
+			}
+			if prefix != "" && a.Prefix == "xmlns" && a.Local == prefix {
+				return a.Value, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// ParseOptions adjusts how forgiving Parse is.
+type ParseOptions struct {
+	// Lenient accepts what real-world feeds and hand-written files contain
+	// and a strict parser refuses: HTML entities such as &nbsp;, attributes
+	// without a value, end tags that do not match and prefixes that were
+	// never declared, which are left in no namespace.
+	Lenient bool
+}
+
+// Parse reads a whole document. Comments and processing instructions are
+// kept; the XML declaration and any DOCTYPE are not, and nothing a DOCTYPE
+// declares is expanded or fetched. A tab or line break written as is inside
+// an attribute value reads as a space, as XML requires; one written as a
+// character reference stays what it is.
+func Parse(data []byte, opts ParseOptions) (*Node, error) {
+	dec := xml.NewDecoder(bytes.NewReader(normalizeAttrs(data)))
+	dec.CharsetReader = charsetReader
+	if opts.Lenient {
+		dec.Strict = false
+		dec.Entity = xml.HTMLEntity
+	}
+
+	doc := &Node{Kind: DocumentNode}
+	cur := doc
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if cur == doc && doc.Root() != nil {
+				return nil, fmt.Errorf("line %d: a document has one root element, found a second: <%s>", line(dec), rawName(t.Name))
+			}
+			el := &Node{Kind: ElementNode, Prefix: t.Name.Space, Local: t.Name.Local, Parent: cur}
+			for _, a := range t.Attr {
+				el.Attrs = append(el.Attrs, Attr{Prefix: a.Name.Space, Local: a.Name.Local, Value: a.Value})
+			}
+			if err := resolve(el, opts.Lenient); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line(dec), err)
+			}
+			cur.Children = append(cur.Children, el)
+			cur = el
+		case xml.EndElement:
+			// RawToken leaves matching end tags to the caller.
+			name := rawName(t.Name)
+			if cur != doc && cur.Name() == name {
+				cur = cur.Parent
+				continue
+			}
+			if !opts.Lenient {
+				if cur == doc {
+					return nil, fmt.Errorf("line %d: unexpected end tag </%s>", line(dec), name)
+				}
+				return nil, fmt.Errorf("line %d: element <%s> closed by </%s>", line(dec), cur.Name(), name)
+			}
+			// Close whatever was left open inside the element being ended;
+			// an end tag that matches nothing open is dropped.
+			for m := cur; m != doc; m = m.Parent {
+				if m.Name() == name {
+					cur = m.Parent
+					break
+				}
+			}
+		case xml.CharData:
+			if cur == doc {
+				// Only whitespace can sit outside the root, and it means
+				// nothing there.
+				continue
+			}
+			if last := len(cur.Children) - 1; last >= 0 && cur.Children[last].Kind == TextNode {
+				cur.Children[last].Data += string(t)
+				continue
+			}
+			cur.Children = append(cur.Children, &Node{Kind: TextNode, Data: string(t), Parent: cur})
+		case xml.Comment:
+			cur.Children = append(cur.Children, &Node{Kind: CommentNode, Data: string(t), Parent: cur})
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				continue
+			}
+			cur.Children = append(cur.Children, &Node{Kind: ProcInstNode, Target: t.Target, Data: string(t.Inst), Parent: cur})
+		}
+	}
+	if cur != doc && !opts.Lenient {
+		return nil, fmt.Errorf("element <%s> is never closed", cur.Name())
+	}
+	if doc.Root() == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return doc, nil
+}
+
+// normalizeAttrs replaces the literal tabs, line feeds and carriage returns
+// inside attribute values with spaces, a CR LF pair with one. encoding/xml
+// keeps them, and decodes a character reference to the same character, so
+// this has to happen on the source, where the two can still be told apart.
+// Canonicalization writes a tab or line break in an attribute as a
+// reference, so a signature over one read without this would not verify
+// anywhere else. Every encoding Parse reads is ASCII-compatible, so the
+// bytes looked at mean the same in all of them.
+func normalizeAttrs(data []byte) []byte {
+	var out []byte
+	last := 0
+	for i := 0; i < len(data); {
+		j := bytes.IndexByte(data[i:], '<')
+		if j < 0 {
+			break
+		}
+		i += j
+		rest := data[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			i += skipPast(rest, "-->")
+		case bytes.HasPrefix(rest, []byte("<![CDATA[")):
+			i += skipPast(rest, "]]>")
+		case bytes.HasPrefix(rest, []byte("<?")):
+			i += skipPast(rest, "?>")
+		case bytes.HasPrefix(rest, []byte("<!")):
+			i += skipDeclaration(rest)
+		case len(rest) > 1 && nameStart(rest[1]):
+			var quote byte
+			k := 1
+			for ; k < len(rest) && (quote != 0 || rest[k] != '>'); k++ {
+				switch c := rest[k]; {
+				case quote == 0 && (c == '"' || c == '\''):
+					quote = c
+				case c == quote:
+					quote = 0
+				case quote != 0 && (c == '\t' || c == '\n' || c == '\r'):
+					out = append(out, data[last:i+k]...)
+					out = append(out, ' ')
+					last = i + k + 1
+					if c == '\r' && k+1 < len(rest) && rest[k+1] == '\n' {
+						k++
+						last++
+					}
+				}
+			}
+			i += k
+		default:
+			i++
+		}
+	}
+	if out == nil {
+		return data
+	}
+	return append(out, data[last:]...)
+}
+
+// skipPast is how far past the end marker of a comment, CDATA section or
+// processing instruction is, or all of b when it never ends.
+func skipPast(b []byte, end string) int {
+	if k := bytes.Index(b, []byte(end)); k >= 0 {
+		return k + len(end)
+	}
+	return len(b)
+}
+
+// skipDeclaration is the length of a DOCTYPE, internal subset and quoted
+// strings included.
+func skipDeclaration(b []byte) int {
+	var quote byte
+	depth := 0
+	for k := 2; k < len(b); k++ {
+		switch c := b[k]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '>' && depth <= 0:
+			return k + 1
+		}
+	}
+	return len(b)
+}
+
+func nameStart(c byte) bool {
+	return c == '_' || c == ':' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// resolve binds el's and its attributes' prefixes to namespaces, now that
+// its own declarations are known. An unprefixed attribute is in no namespace,
+// whatever the default namespace is; so, when lenient, is a name whose prefix
+// was never declared.
+func resolve(el *Node, lenient bool) error {
+	space, ok := el.LookupPrefix(el.Prefix)
+	if !ok && !lenient {
+		return fmt.Errorf("prefix %q of <%s> is not declared", el.Prefix, el.Name())
+	}
+	el.Space = space
+	for i, a := range el.Attrs {
+		switch {
+		case a.IsNamespaceDecl():
+			el.Attrs[i].Space = XMLNSNamespace
+		case a.Prefix != "":
+			space, ok := el.LookupPrefix(a.Prefix)
+			if !ok && !lenient {
+				return fmt.Errorf("prefix %q of attribute %s on <%s> is not declared", a.Prefix, a.Name(), el.Name())
+			}
+			el.Attrs[i].Space = space
+		}
+	}
+	return nil
+}
+
+func rawName(n xml.Name) string {
+	return qualified(n.Space, n.Local)
+}
+
+func line(dec *xml.Decoder) int {
+	l, _ := dec.InputPos()
+	return l
+}
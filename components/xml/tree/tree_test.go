@@ -0,0 +1,416 @@
+package tree
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string, opts ParseOptions) *Node {
+	t.Helper()
+	doc, err := Parse([]byte(s), opts)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return doc
+}
+
+// Prefixes are kept as written and resolved, including a default namespace
+// and one redeclared further down.
+func TestNamespacesResolve(t *testing.T) {
+	doc := mustParse(t, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="urn:soap" xmlns="urn:default">
+	<s:Body><Order a:id="7" plain="x" xmlns:a="urn:a"><Line xmlns="urn:other"/></Order></s:Body>
+</s:Envelope>`, ParseOptions{})
+
+	env := doc.Root()
+	if env.Space != "urn:soap" || env.Name() != "s:Envelope" {
+		t.Fatalf("root = %q in %q", env.Name(), env.Space)
+	}
+	order := env.Elements()[0].Elements()[0]
+	if order.Space != "urn:default" || order.Prefix != "" {
+		t.Errorf("order in %q", order.Space)
+	}
+	if v, ok := order.Attr("urn:a", "id"); !ok || v != "7" {
+		t.Errorf("a:id = %q, %v", v, ok)
+	}
+	if _, ok := order.Attr("", "plain"); !ok {
+		t.Error("an unprefixed attribute should be in no namespace")
+	}
+	if line := order.Elements()[0]; line.Space != "urn:other" {
+		t.Errorf("line in %q", line.Space)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]string{
+		`<a><b></a>`:         "closed by",
+		`<a>`:                "never closed",
+		`<p:a/>`:             "not declared",
+		`<a x:y="1"></a>`:    "not declared",
+		`<a/><b/>`:           "one root",
+		``:                   "no root",
+		`just text, no tags`: "no root",
+		`<a>&nbsp;</a>`:      "entity",
+	}
+	for in, want := range cases {
+		_, err := Parse([]byte(in), ParseOptions{})
+		if err == nil {
+			t.Errorf("%q parsed", in)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %q, want it to mention %q", in, err, want)
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	doc := mustParse(t, `<p>a&nbsp;b<br><i>c</p>`, ParseOptions{Lenient: true})
+	if got := doc.Root().Text(); got != "a\u00a0bc" {
+		t.Fatalf("text = %q", got)
+	}
+
+	doc = mustParse(t, `<a><m:b m:c="1">x</m:b></a>`, ParseOptions{Lenient: true})
+	if b := doc.Root().Elements()[0]; b.Name() != "m:b" || b.Space != "" || b.Attrs[0].Space != "" {
+		t.Fatalf("undeclared prefix: %+v", b)
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	doc := mustParse(t, "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>caf\xe9</a>", ParseOptions{})
+	if got := doc.Root().Text(); got != "café" {
+		t.Fatalf("text = %q", got)
+	}
+	doc = mustParse(t, "<?xml version=\"1.0\" encoding=\"windows-1252\"?><a>\x80 \x93q\x94</a>", ParseOptions{})
+	if got := doc.Root().Text(); got != "€ “q”" {
+		t.Fatalf("text = %q", got)
+	}
+	if _, err := Parse([]byte(`<?xml version="1.0" encoding="EBCDIC"?><a/>`), ParseOptions{}); err == nil {
+		t.Fatal("decoded an unsupported encoding")
+	}
+}
+
+func TestToValue(t *testing.T) {
+	doc := mustParse(t, `<order id="7" xmlns:x="urn:x">
+	<item>a</item>
+	<item sku="s2">b</item>
+	<x:note>  hi  </x:note>
+	<empty/>
+	<p>one <b>two</b> three</p>
+</order>`, ParseOptions{})
+
+	got := ToValue(doc.Root(), ValueOptions{})
+	want := map[string]any{"order": map[string]any{
+		"@id":      "7",
+		"@xmlns:x": "urn:x",
+		"item":     []any{"a", map[string]any{"@sku": "s2", "#text": "b"}},
+		"x:note":   "hi",
+		"empty":    "",
+		"p":        map[string]any{"b": "two", "#text": "one  three"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %#v\nwant %#v", got, want)
+	}
+}
+
+func TestToValueNames(t *testing.T) {
+	doc := mustParse(t, `<s:Envelope xmlns:s="urn:soap"><s:Body s:flag="1">x</s:Body></s:Envelope>`, ParseOptions{})
+	local := ToValue(doc.Root(), ValueOptions{Names: NamesLocal})
+	if !reflect.DeepEqual(local, map[string]any{"Envelope": map[string]any{"Body": map[string]any{"@flag": "1", "#text": "x"}}}) {
+		t.Errorf("local = %#v", local)
+	}
+	expanded := ToValue(doc.Root(), ValueOptions{Names: NamesExpanded})
+	if _, ok := expanded["{urn:soap}Envelope"].(map[string]any)["{urn:soap}Body"]; !ok {
+		t.Errorf("expanded = %#v", expanded)
+	}
+}
+
+// One <item> must decode the same way as two when the author asked for an
+// array.
+func TestForceArray(t *testing.T) {
+	doc := mustParse(t, `<order><item>a</item><note>n</note></order>`, ParseOptions{})
+	got := ToValue(doc.Root(), ValueOptions{ForceArray: []string{"item"}})
+	if items, ok := got["order"].(map[string]any)["item"].([]any); !ok || len(items) != 1 {
+		t.Fatalf("item = %#v", got["order"])
+	}
+}
+
+func TestKeepWhitespace(t *testing.T) {
+	doc := mustParse(t, "<a>\n\t<b>  x  </b>\n</a>", ParseOptions{})
+	got := ToValue(doc.Root(), ValueOptions{KeepWhitespace: true})
+	if !reflect.DeepEqual(got, map[string]any{"a": map[string]any{"b": "  x  "}}) {
+		t.Fatalf("got %#v", got)
+	}
+}
+
+func encode(t *testing.T, v any, opts BuildOptions) string {
+	t.Helper()
+	el, err := FromValue(v, opts)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	var b strings.Builder
+	if err := Write(&b, el, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestFromValue(t *testing.T) {
+	got := encode(t, map[string]any{"order": map[string]any{
+		"@id":  "7",
+		"line": []any{"a", map[string]any{"@qty": 2.0, "#text": "b"}},
+		"note": nil,
+		"tags": map[string]any{"tag": []any{}},
+	}}, BuildOptions{})
+	want := `<order id="7"><line>a</line><line qty="2">b</line><note/><tags/></order>`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestFromValueRootAndItems(t *testing.T) {
+	if got := encode(t, []any{1.5, []any{true}}, BuildOptions{}); got != `<root><item>1.5</item><item><item>true</item></item></root>` {
+		t.Errorf("array: %s", got)
+	}
+	if got := encode(t, []any{"x"}, BuildOptions{RootName: "list", ItemName: "entry"}); got != `<list><entry>x</entry></list>` {
+		t.Errorf("named: %s", got)
+	}
+	if got := encode(t, map[string]any{"a": "1", "b": "2"}, BuildOptions{}); got != `<root><a>1</a><b>2</b></root>` {
+		t.Errorf("several keys: %s", got)
+	}
+	if got := encode(t, map[string]any{"a": []any{"1", "2"}}, BuildOptions{}); got != `<root><a>1</a><a>2</a></root>` {
+		t.Errorf("one repeated key cannot be the root: %s", got)
+	}
+}
+
+func TestFromValueOrder(t *testing.T) {
+	got := encode(t, map[string]any{"p": map[string]any{"z": "1", "name": "n", "id": "i", "a": "2"}}, BuildOptions{Order: []string{"id", "name"}})
+	if got != `<p><id>i</id><name>n</name><a>2</a><z>1</z></p>` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	got := encode(t, map[string]any{"a": map[string]any{"@v": "x\"<&\n", "#text": "1 < 2 && ]]> \x00"}}, BuildOptions{})
+	if got != "<a v=\"x&quot;&lt;&amp;&#xA;\">1 &lt; 2 &amp;&amp; ]]&gt; �</a>" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	for _, v := range []any{
+		map[string]any{"has space": "x"},
+		map[string]any{"r": map[string]any{"1st": "x"}},
+		map[string]any{"r": map[string]any{"@a:b:c": "x"}},
+		map[string]any{"r": map[string]any{"@obj": map[string]any{}}},
+	} {
+		if _, err := FromValue(v, BuildOptions{}); err == nil {
+			t.Errorf("%v built", v)
+		}
+	}
+}
+
+// What xml_decode produces, xml_encode writes back.
+func TestValueRoundTrip(t *testing.T) {
+	in := `<s:Envelope xmlns:s="urn:soap"><s:Body><order id="7"><line>a</line><line>b</line><note>x<b>y</b></note></order></s:Body></s:Envelope>`
+	doc := mustParse(t, in, ParseOptions{})
+	out := encode(t, ToValue(doc.Root(), ValueOptions{}), BuildOptions{})
+	if out != `<s:Envelope xmlns:s="urn:soap"><s:Body><order id="7"><line>a</line><line>b</line><note><b>y</b>x</note></order></s:Body></s:Envelope>` {
+		t.Fatalf("got %s", out)
+	}
+}
+
+func write(t *testing.T, v any, build BuildOptions, opts WriteOptions) string {
+	t.Helper()
+	el, err := FromValue(v, build)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	var b strings.Builder
+	if err := Write(&b, el, opts); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return b.String()
+}
+
+func TestNamespaceDeclarations(t *testing.T) {
+	doc := map[string]any{"Invoice": map[string]any{"cbc:ID": "1", "cac:Party": map[string]any{"cbc:Name": "n"}}}
+	got := write(t, doc, BuildOptions{
+		Namespace:  "urn:inv",
+		Namespaces: map[string]string{"cbc": "urn:cbc", "cac": "urn:cac"},
+	}, WriteOptions{})
+	want := `<Invoice xmlns="urn:inv" xmlns:cac="urn:cac" xmlns:cbc="urn:cbc"><cac:Party><cbc:Name>n</cbc:Name></cac:Party><cbc:ID>1</cbc:ID></Invoice>`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if _, err := FromValue(doc, BuildOptions{Namespaces: map[string]string{"cbc": "urn:cbc"}}); err == nil || !strings.Contains(err.Error(), `"cac"`) {
+		t.Fatalf("undeclared prefix: err = %v", err)
+	}
+}
+
+// A declaration already in the document wins over the settings.
+func TestDocumentDeclarationWins(t *testing.T) {
+	doc := map[string]any{"a": map[string]any{"@xmlns": "urn:doc", "b": "x"}}
+	if got := write(t, doc, BuildOptions{Namespace: "urn:settings"}, WriteOptions{}); got != `<a xmlns="urn:doc"><b>x</b></a>` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestDeclarationAndIndent(t *testing.T) {
+	doc := map[string]any{"a": map[string]any{"b": map[string]any{"c": "x", "d": nil}, "e": map[string]any{"@k": "v", "#text": "t"}}}
+	got := write(t, doc, BuildOptions{}, WriteOptions{Declaration: true, Indent: "  "})
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<a>
+  <b>
+    <c>x</c>
+    <d/>
+  </b>
+  <e k="v">t</e>
+</a>
+`
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// Indenting an element with mixed content would change its text.
+func TestIndentLeavesMixedContent(t *testing.T) {
+	doc := mustParse(t, `<p>one <b>two</b> three</p>`, ParseOptions{})
+	var b strings.Builder
+	if err := Write(&b, doc, WriteOptions{Indent: "\t"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "<p>one <b>two</b> three</p>\n" {
+		t.Fatalf("got %q", b.String())
+	}
+}
+
+func TestCDATA(t *testing.T) {
+	doc := map[string]any{"env": map[string]any{"payload": "<x>a]]>b</x>", "other": map[string]any{"payload": "<y/>"}, "note": "<n/>"}}
+	got := write(t, doc, BuildOptions{}, WriteOptions{CDATA: []string{"/env/payload"}})
+	want := `<env><note>&lt;n/&gt;</note><other><payload>&lt;y/&gt;</payload></other><payload><![CDATA[<x>a]]]]><![CDATA[>b</x>]]></payload></env>`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if got := write(t, doc, BuildOptions{}, WriteOptions{CDATA: []string{"payload"}}); strings.Count(got, "<![CDATA[") != 3 {
+		t.Fatalf("bare name: %s", got)
+	}
+	// The section survives a parse: the text comes back as it went in.
+	parsed := mustParse(t, got, ParseOptions{})
+	if text := parsed.Root().Elements()[2].Text(); text != "<x>a]]>b</x>" {
+		t.Fatalf("text = %q", text)
+	}
+}
+
+func TestSingleByteEncoding(t *testing.T) {
+	doc := map[string]any{"a": map[string]any{"@v": "é", "#text": "café €"}}
+	got := write(t, doc, BuildOptions{}, WriteOptions{Declaration: true, Encoding: "ISO-8859-1"})
+	if got != `<?xml version="1.0" encoding="ISO-8859-1"?>`+"\n"+`<a v="&#xe9;">caf&#xe9; &#x20ac;</a>` {
+		t.Fatalf("got %s", got)
+	}
+	parsed := mustParse(t, got, ParseOptions{})
+	if parsed.Root().Text() != "café €" {
+		t.Fatalf("text = %q", parsed.Root().Text())
+	}
+	el, _ := FromValue(doc, BuildOptions{})
+	if err := Write(&strings.Builder{}, el, WriteOptions{Encoding: "UTF-16"}); err == nil {
+		t.Fatal("wrote UTF-16")
+	}
+}
+
+// A detached element takes along the declarations it needs from above, and
+// only those; declarations it makes itself stay where they are.
+func TestDetach(t *testing.T) {
+	doc := mustParse(t, `<s:Envelope xmlns:s="urn:soap" xmlns:unused="urn:u" xmlns="urn:default">`+
+		`<s:Body><Order s:mustUnderstand="1"><a:Line xmlns:a="urn:a"/></Order></s:Body></s:Envelope>`, ParseOptions{})
+	order := doc.Root().Elements()[0].Elements()[0]
+
+	el := Detach(order)
+	if el.Parent != nil || order.Parent == nil {
+		t.Fatal("Detach should copy, not move")
+	}
+	var b strings.Builder
+	if err := Write(&b, el, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `<Order xmlns="urn:default" xmlns:s="urn:soap" s:mustUnderstand="1"><a:Line xmlns:a="urn:a"/></Order>`
+	if b.String() != want {
+		t.Fatalf("got  %s\nwant %s", b.String(), want)
+	}
+	if _, err := Parse([]byte(b.String()), ParseOptions{}); err != nil {
+		t.Fatalf("detached element does not parse: %v", err)
+	}
+}
+
+// An element built into a parent may use the prefixes the parent declares.
+func TestFromValueIntoParent(t *testing.T) {
+	env := mustParse(t, `<s:Body xmlns:s="urn:soap" xmlns:r="urn:rates"/>`, ParseOptions{}).Root()
+	el, err := FromValue(map[string]any{"r:GetRate": map[string]any{"@s:mustUnderstand": "1"}}, BuildOptions{Parent: env})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if el.Parent != env || len(env.Children) != 1 || el.Space != "urn:rates" || len(el.Attrs) != 1 {
+		t.Fatalf("built %#v", el)
+	}
+	if _, err := FromValue(map[string]any{"x:GetRate": ""}, BuildOptions{Parent: env}); err == nil || len(env.Children) != 1 {
+		t.Fatalf("undeclared prefix: err = %v, children = %d", err, len(env.Children))
+	}
+}
+
+func canonical(t *testing.T, n *Node, opts CanonicalOptions) string {
+	t.Helper()
+	var b strings.Builder
+	if err := Canonicalize(&b, n, opts); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// The examples of the Canonical XML and Exclusive XML Canonicalization
+// specifications, and the cases signatures break on in practice.
+func TestCanonicalize(t *testing.T) {
+	elem2 := mustParse(t, `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org">
+  <n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+     <n3:stuff xmlns:n3="ftp://example.org"/>
+  </n1:elem2>
+</n0:local>`, ParseOptions{}).Root().Elements()[0]
+	undeclared := mustParse(t, `<a xmlns="urn:x"><b xmlns=""><c/></b></a>`, ParseOptions{})
+	enveloped := mustParse(t, `<a><s/><b/></a>`, ParseOptions{})
+	commented := mustParse(t, `<?pi x?><!--c--><a><!--in--></a><!--after-->`, ParseOptions{})
+	inclusive := mustParse(t, `<r xmlns:x="urn:x" xmlns:y="urn:y"><e/></r>`, ParseOptions{})
+
+	for _, tc := range []struct {
+		name string
+		n    *Node
+		opts CanonicalOptions
+		want string
+	}{
+		{"subtree", elem2, CanonicalOptions{}, `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+     <n3:stuff xmlns:n3="ftp://example.org"></n3:stuff>
+  </n1:elem2>`},
+		{"sorting", mustParse(t, `<e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm" xmlns:b="http://www.ietf.org" `+
+			`xmlns:a="http://www.w3.org" xmlns="http://example.org"/>`, ParseOptions{}), CanonicalOptions{},
+			`<e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>`},
+		{"escaping", mustParse(t, `<a t="&quot;&#9;&#10;&lt;>">x &amp; &lt; &gt; &#13;</a>`, ParseOptions{}), CanonicalOptions{},
+			`<a t="&quot;&#x9;&#xA;&lt;>">x &amp; &lt; &gt; &#xD;</a>`},
+		// As xmllint --exc-c14n has it: whitespace written in an attribute
+		// is a space, a reference to it stays a reference.
+		{"attribute whitespace", mustParse(t, "<a nl=\"x\ny\" t=\"a\tb\" crlf=\"p\r\nq\" r=\"&#xA;&#9;\"/>", ParseOptions{}), CanonicalOptions{},
+			`<a crlf="p q" nl="x y" r="&#xA;&#x9;" t="a b"></a>`},
+		{"default undeclared", undeclared, CanonicalOptions{}, `<a xmlns="urn:x"><b xmlns=""><c></c></b></a>`},
+		{"default never declared", undeclared.Root().Elements()[0], CanonicalOptions{}, `<b><c></c></b>`},
+		{"enveloped", enveloped, CanonicalOptions{Exclude: enveloped.Root().Elements()[0]}, `<a><b></b></a>`},
+		{"without comments", commented, CanonicalOptions{}, "<?pi x?>\n<a></a>"},
+		{"with comments", commented, CanonicalOptions{Comments: true}, "<?pi x?>\n<!--c-->\n<a><!--in--></a>\n<!--after-->"},
+		{"unused prefixes", inclusive.Root().Elements()[0], CanonicalOptions{}, `<e></e>`},
+		{"inclusive prefixes", inclusive.Root().Elements()[0], CanonicalOptions{InclusivePrefixes: []string{"x", "z"}}, `<e xmlns:x="urn:x"></e>`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := canonical(t, tc.n, tc.opts); got != tc.want {
+				t.Fatalf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package tree
+
+import "strings"
+
+const (
+	// AttrPrefix marks a key as an attribute: {"@id":"7"} is id="7".
+	AttrPrefix = "@"
+	// TextKey holds an element's text when it also has attributes or child
+	// elements; an element with neither is just its text.
+	TextKey = "#text"
+
+	// NamesPrefixed keys elements and attributes by name as written,
+	// soap:Body, and keeps xmlns declarations as attributes, so the document
+	// can be written back with the same prefixes.
+	NamesPrefixed = "prefixed"
+	// NamesLocal keys them by local name, Body, dropping declarations — for
+	// reading documents whose prefixes vary between senders.
+	NamesLocal = "local"
+	// NamesExpanded keys them as {namespace}local, which names each
+	// unambiguously whatever prefix the sender chose.
+	NamesExpanded = "expanded"
+)
+
+// ValueOptions adjusts how ToValue names and shapes things.
+type ValueOptions struct {
+	Names string
+	// ForceArray names elements that are always given as an array, even when
+	// a document has only one — otherwise one <item> is an object and two
+	// are an array, and every expression reading them breaks on the first
+	// single-item document. Each matches the element's key or local name.
+	ForceArray []string
+	// KeepWhitespace keeps text exactly as written. Otherwise it is trimmed.
+	// Whitespace between child elements is indentation and is dropped either
+	// way.
+	KeepWhitespace bool
+}
+
+// ToValue turns an element into the generic tree, {name: value}. The value of
+// an element with no attributes and no child elements is its text; otherwise
+// it is an object of @attributes, child elements by name — an array when
+// repeated — and #text. Comments and processing instructions are left out.
+func ToValue(el *Node, opts ValueOptions) map[string]any {
+	c := converter{opts: opts, force: map[string]bool{}}
+	for _, name := range opts.ForceArray {
+		c.force[name] = true
+	}
+	return map[string]any{c.name(el.Space, el.Prefix, el.Local): c.element(el)}
+}
+
+type converter struct {
+	opts  ValueOptions
+	force map[string]bool
+}
+
+func (c *converter) name(space, prefix, local string) string {
+	switch c.opts.Names {
+	case NamesLocal:
+		return local
+	case NamesExpanded:
+		if space == "" {
+			return local
+		}
+		return "{" + space + "}" + local
+	}
+	return qualified(prefix, local)
+}
+
+func (c *converter) element(el *Node) any {
+	obj := map[string]any{}
+	for _, a := range el.Attrs {
+		if a.IsNamespaceDecl() {
+			if c.opts.Names == NamesPrefixed || c.opts.Names == "" {
+				obj[AttrPrefix+a.Name()] = a.Value
+			}
+			continue
+		}
+		obj[AttrPrefix+c.name(a.Space, a.Prefix, a.Local)] = a.Value
+	}
+
+	var text strings.Builder
+	hasElements := false
+	for _, child := range el.Children {
+		switch child.Kind {
+		case TextNode:
+			text.WriteString(child.Data)
+		case ElementNode:
+			hasElements = true
+			key := c.name(child.Space, child.Prefix, child.Local)
+			v := c.element(child)
+			switch prev := obj[key].(type) {
+			case nil:
+				if c.force[key] || c.force[child.Local] {
+					obj[key] = []any{v}
+				} else {
+					obj[key] = v
+				}
+			case []any:
+				obj[key] = append(prev, v)
+			default:
+				obj[key] = []any{prev, v}
+			}
+		}
+	}
+
+	s := text.String()
+	if !c.opts.KeepWhitespace || (hasElements && strings.TrimSpace(s) == "") {
+		s = strings.TrimSpace(s)
+	}
+	if len(obj) == 0 {
+		return s
+	}
+	if s != "" {
+		obj[TextKey] = s
+	}
+	return obj
+}
@@ -0,0 +1,252 @@
+package tree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// WriteOptions adjusts how Write lays a document out.
+type WriteOptions struct {
+	// Declaration writes <?xml version="1.0" encoding="…"?> first.
+	Declaration bool
+	// Encoding is named in the declaration; empty is UTF-8. The output is a
+	// string either way, so for a single-byte encoding every character
+	// outside ASCII is written as a character reference, which reads the
+	// same in all of them.
+	Encoding string
+	// Indent, when set, puts each element on its own line, indented by it
+	// once per level. Elements holding text are written as they are, since
+	// whitespace added inside them would become part of the text.
+	Indent string
+	// CDATA lists the elements whose text is written as a CDATA section: a
+	// path of element names from the root such as /Envelope/Body/payload, *
+	// matching any one name, or a bare name matching that element anywhere.
+	CDATA []string
+}
+
+// Write serializes n and everything under it. Empty elements are written
+// self-closed; text and attribute values are escaped as little as XML allows,
+// so what a reader sees is what the value held.
+func Write(w io.Writer, n *Node, opts WriteOptions) error {
+	wr := &writer{w: bufio.NewWriter(w), indent: opts.Indent}
+	switch strings.ToLower(opts.Encoding) {
+	case "", "utf-8", "utf8":
+		if opts.Encoding == "" {
+			opts.Encoding = "UTF-8"
+		}
+	case "iso-8859-1", "latin1", "windows-1252", "us-ascii":
+		wr.ascii = true
+	default:
+		return fmt.Errorf("unsupported encoding %q: use UTF-8, ISO-8859-1, windows-1252 or US-ASCII", opts.Encoding)
+	}
+	for _, path := range opts.CDATA {
+		wr.cdata = append(wr.cdata, strings.Split(path, "/"))
+	}
+
+	if opts.Declaration {
+		wr.w.WriteString(`<?xml version="1.0" encoding="` + opts.Encoding + `"?>` + "\n")
+	}
+	if err := wr.node(n, nil, 0); err != nil {
+		return err
+	}
+	if opts.Indent != "" {
+		wr.w.WriteString("\n")
+	}
+	return wr.w.Flush()
+}
+
+type writer struct {
+	w      *bufio.Writer
+	indent string
+	ascii  bool
+	cdata  [][]string
+}
+
+// node writes n. path is the names of the elements it is in, n's own
+// included when it is one; depth is its indentation level.
+func (wr *writer) node(n *Node, path []string, depth int) error {
+	w := wr.w
+	switch n.Kind {
+	case DocumentNode:
+		for i, c := range n.Children {
+			if i > 0 && wr.indent != "" {
+				w.WriteString("\n")
+			}
+			if err := wr.node(c, path, 0); err != nil {
+				return err
+			}
+		}
+	case TextNode:
+		if wr.isCDATA(path) {
+			wr.writeCDATA(n.Data)
+		} else {
+			w.WriteString(wr.escape(n.Data, false))
+		}
+	case CommentNode:
+		w.WriteString("<!--" + n.Data + "-->")
+	case ProcInstNode:
+		w.WriteString("<?" + n.Target)
+		if n.Data != "" {
+			w.WriteString(" " + n.Data)
+		}
+		w.WriteString("?>")
+	case ElementNode:
+		name := n.Name()
+		if wr.ascii && !ascii(name) {
+			return fmt.Errorf("element <%s>: a name outside ASCII cannot be written in a single-byte encoding", name)
+		}
+		path = append(path[:len(path):len(path)], name)
+		w.WriteString("<" + name)
+		for _, a := range n.Attrs {
+			if wr.ascii && !ascii(a.Name()) {
+				return fmt.Errorf("attribute %s: a name outside ASCII cannot be written in a single-byte encoding", a.Name())
+			}
+			w.WriteString(" " + a.Name() + `="` + wr.escape(a.Value, true) + `"`)
+		}
+		if len(n.Children) == 0 {
+			w.WriteString("/>")
+			return nil
+		}
+		w.WriteString(">")
+		block := wr.indent != "" && !hasText(n)
+		for _, c := range n.Children {
+			if block {
+				w.WriteString("\n" + strings.Repeat(wr.indent, depth+1))
+			}
+			if err := wr.node(c, path, depth+1); err != nil {
+				return err
+			}
+		}
+		if block {
+			w.WriteString("\n" + strings.Repeat(wr.indent, depth))
+		}
+		w.WriteString("</" + name + ">")
+	}
+	return nil
+}
+
+func hasText(n *Node) bool {
+	for _, c := range n.Children {
+		if c.Kind == TextNode {
+			return true
+		}
+	}
+	return false
+}
+
+func (wr *writer) isCDATA(path []string) bool {
+	for _, pattern := range wr.cdata {
+		if len(pattern) == 1 {
+			// A bare name, matched wherever the element is.
+			if len(path) > 0 && (pattern[0] == "*" || pattern[0] == path[len(path)-1]) {
+				return true
+			}
+			continue
+		}
+		// An absolute path splits to a leading empty name.
+		if len(pattern)-1 != len(path) {
+			continue
+		}
+		match := true
+		for i, name := range pattern[1:] {
+			if name != "*" && name != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCDATA writes s as a CDATA section. A section cannot contain ]]>, so
+// one in s ends the section after ]] and starts the next with >.
+func (wr *writer) writeCDATA(s string) {
+	s = strings.ReplaceAll(s, "]]>", "]]]]><![CDATA[>")
+	var b strings.Builder
+	for _, r := range s {
+		if !xmlChar(r) {
+			r = '\uFFFD'
+		}
+		if wr.ascii && r >= utf8.RuneSelf {
+			// A character reference means nothing inside CDATA, so the
+			// section is closed around it.
+			b.WriteString("]]>&#x" + strconv.FormatInt(int64(r), 16) + ";<![CDATA[")
+			continue
+		}
+		b.WriteRune(r)
+	}
+	wr.w.WriteString("<![CDATA[" + b.String() + "]]>")
+}
+
+func (wr *writer) escape(s string, attr bool) string {
+	s = escape(s, attr)
+	if !wr.ascii || ascii(s) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteString("&#x" + strconv.FormatInt(int64(r), 16) + ";")
+	}
+	return b.String()
+}
+
+func ascii(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// escape escapes what text cannot hold literally: > too, since ]]> is not
+// allowed in text, and a carriage return, which a parser would turn into a
+// line feed. In an attribute it also escapes the quote and the whitespace a
+// parser would otherwise normalize to spaces. Characters XML 1.0 cannot
+// contain at all, such as NUL, become U+FFFD, as encoding/xml does, rather
+// than a document no parser accepts.
+func escape(s string, attr bool) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>' && !attr:
+			b.WriteString("&gt;")
+		case r == '"' && attr:
+			b.WriteString("&quot;")
+		case r == '\t' && attr:
+			b.WriteString("&#x9;")
+		case r == '\n' && attr:
+			b.WriteString("&#xA;")
+		case r == '\r':
+			b.WriteString("&#xD;")
+		case !xmlChar(r):
+			b.WriteRune('\uFFFD')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func xmlChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= 0x10FFFF)
+}
@@ -0,0 +1,524 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+// context is the XPath evaluation context: the node, its position in the
+// node-set being filtered and that node-set's size.
+type context struct {
+	ev   *evaluator
+	node Node
+	pos  int
+	size int
+}
+
+type expr interface {
+	eval(c *context) (any, error)
+}
+
+type literal struct {
+	v any
+}
+
+func (l literal) eval(*context) (any, error) {
+	return l.v, nil
+}
+
+type negateExpr struct {
+	x expr
+}
+
+func (e *negateExpr) eval(c *context) (any, error) {
+	v, err := e.x.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return -Number(v), nil
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *binaryExpr) eval(c *context) (any, error) {
+	left, err := e.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	// and and or stop as soon as the left side decides.
+	switch e.op {
+	case "and":
+		if !Boolean(left) {
+			return false, nil
+		}
+	case "or":
+		if Boolean(left) {
+			return true, nil
+		}
+	}
+	right, err := e.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "and", "or":
+		return Boolean(right), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compare(e.op, left, right), nil
+	}
+	l, r := Number(left), Number(right)
+	switch e.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "div":
+		return l / r, nil
+	}
+	return math.Mod(l, r), nil
+}
+
+// compare applies a comparison as XPath 1.0 §3.4 defines it: against a
+// node-set it holds when it holds for any one of its nodes.
+func compare(op string, left, right any) bool {
+	ln, lset := left.([]Node)
+	rn, rset := right.([]Node)
+	switch {
+	case lset && rset:
+		for _, a := range ln {
+			for _, b := range rn {
+				if compareAtoms(op, a.Text(), b.Text()) {
+					return true
+				}
+			}
+		}
+		return false
+	case lset:
+		if b, ok := right.(bool); ok {
+			return compareAtoms(op, Boolean(left), b)
+		}
+		for _, a := range ln {
+			if compareAtoms(op, atom(a.Text(), right), right) {
+				return true
+			}
+		}
+		return false
+	case rset:
+		if a, ok := left.(bool); ok {
+			return compareAtoms(op, a, Boolean(right))
+		}
+		for _, b := range rn {
+			if compareAtoms(op, left, atom(b.Text(), left)) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareAtoms(op, left, right)
+}
+
+// atom is a node's text as the type of the value it is compared with.
+func atom(text string, other any) any {
+	if _, ok := other.(float64); ok {
+		return Number(text)
+	}
+	return text
+}
+
+func compareAtoms(op string, left, right any) bool {
+	if op != "=" && op != "!=" {
+		l, r := Number(left), Number(right)
+		switch op {
+		case "<":
+			return l < r
+		case "<=":
+			return l <= r
+		case ">":
+			return l > r
+		}
+		return l >= r
+	}
+
+	var equal bool
+	_, lb := left.(bool)
+	_, rb := right.(bool)
+	_, lf := left.(float64)
+	_, rf := right.(float64)
+	switch {
+	case lb || rb:
+		equal = Boolean(left) == Boolean(right)
+	case lf || rf:
+		equal = Number(left) == Number(right)
+	default:
+		equal = String(left) == String(right)
+	}
+	return equal == (op == "=")
+}
+
+type unionExpr struct {
+	left, right expr
+}
+
+func (e *unionExpr) eval(c *context) (any, error) {
+	left, err := nodeSet(e.left, c, "|")
+	if err != nil {
+		return nil, err
+	}
+	right, err := nodeSet(e.right, c, "|")
+	if err != nil {
+		return nil, err
+	}
+	return c.ev.sorted(append(append([]Node{}, left...), right...)), nil
+}
+
+func nodeSet(x expr, c *context, what string) ([]Node, error) {
+	v, err := x.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]Node)
+	if !ok {
+		return nil, fmt.Errorf("%s needs a node-set, not a %s", what, typeName(v))
+	}
+	return nodes, nil
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case []Node:
+		return NodeSetType
+	case string:
+		return StringType
+	case float64:
+		return NumberType
+	}
+	return BooleanType
+}
+
+type filterExpr struct {
+	primary expr
+	preds   []expr
+}
+
+func (e *filterExpr) eval(c *context) (any, error) {
+	nodes, err := nodeSet(e.primary, c, "a predicate")
+	if err != nil {
+		return nil, err
+	}
+	for _, pred := range e.preds {
+		if nodes, err = filter(c.ev, nodes, pred); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// filter keeps the nodes pred holds for, positions counted in the order nodes
+// are given. A number as the predicate's value is a position to match.
+func filter(ev *evaluator, nodes []Node, pred expr) ([]Node, error) {
+	var out []Node
+	for i, n := range nodes {
+		v, err := pred.eval(&context{ev: ev, node: n, pos: i + 1, size: len(nodes)})
+		if err != nil {
+			return nil, err
+		}
+		keep := Boolean(v)
+		if f, ok := v.(float64); ok {
+			keep = f == float64(i+1)
+		}
+		if keep {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+type pathExpr struct {
+	// filter starts the path when it is not a location path, as in
+	// (//a)[1]/b; absolute starts it at the document.
+	filter   expr
+	absolute bool
+	steps    []*step
+}
+
+func (e *pathExpr) eval(c *context) (any, error) {
+	var nodes []Node
+	switch {
+	case e.filter != nil:
+		var err error
+		if nodes, err = nodeSet(e.filter, c, "/"); err != nil {
+			return nil, err
+		}
+	case e.absolute:
+		doc := c.node.node
+		for doc.Parent != nil {
+			doc = doc.Parent
+		}
+		nodes = []Node{{node: doc, attr: -1}}
+	default:
+		nodes = []Node{c.node}
+	}
+	for _, s := range e.steps {
+		var next []Node
+		for _, n := range nodes {
+			selected, err := s.selectFrom(c.ev, n)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, selected...)
+		}
+		nodes = c.ev.sorted(next)
+	}
+	return nodes, nil
+}
+
+type axis int
+
+const (
+	axisChild axis = iota
+	axisDescendant
+	axisDescendantOrSelf
+	axisParent
+	axisAncestor
+	axisAncestorOrSelf
+	axisFollowingSibling
+	axisPrecedingSibling
+	axisFollowing
+	axisPreceding
+	axisAttribute
+	axisSelf
+	axisNamespace
+)
+
+var axes = map[string]axis{
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"parent":             axisParent,
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"following-sibling":  axisFollowingSibling,
+	"preceding-sibling":  axisPrecedingSibling,
+	"following":          axisFollowing,
+	"preceding":          axisPreceding,
+	"attribute":          axisAttribute,
+	"self":               axisSelf,
+	"namespace":          axisNamespace,
+}
+
+// reverse reports whether positions on the axis count back from the context
+// node rather than forward in document order.
+func (a axis) reverse() bool {
+	return a == axisAncestor || a == axisAncestorOrSelf || a == axisPreceding || a == axisPrecedingSibling
+}
+
+type testKind int
+
+const (
+	testName    testKind = iota // a name, in a namespace or none
+	testSpace                   // prefix:*
+	testAny                     // *
+	testNode                    // node()
+	testText                    // text()
+	testComment                 // comment()
+	testPI                      // processing-instruction(), local holding the target if one is given
+)
+
+var nodeTypes = map[string]testKind{
+	"node":                   testNode,
+	"text":                   testText,
+	"comment":                testComment,
+	"processing-instruction": testPI,
+}
+
+type nodeTest struct {
+	kind         testKind
+	space, local string
+}
+
+type step struct {
+	axis  axis
+	test  nodeTest
+	preds []expr
+}
+
+func (s *step) selectFrom(ev *evaluator, n Node) ([]Node, error) {
+	var nodes []Node
+	for _, m := range s.axis.nodes(n) {
+		if s.matches(m) {
+			nodes = append(nodes, m)
+		}
+	}
+	nodes = ev.sorted(nodes)
+	if s.axis.reverse() {
+		for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+			nodes[i], nodes[j] = nodes[j], nodes[i]
+		}
+	}
+	for _, pred := range s.preds {
+		var err error
+		if nodes, err = filter(ev, nodes, pred); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// matches applies the node test. A name or * matches the axis's principal
+// node type: attributes on the attribute axis, elements everywhere else.
+func (s *step) matches(n Node) bool {
+	switch s.test.kind {
+	case testNode:
+		return true
+	case testText:
+		return n.attr < 0 && n.node.Kind == tree.TextNode
+	case testComment:
+		return n.attr < 0 && n.node.Kind == tree.CommentNode
+	case testPI:
+		return n.attr < 0 && n.node.Kind == tree.ProcInstNode && (s.test.local == "" || n.node.Target == s.test.local)
+	}
+	if (s.axis == axisAttribute) != (n.attr >= 0) {
+		return false
+	}
+	if n.attr < 0 && n.node.Kind != tree.ElementNode {
+		return false
+	}
+	switch s.test.kind {
+	case testSpace:
+		return n.Namespace() == s.test.space
+	case testName:
+		return n.Namespace() == s.test.space && n.LocalName() == s.test.local
+	}
+	return true
+}
+
+// nodes lists the nodes on the axis from n, in no particular order.
+func (a axis) nodes(n Node) []Node {
+	var out []Node
+	add := func(m *tree.Node) {
+		out = append(out, Node{node: m, attr: -1})
+	}
+	var descend func(m *tree.Node)
+	descend = func(m *tree.Node) {
+		for _, c := range m.Children {
+			add(c)
+			descend(c)
+		}
+	}
+	isAttr := n.attr >= 0
+	// An attribute's parent is its element, but it is not the element's child.
+	parent := n.node.Parent
+	if isAttr {
+		parent = n.node
+	}
+
+	switch a {
+	case axisSelf:
+		out = append(out, n)
+	case axisChild:
+		if !isAttr {
+			for _, c := range n.node.Children {
+				add(c)
+			}
+		}
+	case axisDescendantOrSelf:
+		out = append(out, n)
+		fallthrough
+	case axisDescendant:
+		if !isAttr {
+			descend(n.node)
+		}
+	case axisParent:
+		if parent != nil {
+			add(parent)
+		}
+	case axisAncestorOrSelf:
+		out = append(out, n)
+		fallthrough
+	case axisAncestor:
+		for m := parent; m != nil; m = m.Parent {
+			add(m)
+		}
+	case axisAttribute:
+		if !isAttr {
+			for i, attr := range n.node.Attrs {
+				// Namespace declarations are not attributes in the XPath
+				// data model.
+				if !attr.IsNamespaceDecl() {
+					out = append(out, Node{node: n.node, attr: i})
+				}
+			}
+		}
+	case axisFollowingSibling, axisPrecedingSibling:
+		if isAttr || parent == nil {
+			break
+		}
+		after := false
+		for _, c := range parent.Children {
+			if c == n.node {
+				after = true
+				continue
+			}
+			if after == (a == axisFollowingSibling) {
+				add(c)
+			}
+		}
+	case axisFollowing:
+		m := n.node
+		if isAttr {
+			// What follows an attribute starts with its element's content.
+			descend(m)
+		}
+		for ; m.Parent != nil; m = m.Parent {
+			after := false
+			for _, c := range m.Parent.Children {
+				if after {
+					add(c)
+					descend(c)
+				}
+				after = after || c == m
+			}
+		}
+	case axisPreceding:
+		for m := n.node; m.Parent != nil; m = m.Parent {
+			for _, c := range m.Parent.Children {
+				if c == m {
+					break
+				}
+				add(c)
+				descend(c)
+			}
+		}
+	}
+	return out
+}
+
+type callExpr struct {
+	name string
+	fn   function
+	args []expr
+}
+
+func (e *callExpr) eval(c *context) (any, error) {
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		v, err := arg.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := e.fn.call(c, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", e.name, err)
+	}
+	return v, nil
+}
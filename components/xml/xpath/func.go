@@ -0,0 +1,226 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+// function is one function of the core library. max is -1 when any number of
+// arguments beyond min is allowed.
+type function struct {
+	min, max int
+	returns  string
+	call     func(c *context, args []any) (any, error)
+}
+
+// functions is the XPath 1.0 core function library, less id(), which needs
+// the DTD a document's IDs are declared in.
+var functions = map[string]function{
+	"last": {0, 0, NumberType, func(c *context, _ []any) (any, error) {
+		return float64(c.size), nil
+	}},
+	"position": {0, 0, NumberType, func(c *context, _ []any) (any, error) {
+		return float64(c.pos), nil
+	}},
+	"count": {1, 1, NumberType, func(_ *context, args []any) (any, error) {
+		nodes, err := nodeArg(args[0])
+		return float64(len(nodes)), err
+	}},
+	"local-name": {0, 1, StringType, func(c *context, args []any) (any, error) {
+		n, ok, err := firstNode(c, args)
+		if !ok {
+			return "", err
+		}
+		return n.LocalName(), nil
+	}},
+	"namespace-uri": {0, 1, StringType, func(c *context, args []any) (any, error) {
+		n, ok, err := firstNode(c, args)
+		if !ok {
+			return "", err
+		}
+		return n.Namespace(), nil
+	}},
+	"name": {0, 1, StringType, func(c *context, args []any) (any, error) {
+		n, ok, err := firstNode(c, args)
+		if !ok {
+			return "", err
+		}
+		return n.Name(), nil
+	}},
+
+	"string": {0, 1, StringType, func(c *context, args []any) (any, error) {
+		return stringArg(c, args), nil
+	}},
+	"concat": {2, -1, StringType, func(_ *context, args []any) (any, error) {
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(String(a))
+		}
+		return b.String(), nil
+	}},
+	"starts-with": {2, 2, BooleanType, func(_ *context, args []any) (any, error) {
+		return strings.HasPrefix(String(args[0]), String(args[1])), nil
+	}},
+	"contains": {2, 2, BooleanType, func(_ *context, args []any) (any, error) {
+		return strings.Contains(String(args[0]), String(args[1])), nil
+	}},
+	"substring-before": {2, 2, StringType, func(_ *context, args []any) (any, error) {
+		before, _, found := strings.Cut(String(args[0]), String(args[1]))
+		if !found {
+			return "", nil
+		}
+		return before, nil
+	}},
+	"substring-after": {2, 2, StringType, func(_ *context, args []any) (any, error) {
+		_, after, _ := strings.Cut(String(args[0]), String(args[1]))
+		return after, nil
+	}},
+	"substring": {2, 3, StringType, func(_ *context, args []any) (any, error) {
+		return substring(String(args[0]), args[1:]), nil
+	}},
+	"string-length": {0, 1, NumberType, func(c *context, args []any) (any, error) {
+		return float64(utf8.RuneCountInString(stringArg(c, args))), nil
+	}},
+	"normalize-space": {0, 1, StringType, func(c *context, args []any) (any, error) {
+		return strings.Join(strings.Fields(stringArg(c, args)), " "), nil
+	}},
+	"translate": {3, 3, StringType, func(_ *context, args []any) (any, error) {
+		return translate(String(args[0]), String(args[1]), String(args[2])), nil
+	}},
+
+	"boolean": {1, 1, BooleanType, func(_ *context, args []any) (any, error) {
+		return Boolean(args[0]), nil
+	}},
+	"not": {1, 1, BooleanType, func(_ *context, args []any) (any, error) {
+		return !Boolean(args[0]), nil
+	}},
+	"true": {0, 0, BooleanType, func(*context, []any) (any, error) {
+		return true, nil
+	}},
+	"false": {0, 0, BooleanType, func(*context, []any) (any, error) {
+		return false, nil
+	}},
+	"lang": {1, 1, BooleanType, func(c *context, args []any) (any, error) {
+		return lang(c.node, String(args[0])), nil
+	}},
+
+	"number": {0, 1, NumberType, func(c *context, args []any) (any, error) {
+		if len(args) == 0 {
+			return Number(c.node.Text()), nil
+		}
+		return Number(args[0]), nil
+	}},
+	"sum": {1, 1, NumberType, func(_ *context, args []any) (any, error) {
+		nodes, err := nodeArg(args[0])
+		var sum float64
+		for _, n := range nodes {
+			sum += Number(n.Text())
+		}
+		return sum, err
+	}},
+	"floor": {1, 1, NumberType, func(_ *context, args []any) (any, error) {
+		return math.Floor(Number(args[0])), nil
+	}},
+	"ceiling": {1, 1, NumberType, func(_ *context, args []any) (any, error) {
+		return math.Ceil(Number(args[0])), nil
+	}},
+	"round": {1, 1, NumberType, func(_ *context, args []any) (any, error) {
+		return round(Number(args[0])), nil
+	}},
+}
+
+func nodeArg(v any) ([]Node, error) {
+	nodes, ok := v.([]Node)
+	if !ok {
+		return nil, fmt.Errorf("the argument must be a node-set, not a %s", typeName(v))
+	}
+	return nodes, nil
+}
+
+// firstNode is the node a name function is about: the first of its argument,
+// or the context node without one.
+func firstNode(c *context, args []any) (Node, bool, error) {
+	if len(args) == 0 {
+		return c.node, true, nil
+	}
+	nodes, err := nodeArg(args[0])
+	if err != nil || len(nodes) == 0 {
+		return Node{}, false, err
+	}
+	return nodes[0], true, nil
+}
+
+func stringArg(c *context, args []any) string {
+	if len(args) == 0 {
+		return c.node.Text()
+	}
+	return String(args[0])
+}
+
+// substring counts characters from 1 and rounds its arguments, so that
+// substring("12345", 1.5, 2.6) is "234" and a NaN selects nothing.
+func substring(s string, args []any) string {
+	start := round(Number(args[0]))
+	end := math.Inf(1)
+	if len(args) > 1 {
+		end = start + round(Number(args[1]))
+	}
+	var b strings.Builder
+	pos := 1.0
+	for _, r := range s {
+		if pos >= start && pos < end {
+			b.WriteRune(r)
+		}
+		pos++
+	}
+	return b.String()
+}
+
+func translate(s, from, to string) string {
+	toRunes := []rune(to)
+	mapping := map[rune]int{}
+	for i, r := range []rune(from) {
+		// The first occurrence of a character in from decides.
+		if _, seen := mapping[r]; !seen {
+			mapping[r] = i
+		}
+	}
+	var b strings.Builder
+	for _, r := range s {
+		i, ok := mapping[r]
+		switch {
+		case !ok:
+			b.WriteRune(r)
+		case i < len(toRunes):
+			b.WriteRune(toRunes[i])
+		}
+	}
+	return b.String()
+}
+
+// round rounds half up, toward positive infinity, as XPath does.
+func round(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	if f < 0 && f >= -0.5 {
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
+
+// lang reports whether the xml:lang in force at n is lang or a sublanguage
+// of it, ignoring case.
+func lang(n Node, lang string) bool {
+	for m := n.node; m != nil; m = m.Parent {
+		if v, ok := m.Attr(tree.XMLNamespace, "lang"); ok {
+			v, lang = strings.ToLower(v), strings.ToLower(lang)
+			return v == lang || strings.HasPrefix(v, lang+"-")
+		}
+	}
+	return false
+}
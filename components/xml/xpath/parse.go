@@ -0,0 +1,557 @@
+package xpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+type tokenKind int
+
+const (
+	tEOF      tokenKind = iota
+	tName               // a name test: local, prefix:local, prefix:* or *
+	tNodeType           // comment, text, processing-instruction or node, before (
+	tFunc               // a function name, before (
+	tAxis               // an axis name, before ::
+	tOp                 // an operator, including and, or, div and mod
+	tLiteral
+	tNumber
+	tVar
+	tPunct // ( ) [ ] . .. @ , ::
+)
+
+type token struct {
+	kind tokenKind
+	s    string
+	n    float64
+	pos  int
+}
+
+// lex splits an expression into tokens, resolving the ambiguities XPath 1.0
+// §3.7 resolves by what precedes a token: * is multiplication and and, or,
+// div and mod are operators only after something that can end an operand.
+func lex(expr string) ([]token, error) {
+	var toks []token
+	operandEnded := func() bool {
+		if len(toks) == 0 {
+			return false
+		}
+		last := toks[len(toks)-1]
+		switch last.kind {
+		case tOp, tAxis:
+			return false
+		case tPunct:
+			return last.s == ")" || last.s == "]" || last.s == "." || last.s == ".."
+		}
+		return true
+	}
+
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("position %d: unterminated string", i)
+			}
+			toks = append(toks, token{kind: tLiteral, s: expr[i+1 : i+1+end], pos: start})
+			i += end + 2
+			continue
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(expr) && expr[i+1] >= '0' && expr[i+1] <= '9'):
+			for i < len(expr) && (expr[i] >= '0' && expr[i] <= '9' || expr[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(expr[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("position %d: bad number %q", start, expr[start:i])
+			}
+			toks = append(toks, token{kind: tNumber, n: n, pos: start})
+			continue
+		case c == '.':
+			if strings.HasPrefix(expr[i:], "..") {
+				toks = append(toks, token{kind: tPunct, s: "..", pos: start})
+				i += 2
+			} else {
+				toks = append(toks, token{kind: tPunct, s: ".", pos: start})
+				i++
+			}
+			continue
+		case c == ':' && strings.HasPrefix(expr[i:], "::"):
+			toks = append(toks, token{kind: tPunct, s: "::", pos: start})
+			i += 2
+			continue
+		case strings.IndexByte("()[]@,", c) >= 0:
+			toks = append(toks, token{kind: tPunct, s: string(c), pos: start})
+			i++
+			continue
+		case c == '$':
+			i++
+			name := scanName(expr, &i)
+			if name == "" {
+				return nil, fmt.Errorf("position %d: $ must be followed by a variable name", start)
+			}
+			toks = append(toks, token{kind: tVar, s: name, pos: start})
+			continue
+		case c == '*':
+			if operandEnded() {
+				toks = append(toks, token{kind: tOp, s: "*", pos: start})
+			} else {
+				toks = append(toks, token{kind: tName, s: "*", pos: start})
+			}
+			i++
+			continue
+		}
+
+		if op := operator(expr[i:]); op != "" {
+			toks = append(toks, token{kind: tOp, s: op, pos: start})
+			i += len(op)
+			continue
+		}
+
+		name := scanName(expr, &i)
+		if name == "" {
+			return nil, fmt.Errorf("position %d: unexpected %q", start, expr[start:start+1])
+		}
+		if operandEnded() {
+			switch name {
+			case "and", "or", "div", "mod":
+				toks = append(toks, token{kind: tOp, s: name, pos: start})
+				continue
+			}
+			return nil, fmt.Errorf("position %d: expected an operator, found %q", start, name)
+		}
+		// prefix:local and prefix:* are one name test.
+		if i < len(expr) && expr[i] == ':' && !strings.HasPrefix(expr[i:], "::") {
+			i++
+			if i < len(expr) && expr[i] == '*' {
+				i++
+				toks = append(toks, token{kind: tName, s: name + ":*", pos: start})
+				continue
+			}
+			local := scanName(expr, &i)
+			if local == "" {
+				return nil, fmt.Errorf("position %d: expected a local name after %s:", start, name)
+			}
+			name += ":" + local
+			if next := peekNonSpace(expr, i); next == '(' {
+				toks = append(toks, token{kind: tFunc, s: name, pos: start})
+			} else {
+				toks = append(toks, token{kind: tName, s: name, pos: start})
+			}
+			continue
+		}
+		switch next := peekNonSpace(expr, i); {
+		case next == '(':
+			switch name {
+			case "comment", "text", "processing-instruction", "node":
+				toks = append(toks, token{kind: tNodeType, s: name, pos: start})
+			default:
+				toks = append(toks, token{kind: tFunc, s: name, pos: start})
+			}
+		case next == ':' && strings.HasPrefix(strings.TrimLeft(expr[i:], " \t\r\n"), "::"):
+			toks = append(toks, token{kind: tAxis, s: name, pos: start})
+		default:
+			toks = append(toks, token{kind: tName, s: name, pos: start})
+		}
+	}
+	return append(toks, token{kind: tEOF, pos: len(expr)}), nil
+}
+
+func operator(s string) string {
+	for _, op := range []string{"//", "!=", "<=", ">=", "/", "|", "+", "-", "=", "<", ">"} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// scanName reads an NCName at *i.
+func scanName(s string, i *int) string {
+	start := *i
+	for j, r := range s[start:] {
+		ok := unicode.IsLetter(r) || r == '_' || (j > 0 && (unicode.IsDigit(r) || r == '-' || r == '.' || unicode.Is(unicode.Mn, r)))
+		if !ok {
+			*i = start + j
+			return s[start:*i]
+		}
+	}
+	*i = len(s)
+	return s[start:]
+}
+
+func peekNonSpace(s string, i int) byte {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	if i < len(s) {
+		return s[i]
+	}
+	return 0
+}
+
+// parser is a recursive descent over the XPath 1.0 grammar, one method per
+// production.
+type parser struct {
+	toks       []token
+	i          int
+	namespaces map[string]string
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) is(kind tokenKind, s string) bool {
+	t := p.peek()
+	return t.kind == kind && t.s == s
+}
+
+func (p *parser) expect(kind tokenKind, s string) error {
+	if !p.is(kind, s) {
+		return p.errorf("expected %q", s)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	found := t.s
+	switch t.kind {
+	case tEOF:
+		found = "the end"
+	case tNumber:
+		found = strconv.FormatFloat(t.n, 'f', -1, 64)
+	case tLiteral:
+		found = strconv.Quote(t.s)
+	}
+	return fmt.Errorf("position %d: %s, found %s", t.pos, fmt.Sprintf(format, args...), found)
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.binary(0)
+}
+
+// levels lists the binary operators by precedence, loosest first.
+var levels = [][]string{
+	{"or"},
+	{"and"},
+	{"=", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "div", "mod"},
+}
+
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(levels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tOp || !contains(levels[level], t.s) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: t.s, left: left, right: right}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.is(tOp, "-") {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateExpr{x: x}, nil
+	}
+	return p.union()
+}
+
+func (p *parser) union() (expr, error) {
+	left, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(tOp, "|") {
+		p.next()
+		right, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		left = &unionExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) path() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tLiteral, t.kind == tNumber, t.kind == tVar, t.kind == tFunc, t.kind == tPunct && t.s == "(":
+		filter, err := p.filter()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(tOp, "/") && !p.is(tOp, "//") {
+			return filter, nil
+		}
+		path := &pathExpr{filter: filter}
+		return path, p.relative(path)
+	case t.kind == tOp && (t.s == "/" || t.s == "//"):
+		p.next()
+		path := &pathExpr{absolute: true}
+		if t.s == "//" {
+			path.steps = append(path.steps, descendantOrSelf())
+			return path, p.relativeSteps(path)
+		}
+		if p.startsStep() {
+			return path, p.relativeSteps(path)
+		}
+		return path, nil
+	}
+	if !p.startsStep() {
+		return nil, p.errorf("expected an expression")
+	}
+	path := &pathExpr{}
+	return path, p.relativeSteps(path)
+}
+
+// relative reads the / or // and the steps that follow a filter expression.
+func (p *parser) relative(path *pathExpr) error {
+	if p.next().s == "//" {
+		path.steps = append(path.steps, descendantOrSelf())
+	}
+	return p.relativeSteps(path)
+}
+
+func (p *parser) relativeSteps(path *pathExpr) error {
+	for {
+		s, err := p.step()
+		if err != nil {
+			return err
+		}
+		path.steps = append(path.steps, s)
+		switch {
+		case p.is(tOp, "/"):
+			p.next()
+		case p.is(tOp, "//"):
+			p.next()
+			path.steps = append(path.steps, descendantOrSelf())
+		default:
+			return nil
+		}
+	}
+}
+
+func descendantOrSelf() *step {
+	return &step{axis: axisDescendantOrSelf, test: nodeTest{kind: testNode}}
+}
+
+func (p *parser) startsStep() bool {
+	t := p.peek()
+	switch t.kind {
+	case tName, tNodeType, tAxis:
+		return true
+	case tPunct:
+		return t.s == "@" || t.s == "." || t.s == ".."
+	}
+	return false
+}
+
+func (p *parser) step() (*step, error) {
+	switch {
+	case p.is(tPunct, "."):
+		p.next()
+		return &step{axis: axisSelf, test: nodeTest{kind: testNode}}, nil
+	case p.is(tPunct, ".."):
+		p.next()
+		return &step{axis: axisParent, test: nodeTest{kind: testNode}}, nil
+	}
+
+	s := &step{axis: axisChild}
+	switch t := p.peek(); {
+	case t.kind == tPunct && t.s == "@":
+		p.next()
+		s.axis = axisAttribute
+	case t.kind == tAxis:
+		p.next()
+		a, ok := axes[t.s]
+		if !ok {
+			return nil, fmt.Errorf("position %d: unknown axis %s", t.pos, t.s)
+		}
+		if a == axisNamespace {
+			return nil, fmt.Errorf("position %d: the namespace axis is not supported", t.pos)
+		}
+		s.axis = a
+		if err := p.expect(tPunct, "::"); err != nil {
+			return nil, err
+		}
+	}
+
+	test, err := p.nodeTest()
+	if err != nil {
+		return nil, err
+	}
+	s.test = test
+	for p.is(tPunct, "[") {
+		pred, err := p.predicate()
+		if err != nil {
+			return nil, err
+		}
+		s.preds = append(s.preds, pred)
+	}
+	return s, nil
+}
+
+func (p *parser) nodeTest() (nodeTest, error) {
+	t := p.peek()
+	switch t.kind {
+	case tNodeType:
+		p.next()
+		if err := p.expect(tPunct, "("); err != nil {
+			return nodeTest{}, err
+		}
+		test := nodeTest{kind: nodeTypes[t.s]}
+		if t.s == "processing-instruction" && p.peek().kind == tLiteral {
+			test.local = p.next().s
+		}
+		return test, p.expect(tPunct, ")")
+	case tName:
+		p.next()
+		if t.s == "*" {
+			return nodeTest{kind: testAny}, nil
+		}
+		prefix, local, qualified := strings.Cut(t.s, ":")
+		if !qualified {
+			// An unprefixed name is in no namespace, whatever the
+			// document's default namespace is (XPath 1.0 §2.3).
+			return nodeTest{kind: testName, local: t.s}, nil
+		}
+		space, ok := p.namespaces[prefix]
+		if prefix == "xml" {
+			space, ok = tree.XMLNamespace, true
+		}
+		if !ok {
+			return nodeTest{}, fmt.Errorf("position %d: prefix %q is not bound to a namespace; add it to namespaces", t.pos, prefix)
+		}
+		if local == "*" {
+			return nodeTest{kind: testSpace, space: space}, nil
+		}
+		return nodeTest{kind: testName, space: space, local: local}, nil
+	}
+	return nodeTest{}, p.errorf("expected a node test")
+}
+
+func (p *parser) predicate() (expr, error) {
+	p.next()
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return x, p.expect(tPunct, "]")
+}
+
+func (p *parser) filter() (expr, error) {
+	primary, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(tPunct, "[") {
+		return primary, nil
+	}
+	f := &filterExpr{primary: primary}
+	for p.is(tPunct, "[") {
+		pred, err := p.predicate()
+		if err != nil {
+			return nil, err
+		}
+		f.preds = append(f.preds, pred)
+	}
+	return f, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tLiteral:
+		return literal{v: t.s}, nil
+	case tNumber:
+		return literal{v: t.n}, nil
+	case tVar:
+		return nil, fmt.Errorf("position %d: variables are not supported: $%s", t.pos, t.s)
+	case tFunc:
+		fn, ok := functions[t.s]
+		if !ok {
+			return nil, fmt.Errorf("position %d: unknown function %s()", t.pos, t.s)
+		}
+		if err := p.expect(tPunct, "("); err != nil {
+			return nil, err
+		}
+		call := &callExpr{name: t.s, fn: fn}
+		if !p.is(tPunct, ")") {
+			for {
+				arg, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if !p.is(tPunct, ",") {
+					break
+				}
+				p.next()
+			}
+		}
+		if err := p.expect(tPunct, ")"); err != nil {
+			return nil, err
+		}
+		if len(call.args) < fn.min || (fn.max >= 0 && len(call.args) > fn.max) {
+			return nil, fmt.Errorf("position %d: wrong number of arguments to %s()", t.pos, t.s)
+		}
+		return call, nil
+	case tPunct:
+		if t.s == "(" {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(tPunct, ")")
+		}
+	}
+	p.i--
+	return nil, p.errorf("expected an expression")
+}
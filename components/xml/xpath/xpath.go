@@ -0,0 +1,284 @@
+// Package xpath evaluates XPath 1.0 expressions over the tree document model.
+//
+// It covers the language as the recommendation defines it — every axis but
+// namespace, predicates, unions and the core function library — without
+// variables or extension functions, which a flow has no way to supply.
+// Prefixes in an expression are bound by the caller rather than taken from
+// the document, so a query keeps working when a partner renames its prefixes.
+package xpath
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+// Expr is a compiled expression, safe to evaluate concurrently.
+type Expr struct {
+	source string
+	root   expr
+}
+
+// Compile parses an expression. namespaces binds the prefixes it may use to
+// namespace URIs; an unprefixed name always means no namespace.
+func Compile(expression string, namespaces map[string]string) (*Expr, error) {
+	toks, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, namespaces: namespaces}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return &Expr{source: expression, root: root}, nil
+}
+
+// String is the expression as it was written.
+func (e *Expr) String() string {
+	return e.source
+}
+
+// The types an expression's result can have.
+const (
+	NodeSetType = "node-set"
+	StringType  = "string"
+	NumberType  = "number"
+	BooleanType = "boolean"
+)
+
+// Type is the type every evaluation of the expression returns, which XPath
+// 1.0 fixes by the expression's form alone.
+func (e *Expr) Type() string {
+	return resultType(e.root)
+}
+
+func resultType(x expr) string {
+	switch e := x.(type) {
+	case literal:
+		return typeName(e.v)
+	case *negateExpr:
+		return NumberType
+	case *binaryExpr:
+		switch e.op {
+		case "+", "-", "*", "div", "mod":
+			return NumberType
+		}
+		return BooleanType
+	case *callExpr:
+		return e.fn.returns
+	}
+	return NodeSetType
+}
+
+// Evaluate runs the expression with n as the context node. The result is a
+// []Node in document order, a string, a float64 or a bool.
+func (e *Expr) Evaluate(n *tree.Node) (any, error) {
+	doc := n
+	for doc.Parent != nil {
+		doc = doc.Parent
+	}
+	ev := &evaluator{order: map[*tree.Node]int{}}
+	ev.number(doc)
+	return e.root.eval(&context{ev: ev, node: Node{node: n, attr: -1}, pos: 1, size: 1})
+}
+
+// Node is a node an expression selected: a node of the tree, or one of an
+// element's attributes, which the tree keeps on the element rather than as
+// nodes of their own.
+type Node struct {
+	node *tree.Node
+	attr int
+}
+
+// Node is the tree node, or for an attribute the element holding it.
+func (n Node) Node() *tree.Node {
+	return n.node
+}
+
+// Attr is the attribute, when the node is one.
+func (n Node) Attr() (tree.Attr, bool) {
+	if n.attr < 0 {
+		return tree.Attr{}, false
+	}
+	return n.node.Attrs[n.attr], true
+}
+
+// Kind names the node's type as XPath does: root, element, attribute, text,
+// comment or processing-instruction.
+func (n Node) Kind() string {
+	if n.attr >= 0 {
+		return "attribute"
+	}
+	switch n.node.Kind {
+	case tree.DocumentNode:
+		return "root"
+	case tree.ElementNode:
+		return "element"
+	case tree.TextNode:
+		return "text"
+	case tree.CommentNode:
+		return "comment"
+	}
+	return "processing-instruction"
+}
+
+// Name is the node's name as written, prefix included; empty for nodes
+// without one.
+func (n Node) Name() string {
+	if a, ok := n.Attr(); ok {
+		return a.Name()
+	}
+	switch n.node.Kind {
+	case tree.ElementNode:
+		return n.node.Name()
+	case tree.ProcInstNode:
+		return n.node.Target
+	}
+	return ""
+}
+
+// LocalName is the name without its prefix.
+func (n Node) LocalName() string {
+	if a, ok := n.Attr(); ok {
+		return a.Local
+	}
+	switch n.node.Kind {
+	case tree.ElementNode:
+		return n.node.Local
+	case tree.ProcInstNode:
+		return n.node.Target
+	}
+	return ""
+}
+
+// Namespace is the namespace URI of an element or attribute.
+func (n Node) Namespace() string {
+	if a, ok := n.Attr(); ok {
+		return a.Space
+	}
+	if n.node.Kind == tree.ElementNode {
+		return n.node.Space
+	}
+	return ""
+}
+
+// Text is the node's string-value: an attribute's value, or the text of the
+// node and everything under it.
+func (n Node) Text() string {
+	if a, ok := n.Attr(); ok {
+		return a.Value
+	}
+	return n.node.Text()
+}
+
+// String converts a result as XPath's string() does: the first node's text
+// for a node-set, and a number without exponent or needless decimals.
+func String(v any) string {
+	switch val := v.(type) {
+	case []Node:
+		if len(val) == 0 {
+			return ""
+		}
+		return val[0].Text()
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		switch {
+		case math.IsNaN(val):
+			return "NaN"
+		case math.IsInf(val, 1):
+			return "Infinity"
+		case math.IsInf(val, -1):
+			return "-Infinity"
+		case val == 0:
+			// Negative zero too.
+			return "0"
+		}
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	return ""
+}
+
+// Number converts a result as XPath's number() does. Text that is not a
+// plain decimal number, exponents included, is NaN.
+func Number(v any) float64 {
+	switch val := v.(type) {
+	case float64:
+		return val
+	case bool:
+		if val {
+			return 1
+		}
+		return 0
+	}
+	s := strings.Trim(String(v), " \t\r\n")
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || digits == "." || strings.Trim(digits, "0123456789.") != "" || strings.Count(digits, ".") > 1 {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// Boolean converts a result as XPath's boolean() does.
+func Boolean(v any) bool {
+	switch val := v.(type) {
+	case []Node:
+		return len(val) > 0
+	case string:
+		return val != ""
+	case float64:
+		return val != 0 && !math.IsNaN(val)
+	case bool:
+		return val
+	}
+	return false
+}
+
+// evaluator holds what one evaluation needs across the expression: every
+// node's position in document order, which node-sets are sorted by.
+type evaluator struct {
+	order map[*tree.Node]int
+}
+
+func (ev *evaluator) number(n *tree.Node) {
+	ev.order[n] = len(ev.order)
+	for _, c := range n.Children {
+		ev.number(c)
+	}
+}
+
+// before reports whether a comes before b in document order. An element's
+// attributes come after it and before its children.
+func (ev *evaluator) before(a, b Node) bool {
+	if oa, ob := ev.order[a.node], ev.order[b.node]; oa != ob {
+		return oa < ob
+	}
+	return a.attr < b.attr
+}
+
+// sorted puts nodes in document order without duplicates.
+func (ev *evaluator) sorted(nodes []Node) []Node {
+	sort.Slice(nodes, func(i, j int) bool {
+		return ev.before(nodes[i], nodes[j])
+	})
+	out := nodes[:0]
+	for _, n := range nodes {
+		if len(out) == 0 || n != out[len(out)-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,242 @@
+package xpath
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tiny-systems/encoding-module/components/xml/tree"
+)
+
+const catalog = `<?xml version="1.0"?>
+<catalog xmlns:p="urn:price" xml:lang="en-GB">
+  <!-- stock -->
+  <book id="b1" year="2001"><title>Go</title><p:price>10.50</p:price></book>
+  <book id="b2" year="1999"><title>XML</title><p:price>7</p:price></book>
+  <book id="b3"><title></title></book>
+  <?render fast?>
+</catalog>`
+
+func eval(t *testing.T, doc, expression string, namespaces map[string]string) any {
+	t.Helper()
+	root, err := tree.Parse([]byte(doc), tree.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Compile(expression, namespaces)
+	if err != nil {
+		t.Fatalf("compile %s: %v", expression, err)
+	}
+	v, err := e.Evaluate(root)
+	if err != nil {
+		t.Fatalf("evaluate %s: %v", expression, err)
+	}
+	return v
+}
+
+// texts joins the string-values of a node-set, to compare it at a glance.
+func texts(v any) string {
+	var out []string
+	for _, n := range v.([]Node) {
+		out = append(out, n.Kind()+":"+n.Text())
+	}
+	return strings.Join(out, "|")
+}
+
+func TestNodeSets(t *testing.T) {
+	ns := map[string]string{"q": "urn:price"}
+	for _, tc := range []struct{ expression, want string }{
+		{"/catalog/book/title", "element:Go|element:XML|element:"},
+		{"//title/text()", "text:Go|text:XML"},
+		{"//book[2]/title", "element:XML"},
+		{"//book[last()]/@id", "attribute:b3"},
+		{"//book[@year > 2000]/title", "element:Go"},
+		{"//book[q:price = 7]/@id", "attribute:b2"},
+		{"//q:price", "element:10.50|element:7"},
+		{"//book[not(@year)]/@id", "attribute:b3"},
+		{"//book/@*", "attribute:b1|attribute:2001|attribute:b2|attribute:1999|attribute:b3"},
+		{"//title[. = 'XML']/../@id", "attribute:b2"},
+		{"//book[title = 'XML']/preceding-sibling::book/@id", "attribute:b1"},
+		{"//book[3]/preceding-sibling::book[1]/@id", "attribute:b2"},
+		{"//q:price[1]/ancestor::*[last()]/@xml:lang", "attribute:en-GB"},
+		{"//book[1]/following::title", "element:XML|element:"},
+		{"(//title)[position() > 1]", "element:XML|element:"},
+		{"//book[@id='b3'] | //book[@id='b1']", "element:Go10.50|element:"},
+		{"/comment()", ""},
+		{"/catalog/comment()", "comment: stock "},
+		{"//processing-instruction('render')", "processing-instruction:fast"},
+		{"//missing", ""},
+	} {
+		v := eval(t, catalog, tc.expression, ns)
+		if got := texts(v); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.expression, got, tc.want)
+		}
+	}
+}
+
+func TestScalars(t *testing.T) {
+	ns := map[string]string{"q": "urn:price"}
+	for _, tc := range []struct {
+		expression string
+		want       any
+	}{
+		{"count(//book)", 3.0},
+		{"sum(//q:price)", 17.5},
+		{"string(//book[2]/@year)", "1999"},
+		{"//book[1]/@year + 1", 2002.0},
+		{"concat(//title, '-', //book[2]/title)", "Go-XML"},
+		{"normalize-space('  a \n b ')", "a b"},
+		{"substring('12345', 1.5, 2.6)", "234"},
+		{"substring('12345', 0 div 0, 3)", ""},
+		{"substring-before('2001-07', '-')", "2001"},
+		{"substring-after('2001-07', '-')", "07"},
+		{"translate('bar', 'abc', 'AB')", "BAr"},
+		{"string-length(//book[1]/title)", 2.0},
+		{"round(-0.5)", math.Copysign(0, -1)},
+		{"round(2.5)", 3.0},
+		{"floor(-1.5) + ceiling(1.2)", 0.0},
+		{"7 mod 3 * 2 div 4", 0.5},
+		{"-(1 - 3)", 2.0},
+		{"lang('en')", false},
+		{"boolean(//book[1][lang('EN')])", true},
+		{"local-name(//q:price)", "price"},
+		{"name(//q:price)", "p:price"},
+		{"namespace-uri(//q:price)", "urn:price"},
+		{"//book[1]/@id = 'b1' and not(//missing)", true},
+		{"//book/@id = //missing", false},
+		{"//book/@id != 'b1'", true},
+		{"starts-with(//title, 'G') or 1 div 0", true},
+		{"string(1 div 0)", "Infinity"},
+		{"string(0.1 + 0.2 = 0.3)", "false"},
+		{"number('1e3')", math.NaN()},
+		{"string(//book[3]/title)", ""},
+	} {
+		got := eval(t, catalog, tc.expression, ns)
+		if f, ok := tc.want.(float64); ok && math.IsNaN(f) {
+			if g, ok := got.(float64); !ok || !math.IsNaN(g) {
+				t.Errorf("%s = %#v, want NaN", tc.expression, got)
+			}
+			continue
+		}
+		if f, ok := tc.want.(float64); ok && f == 0 && math.Signbit(f) {
+			if g, ok := got.(float64); !ok || g != 0 || !math.Signbit(g) {
+				t.Errorf("%s = %#v, want -0", tc.expression, got)
+			}
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s = %#v, want %#v", tc.expression, got, tc.want)
+		}
+	}
+}
+
+// An unprefixed name means no namespace even under a default one — the rule
+// that makes //Body find nothing in a SOAP response, so it is pinned here.
+func TestDefaultNamespaceNeedsAPrefix(t *testing.T) {
+	doc := `<feed xmlns="http://www.w3.org/2005/Atom"><title>t</title></feed>`
+	if got := texts(eval(t, doc, "/feed/title", nil)); got != "" {
+		t.Fatalf("unprefixed name matched %q", got)
+	}
+	if got := texts(eval(t, doc, "/a:feed/a:title", map[string]string{"a": "http://www.w3.org/2005/Atom"})); got != "element:t" {
+		t.Fatalf("bound prefix = %q", got)
+	}
+	if got := texts(eval(t, doc, "//*[local-name() = 'title']", nil)); got != "element:t" {
+		t.Fatalf("local-name() = %q", got)
+	}
+}
+
+// Names and operators share spellings; the lexer tells them apart by what
+// precedes them.
+func TestOperatorNames(t *testing.T) {
+	doc := `<r><div>6</div><mod>4</mod><and>1</and></r>`
+	if got := eval(t, doc, "/r/div div /r/mod", nil); got != 1.5 {
+		t.Fatalf("div = %#v", got)
+	}
+	if got := eval(t, doc, "count(/r/*) * 2", nil); got != 6.0 {
+		t.Fatalf("* = %#v", got)
+	}
+	if got := eval(t, doc, "/r/and and /r/mod mod 3", nil); got != true {
+		t.Fatalf("and = %#v", got)
+	}
+}
+
+func TestEvaluateFromANode(t *testing.T) {
+	root, err := tree.Parse([]byte(catalog), tree.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := root.Root().Elements()[1]
+	for expression, want := range map[string]string{
+		"title":          "element:XML",
+		"/catalog/@*":    "attribute:en-GB",
+		"../book[1]/@id": "attribute:b1",
+	} {
+		e, err := Compile(expression, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := e.Evaluate(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := texts(v); got != want {
+			t.Errorf("%s = %q, want %q", expression, got, want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for expression, want := range map[string]string{
+		"//p:price":         `prefix "p" is not bound`,
+		"//book[":           "expected an expression",
+		"count(//a, //b)":   "wrong number of arguments",
+		"frobnicate()":      "unknown function",
+		"$total":            "variables are not supported",
+		"namespace::*":      "namespace axis is not supported",
+		"sideways::a":       "unknown axis",
+		"'open":             "unterminated string",
+		"//a b":             "expected an operator",
+		"/a/b and":          "expected an expression",
+		"concat('a', 'b'))": "unexpected token",
+	} {
+		_, err := Compile(expression, nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", expression, err, want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	root, _ := tree.Parse([]byte(catalog), tree.ParseOptions{})
+	for _, expression := range []string{"count('a')", "'a' | //b", "'a'/b", "sum(1)"} {
+		e, err := Compile(expression, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", expression, err)
+		}
+		if _, err := e.Evaluate(root); err == nil || !strings.Contains(err.Error(), "node-set") {
+			t.Errorf("%s: err = %v", expression, err)
+		}
+	}
+}
+
+func TestType(t *testing.T) {
+	for expression, want := range map[string]string{
+		"//a | //b":        NodeSetType,
+		"(//a)[1]/b":       NodeSetType,
+		"count(//a)":       NumberType,
+		"-'1'":             NumberType,
+		"//a = 1":          BooleanType,
+		"not(//a) or //b":  BooleanType,
+		"concat('a', //b)": StringType,
+		"'a'":              StringType,
+		"sum(//a) div 2":   NumberType,
+	} {
+		e, err := Compile(expression, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type() != want {
+			t.Errorf("%s: type %s, want %s", expression, e.Type(), want)
+		}
+	}
+}